@@ -0,0 +1,122 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var urls = flag.Bool("urls", true, "print the URLs of the ports the program listens on after each start")
+
+// detected holds the port the program was found listening on, for --proxy,
+// --pprof-proxy and --pre-restart-profile.
+// --app-port given on the command line turns detection off.
+var detected struct {
+	sync.Mutex
+	port    string
+	sockets bool // found among the sockets of the program, not its output
+}
+
+// appPort returns the port the proxy forwards to.
+func appPort() string {
+	detected.Lock()
+	defer detected.Unlock()
+	if detected.port != "" {
+		return detected.port
+	}
+	return *app_port
+}
+
+func detecting() bool {
+	return (*proxy != "" || *pprof_proxy != "" || *pre_restart_profile) && !flagSet("app-port")
+}
+
+// setPort records the detected port, unless it belongs to rerun itself.
+func setPort(port string, sockets bool) {
+	for _, addr := range []string{*proxy, *http_addr, *pprof_proxy} {
+		if _, own, err := net.SplitHostPort(addr); err == nil && own == port {
+			return
+		}
+	}
+	detected.Lock()
+	if detected.sockets && !sockets {
+		detected.Unlock()
+		return
+	}
+	changed := detected.port != port
+	detected.port, detected.sockets = port, sockets
+	detected.Unlock()
+	if changed {
+		log("the program listens on port %s", port)
+	}
+}
+
+// detectPort looks for the listening sockets of the program started as
+// pid, for a while after the start, to forward --proxy to it and to print
+// its URLs.
+func detectPort(pid int) {
+	if !runner.local() || (!detecting() && !*urls) {
+		return
+	}
+	var ports []string
+	for i := 0; i < 50 && len(ports) == 0; i++ {
+		time.Sleep(200 * time.Millisecond)
+		ports = listenPorts(pid)
+	}
+	if len(ports) == 0 {
+		return
+	}
+	if detecting() {
+		setPort(ports[0], true)
+	}
+	if !*urls {
+		return
+	}
+
+	// Servers often open their ports one after the other.
+	time.Sleep(500 * time.Millisecond)
+	if more := listenPorts(pid); len(more) > len(ports) {
+		ports = more
+	}
+	launchMu.Lock()
+	current := childPid == pid
+	launchMu.Unlock()
+	if current {
+		for _, port := range ports {
+			log("listening on http://localhost:%s", port)
+		}
+	}
+}
+
+// listenRe matches log lines such as "listening on :8080", "serving
+// http://localhost:3000/" or "running on port 4000". The port follows a
+// host, an address or nothing, or the word port, so the times and the
+// fields such as "pid:1234" of the line don't count.
+var listenRe = regexp.MustCompile(`(?i)(?:listen|serv|start|running).*?(?:[\s/@("'=](?:localhost|\d{1,3}(?:\.\d{1,3}){3}|\[[0-9a-f:.]*\]|[a-z][a-z0-9-]*(?:\.[a-z0-9-]+)+)?:(\d{2,5})|\bport\s*[=:]?\s*(\d{2,5}))(?:$|[^\d:])`)
+
+// portScanner reads the output of the program for the address it listens
+// on, where its sockets can't be inspected.
+type portScanner struct {
+	partial string
+}
+
+func (s *portScanner) Write(p []byte) (int, error) {
+	lines := strings.Split(s.partial+string(p), "\n")
+	s.partial = lines[len(lines)-1]
+	if len(s.partial) > 4096 {
+		s.partial = ""
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if m := listenRe.FindStringSubmatch(line); m != nil {
+			setPort(m[1]+m[2], false)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listenPorts returns the TCP ports that pid, or one of its descendants
+// such as a program started by --run-cmd, listens on.
+func listenPorts(pid int) []string {
+	listening := map[string]string{} // socket inode to port
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n")[1:] {
+			f := strings.Fields(line)
+			if len(f) < 10 || f[3] != "0A" {
+				continue
+			}
+			i := strings.LastIndexByte(f[1], ':')
+			if port, err := strconv.ParseUint(f[1][i+1:], 16, 16); err == nil {
+				listening[f[9]] = strconv.FormatUint(port, 10)
+			}
+		}
+	}
+
+	var ports []string
+	seen := map[string]bool{}
+	for _, p := range descendants(pid) {
+		fds, _ := filepath.Glob("/proc/" + strconv.Itoa(p) + "/fd/*")
+		for _, fd := range fds {
+			link, err := os.Readlink(fd)
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if port, ok := listening[inode]; ok && !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// descendants returns pid and all the processes below it, from the
+// children of its threads where the kernel lists them, or else from the
+// parents of all the processes.
+func descendants(pid int) []int {
+	all := []int{pid}
+	for i := 0; i < len(all); i++ {
+		tasks, _ := filepath.Glob("/proc/" + strconv.Itoa(all[i]) + "/task/*/children")
+		if len(tasks) == 0 {
+			if i == 0 && exists("/proc/"+strconv.Itoa(pid)) {
+				return scanDescendants(pid)
+			}
+			continue
+		}
+		for _, name := range tasks {
+			b, _ := ioutil.ReadFile(name)
+			for _, f := range strings.Fields(string(b)) {
+				if p, err := strconv.Atoi(f); err == nil {
+					all = append(all, p)
+				}
+			}
+		}
+	}
+	return all
+}
+
+// scanDescendants is descendants for the kernels without the children
+// files: it reads the stat of every process.
+func scanDescendants(pid int) []int {
+	children := map[int][]int{}
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	for _, name := range stats {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		s := string(b)
+		f := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+		if len(f) < 2 {
+			continue
+		}
+		p, _ := strconv.Atoi(filepath.Base(filepath.Dir(name)))
+		ppid, _ := strconv.Atoi(f[1])
+		children[ppid] = append(children[ppid], p)
+	}
+
+	all := []int{pid}
+	for i := 0; i < len(all); i++ {
+		all = append(all, children[all[i]]...)
+	}
+	return all
+}
@@ -0,0 +1,35 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// listenPorts asks lsof for the TCP ports pid listens on. Without lsof, the
+// port for --proxy is taken from the output of the program instead.
+func listenPorts(pid int) []string {
+	out, err := exec.Command("lsof", "-nP", "-a", "-p", strconv.Itoa(pid), "-iTCP", "-sTCP:LISTEN", "-Fn").Output()
+	if err != nil {
+		return nil
+	}
+	var ports []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "n") {
+			continue
+		}
+		port := line[strings.LastIndexByte(line, ':')+1:]
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Tuning of the watch backends. Small trees want a short latency, huge
+// ones fewer watches.
+var (
+	poll_interval = flag.Duration("poll-interval", 500*time.Millisecond, "how often the polling backend walks the tree")
+	event_latency = flag.Duration("event-latency", 100*time.Millisecond, "how long the event backend waits for more events before it reports a batch")
+	max_watches   = flag.Int("max-watches", 0, "watches the event backend takes at most, the rest is polled: one per directory with inotify, per directory and file with kqueue; 0 for the limit of the system")
+	inotify_mask  = flag.String("inotify-events", "modify,attrib,create,delete,move", "comma-separated events that count as changes, of inotify or the matching kqueue ones: modify, attrib, create, delete, move")
+)
+
+// inotifyEvents are the names --inotify-events takes.
+var inotifyEvents = []string{"modify", "attrib", "create", "delete", "move"}
+
+// checkBackend validates the tuning flags.
+func checkBackend() error {
+	if *poll_interval < 10*time.Millisecond {
+		return fmt.Errorf("--poll-interval %s is too short", *poll_interval)
+	}
+	if *event_latency < 0 {
+		return fmt.Errorf("--event-latency %s is negative", *event_latency)
+	}
+	names := splitList(*inotify_mask)
+	if len(names) == 0 {
+		return fmt.Errorf("--inotify-events: no events")
+	}
+	for _, name := range names {
+		known := false
+		for _, e := range inotifyEvents {
+			known = known || e == name
+		}
+		if !known {
+			return fmt.Errorf("--inotify-events: unknown event %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	bazel_target = flag.String("bazel", "", "build and run this Bazel target, e.g. //cmd/server, instead of a Go package")
+	bazel_cmd    = flag.String("bazel-cmd", "bazel", "the bazel command, e.g. bazelisk")
+)
+
+// rerunBazel builds label with bazel build in every cycle and runs the
+// binary bazel produced. The whole workspace is watched, but bazel's
+// convenience symlinks.
+func rerunBazel(label string, args []string) error {
+	root, err := bazelOutput("info", "workspace")
+	if err != nil {
+		return err
+	}
+	bin, err := bazelBinary(root, label)
+	if err != nil {
+		return err
+	}
+	log("bazel workspace %s, %s builds %s", root, label, bin)
+
+	if *do_tests || *do_vet || *do_build {
+		log("--test, --vet and --build don't apply with --bazel")
+		*do_tests, *do_vet, *do_build = false, false, false
+	}
+	install = bazelBuild
+
+	t := &target{buildpath: label, bin: bin, dir: root}
+	if err = setupChain(root); err != nil {
+		return err
+	}
+	if err = startFixtures(); err != nil {
+		return err
+	}
+
+	ch := make(chan bool)
+	go run(ch, t.bin, t.dir, args)
+
+	initialCycle(t, ch)
+
+	watchTree(t.dir, t, ch)
+	return nil
+}
+
+// bazelBinary asks bazel for the executable of label.
+func bazelBinary(root, label string) (string, error) {
+	out, err := bazelOutput("cquery", "--output=files", label)
+	if err != nil {
+		return "", err
+	}
+	files := strings.Fields(out)
+	if len(files) == 0 {
+		return "", fmt.Errorf("bazel: %s has no output files", label)
+	}
+	bin := files[len(files)-1]
+	if !filepath.IsAbs(bin) {
+		bin = filepath.Join(root, bin)
+	}
+	return bin, nil
+}
+
+func bazelBuild(label string) (bool, error) {
+	cmd := exec.Command(*bazel_cmd, "build", label)
+	recordCommand("install", cmd)
+
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+
+	if err := cmd.Run(); err != nil {
+		log("bazel build failed")
+		reportFailure("build", buf.String())
+		return false, err
+	}
+
+	reportSuccess("build")
+	log("bazel build succeeded")
+	return true, nil
+}
+
+// bazelOutput runs bazel and returns its trimmed output.
+func bazelOutput(args ...string) (string, error) {
+	cmd := exec.Command(*bazel_cmd, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %s", *bazel_cmd, args[0], msg)
+		}
+		return "", fmt.Errorf("%s %s: %s", *bazel_cmd, args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// bazelReason leaves out the bazel-* symlinks of the workspace root, which
+// bazel replaces on every build.
+func bazelReason(root, p string) string {
+	if *bazel_target == "" || filepath.Dir(p) != root || !strings.HasPrefix(filepath.Base(p), "bazel-") {
+		return ""
+	}
+	return "bazel output"
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var on_branch_switch = flag.String("on-branch-switch", "", "shell command run when the git checkout switches to another branch or commit, before the full rebuild that follows, e.g. \"go mod download && make generate\"")
+
+// branch follows the HEAD of the git repository of the watched tree. A
+// switch turns the changes it brings into one full rebuild, rather than
+// an edit of thousands of files.
+var branch struct {
+	sync.Mutex
+	file     string // HEAD of the repository or worktree
+	head     string // its contents: a ref, or a commit when detached
+	switched bool   // a switch the next cycle hasn't handled yet
+}
+
+// watchBranch starts following the HEAD of the repository of dir, if it is
+// in one.
+func watchBranch(dir string) {
+	gitDir, err := git(dir, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return
+	}
+	branch.Lock()
+	defer branch.Unlock()
+	branch.file = filepath.Join(gitDir, "HEAD")
+	branch.head = readHead(branch.file)
+}
+
+func readHead(file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// branchSwitch reports whether HEAD moved to another branch or commit
+// since the last call, and says so. A commit on the branch doesn't count.
+func branchSwitch() bool {
+	branch.Lock()
+	defer branch.Unlock()
+	if branch.file == "" {
+		return false
+	}
+	head := readHead(branch.file)
+	if head == "" || head == branch.head {
+		return false
+	}
+	log("switched from %s to %s, running a full cycle", headName(branch.head), headName(head))
+	branch.head = head
+	branch.switched = true
+	return true
+}
+
+// takeBranchSwitch reports whether the cycle follows a switch.
+func takeBranchSwitch() bool {
+	branch.Lock()
+	defer branch.Unlock()
+	s := branch.switched
+	branch.switched = false
+	return s
+}
+
+// headName names the contents of HEAD, as in "main" or "commit 0a1b2c3d4e5f".
+func headName(head string) string {
+	if ref := strings.TrimPrefix(head, "ref: "); ref != head {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return "commit " + shortHash(head)
+}
+
+// runBranchHook runs --on-branch-switch.
+func runBranchHook(buildpath string) (bool, error) {
+	if err := runShell(*on_branch_switch); err != nil {
+		log("branch switch hook failed")
+		fmt.Fprintln(console, err)
+		return false, err
+	}
+	return true, nil
+}
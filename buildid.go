@@ -0,0 +1,70 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// The build ID counts the cycles, the build hash identifies the contents of
+// the binary. Together they tell clients that the program was rebuilt
+// since they last talked to it.
+var (
+	buildMu   sync.Mutex
+	buildID   int
+	buildHash string
+)
+
+// nextBuild starts the build of a new cycle and returns its ID.
+func nextBuild() int {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	buildID++
+	return buildID
+}
+
+// hashBuild records the hash of the binary built by the current cycle.
+func hashBuild(bin string) string {
+	f, err := os.Open(bin)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	full := hex.EncodeToString(h.Sum(nil))
+	if *repro {
+		// In the format of sha256sum, to compare.
+		log("repro: %s  %s", full, filepath.Base(bin))
+	}
+	sum := full[:12]
+
+	buildMu.Lock()
+	buildHash = sum
+	buildMu.Unlock()
+	return sum
+}
+
+// buildEnv exports the build ID and hash to hooks and the program.
+func buildEnv() []string {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	if buildID == 0 {
+		return nil
+	}
+	return []string{
+		"RERUN_BUILD_ID=" + strconv.Itoa(buildID),
+		"RERUN_BUILD_HASH=" + buildHash,
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var keep_builds = flag.Int("keep-builds", 0, "keep this many previous binaries in .rerun/builds to roll back to")
+
+// kept holds the copies of the recent binaries of the program, the newest
+// last. back counts how many builds the running program is behind it.
+var kept struct {
+	sync.Mutex
+	bin  string
+	ids  []int
+	bins []string
+	back int
+}
+
+// keepBuild copies the binary of the successful cycle id, and forgets the
+// oldest copies beyond --keep-builds. The next start runs the new build.
+func keepBuild(bin string, id int) {
+	if *keep_builds <= 0 || bin == "" {
+		return
+	}
+
+	kept.Lock()
+	defer kept.Unlock()
+
+	dir := statePath("builds")
+	if kept.bins == nil {
+		os.RemoveAll(dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log("--keep-builds: %s", err)
+		return
+	}
+	dst := filepath.Join(dir, fmt.Sprintf("%d-%s", id, filepath.Base(bin)))
+	if err := copyFile(dst, bin); err != nil {
+		log("--keep-builds: %s", err)
+		return
+	}
+
+	kept.bin = bin
+	kept.ids = append(kept.ids, id)
+	kept.bins = append(kept.bins, dst)
+	kept.back = 0
+	for len(kept.bins) > *keep_builds+1 {
+		os.Remove(kept.bins[0])
+		kept.ids, kept.bins = kept.ids[1:], kept.bins[1:]
+	}
+}
+
+// rollback starts the build before the running one again.
+func rollback() error {
+	kept.Lock()
+	n := len(kept.bins) - 1 - (kept.back + 1)
+	if n < 0 {
+		kept.Unlock()
+		return errors.New("no older build kept, see --keep-builds")
+	}
+	kept.back++
+	id := kept.ids[n]
+	kept.Unlock()
+
+	log("rolling back to build %d", id)
+	restart()
+	return nil
+}
+
+// runBinary returns the binary to start for bin: a kept copy after a
+// rollback, bin itself otherwise.
+func runBinary(bin string) string {
+	kept.Lock()
+	defer kept.Unlock()
+	if kept.back == 0 || bin != kept.bin {
+		return bin
+	}
+	return kept.bins[len(kept.bins)-1-kept.back]
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,267 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	record_casts = flag.String("record-casts", "", "record the output of every run of the program as an asciinema cast in this directory, to replay it with asciinema play")
+	keep_casts   = flag.Int("keep-casts", 20, "keep this many --record-casts recordings, removing the oldest; 0 keeps them all")
+)
+
+// castRe matches the names of the casts rerun writes, which sort by time.
+var castRe = regexp.MustCompile(`^\d{8}-\d{6}-build\d+(-\d+)?\.cast$`)
+
+// A castWriter records the output of one run of the program in the
+// asciicast v2 format: a JSON header, then one [time, "o", text] line per
+// write, stdout and stderr interleaved as they came.
+type castWriter struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	streams []*castStream
+}
+
+// A castStream is stdout or stderr of a cast.
+type castStream struct {
+	c       *castWriter
+	cr      bool   // the last byte written was a carriage return
+	pending []byte // incomplete UTF-8 sequence at the end of the last write
+}
+
+var casts struct {
+	sync.Mutex
+	cur    *castWriter
+	atExit bool
+}
+
+// startCast ends the recording of the last run and starts one for the run
+// about to start. It is nil without --record-casts.
+func startCast() *castWriter {
+	if *record_casts == "" {
+		return nil
+	}
+	endCast()
+	if err := os.MkdirAll(*record_casts, 0755); err != nil {
+		log("--record-casts: %s", err)
+		return nil
+	}
+
+	buildMu.Lock()
+	id := buildID
+	buildMu.Unlock()
+	now := time.Now()
+	base := fmt.Sprintf("%s-build%d", now.Format("20060102-150405"), id)
+	var f *os.File
+	var err error
+	for n := 1; ; n++ {
+		name := base + ".cast"
+		if n > 1 {
+			name = fmt.Sprintf("%s-%d.cast", base, n)
+		}
+		f, err = os.OpenFile(filepath.Join(*record_casts, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		log("--record-casts: %s", err)
+		return nil
+	}
+
+	sess.Lock()
+	title := strings.Join(append([]string{"rerun", sess.info.Target}, programArgs...), " ")
+	sess.Unlock()
+	width, height := castSize()
+	header := struct {
+		Version   int               `json:"version"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Timestamp int64             `json:"timestamp"`
+		Title     string            `json:"title"`
+		Env       map[string]string `json:"env,omitempty"`
+	}{2, width, height, now.Unix(), fmt.Sprintf("%s, build %d", title, id), map[string]string{}}
+	for _, k := range []string{"TERM", "SHELL"} {
+		if v := os.Getenv(k); v != "" {
+			header.Env[k] = v
+		}
+	}
+	b, _ := json.Marshal(header)
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log("--record-casts: %s", err)
+		f.Close()
+		return nil
+	}
+	pruneCasts()
+
+	c := &castWriter{f: f, start: now}
+	casts.Lock()
+	casts.cur = c
+	if !casts.atExit {
+		casts.atExit = true
+		atExit(endCast)
+	}
+	casts.Unlock()
+	return c
+}
+
+// endCast closes the recording of the current run, if any.
+func endCast() {
+	casts.Lock()
+	c := casts.cur
+	casts.cur = nil
+	casts.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// castReason leaves the directory of the casts out of the watch, when it
+// is in the tree.
+func castReason(p string) string {
+	if *record_casts == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(*record_casts)
+	if abs, perr := filepath.Abs(p); err == nil && perr == nil && abs == dir {
+		return "--record-casts writes there"
+	}
+	return ""
+}
+
+// castSize is the size of the terminal rerun runs in, for the player.
+func castSize() (width, height int) {
+	if w, h, ok := terminalSize(os.Stdout); ok {
+		return w, h
+	}
+	width, height = 80, 24
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		height = n
+	}
+	return
+}
+
+// pruneCasts removes the oldest casts beyond --keep-casts.
+func pruneCasts() {
+	if *keep_casts <= 0 {
+		return
+	}
+	infos, err := ioutil.ReadDir(*record_casts)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && castRe.MatchString(fi.Name()) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > *keep_casts {
+		os.Remove(filepath.Join(*record_casts, names[0]))
+		names = names[1:]
+	}
+}
+
+// tee returns w, writing to the cast too.
+func (c *castWriter) tee(w io.Writer) io.Writer {
+	if c == nil {
+		return w
+	}
+	cs := &castStream{c: c}
+	c.mu.Lock()
+	c.streams = append(c.streams, cs)
+	c.mu.Unlock()
+	return io.MultiWriter(w, cs)
+}
+
+// Write records p as an output event. Line feeds become CRLF, as a
+// terminal shows them, and a rune split between writes waits for its end.
+func (cs *castStream) Write(p []byte) (int, error) {
+	c := cs.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return len(p), nil
+	}
+
+	data := append([]byte(nil), cs.pending...)
+	for _, b := range p {
+		if b == '\n' && !cs.cr {
+			data = append(data, '\r')
+		}
+		data = append(data, b)
+		cs.cr = b == '\r'
+	}
+	cut := utf8Cut(data)
+	cs.pending = data[cut:]
+	if cut > 0 {
+		c.event(string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// event writes an output event. A failed write ends the recording, not
+// the output of the program.
+func (c *castWriter) event(s string) {
+	t := math.Round(time.Since(c.start).Seconds()*1e6) / 1e6
+	b, _ := json.Marshal([]interface{}{t, "o", s})
+	if _, err := c.f.Write(append(b, '\n')); err != nil {
+		log("--record-casts: %s", err)
+		c.f.Close()
+		c.f = nil
+	}
+}
+
+func (c *castWriter) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return
+	}
+	for _, cs := range c.streams {
+		if len(cs.pending) > 0 && c.f != nil {
+			c.event(string(cs.pending))
+			cs.pending = nil
+		}
+	}
+	if c.f != nil {
+		c.f.Close()
+		c.f = nil
+	}
+}
+
+// utf8Cut returns the length of b without an incomplete UTF-8 sequence at
+// its end.
+func utf8Cut(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
@@ -0,0 +1,32 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestUTF8Cut(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"é", 2},
+		{"a\xc3", 1},
+		{"€"[:2], 0},
+		{"a€"[:3], 1},
+		{"a€", 4},
+		{"🙂"[:3], 0},
+		{"x🙂", 5},
+		// Bytes that never make a rune aren't held back.
+		{"a\xff", 2},
+		{"\x80\x80\x80\x80\x80", 5},
+	}
+	for _, tt := range tests {
+		if got := utf8Cut([]byte(tt.in)); got != tt.want {
+			t.Errorf("utf8Cut(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var chain listFlag
+
+func init() {
+	flag.Var(&chain, "chain", "a main package, with arguments, built and run to completion before the program whenever its sources change, e.g. './cmd/codegen -out gen' (repeatable, in order)")
+}
+
+// A link is a generator that runs before the program.
+type link struct {
+	spec string
+	args []string
+	t    *target
+	ran  bool // ran successfully since its sources last changed
+}
+
+var links []*link
+
+// setupChain resolves the --chain packages. Their sources should be inside
+// the watched tree so that their changes start a cycle.
+func setupChain(root string) error {
+	for _, spec := range chain {
+		words, err := splitWords(spec)
+		if err != nil || len(words) == 0 {
+			return fmt.Errorf("--chain %q: %v", spec, err)
+		}
+		t, err := resolveMain(words[0])
+		if err != nil {
+			return fmt.Errorf("--chain %s: %s", words[0], err)
+		}
+		abs, _ := filepath.Abs(root)
+		if rel, err := filepath.Rel(abs, t.dir); err != nil || strings.HasPrefix(rel, "..") {
+			log("--chain %s: %s is outside the watched tree, set --watch to a directory holding both", words[0], t.dir)
+		}
+		links = append(links, &link{spec: words[0], args: words[1:], t: t})
+	}
+	return nil
+}
+
+// runChain builds and runs the generators whose sources changed, or that
+// haven't succeeded yet, in order. After one runs, the following ones run
+// too since they may read its output.
+func runChain(changed []string) (bool, error) {
+	stale := false
+	for _, l := range links {
+		for _, p := range changed {
+			if p, err := filepath.Abs(p); err == nil && strings.HasPrefix(p, l.t.dir+string(filepath.Separator)) {
+				l.ran = false
+			}
+		}
+		if l.ran && !stale {
+			continue
+		}
+		stale = true
+
+		if out, err := installPackage(l.t.buildpath); err != nil {
+			log("chain: %s failed to build", l.spec)
+			reportFailure("chain", out)
+			return false, err
+		}
+		cmd := exec.Command(l.t.bin, l.args...)
+		cmd.Env = environ()
+		var buf bytes.Buffer
+		cmd.Stdout = &buf
+		cmd.Stderr = &buf
+		if err := cmd.Run(); err != nil {
+			log("chain: %s: %s", l.spec, err)
+			reportFailure("chain", buf.String())
+			return false, err
+		}
+		if buf.Len() > 0 {
+			fmt.Fprint(console, buf.String())
+		}
+		l.ran = true
+		log("chain: ran %s", l.spec)
+	}
+	reportSuccess("chain")
+	return true, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	cleanup_files   listFlag
+	cleanup_timeout = flag.Duration("cleanup-timeout", 5*time.Second, "how long to wait, before starting the program again, for the old one's ports to close and its --cleanup-files to go; 0 doesn't wait")
+)
+
+func init() {
+	flag.Var(&cleanup_files, "cleanup-files", "a glob of the temporary or lock files of the program, e.g. /tmp/app.lock, which must be gone before it starts again; left over ones are removed (repeatable)")
+}
+
+func checkCleanup() error {
+	for _, glob := range cleanup_files {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("--cleanup-files %s: %s", glob, err)
+		}
+	}
+	return nil
+}
+
+// openPorts returns the ports the program pid listens on, before it is
+// stopped, for waitCleanup. The sockets of --pass-fd stay open on purpose.
+func openPorts(pid int) []string {
+	if *cleanup_timeout <= 0 || !runner.local() {
+		return nil
+	}
+	kept := map[string]bool{}
+	for _, spec := range pass_fd {
+		if strings.HasPrefix(spec, "tcp") {
+			if _, port, err := net.SplitHostPort(spec[strings.IndexByte(spec, ':')+1:]); err == nil {
+				kept[port] = true
+			}
+		}
+	}
+	var ports []string
+	for _, port := range listenPorts(pid) {
+		if !kept[port] {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// waitCleanup waits until the ports of the stopped program are closed and
+// its --cleanup-files are gone, so the next start doesn't fail on them.
+// What is left after --cleanup-timeout is reported, and the files removed.
+func waitCleanup(ports []string) {
+	if *cleanup_timeout <= 0 || (len(ports) == 0 && len(cleanup_files) == 0) {
+		return
+	}
+	start := time.Now()
+	var open, files []string
+	for {
+		open, files = leftovers(ports)
+		if len(open) == 0 && len(files) == 0 {
+			if d := time.Since(start); d > 500*time.Millisecond {
+				log("cleanup: the old program's resources were released after %s", d.Round(time.Millisecond))
+			}
+			return
+		}
+		if time.Since(start) >= *cleanup_timeout {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(open) > 0 {
+		log("cleanup: port %s still open %s after the program stopped, a child of it may hold it", strings.Join(open, ", "), *cleanup_timeout)
+	}
+	for _, f := range files {
+		if err := os.RemoveAll(f); err != nil {
+			log("cleanup: %s", err)
+			continue
+		}
+		log("cleanup: removed %s, left over %s after the program stopped", f, *cleanup_timeout)
+	}
+}
+
+// leftovers returns the ports that still accept connections and the files
+// of --cleanup-files that still exist.
+func leftovers(ports []string) (open, files []string) {
+	for _, port := range ports {
+		if c, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", port), 100*time.Millisecond); err == nil {
+			c.Close()
+			open = append(open, port)
+		}
+	}
+	for _, glob := range cleanup_files {
+		matches, _ := filepath.Glob(glob)
+		files = append(files, matches...)
+	}
+	return open, files
+}
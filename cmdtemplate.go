@@ -0,0 +1,146 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cmd_template = flag.String("cmd", "", "shell command template run on every change instead of testing, building and running the program, e.g. \"go test {{.ChangedPkg}}\"")
+
+// changeData is handed to the --cmd template. The fields are shell quoted
+// and list the changes of the cycle; without a changed Go file, as at
+// startup, ChangedPkg is the package or pattern rerun was started with.
+type changeData struct {
+	Changed     string // the changed files
+	ChangedFile string // the first changed file
+	ChangedDir  string // its directory
+	ChangedPkg  string // directories of the changed Go files, as ./relative package paths
+	PkgDir      string // the watched package directory
+}
+
+func newChangeData(t *target, changed []string) changeData {
+	var files, pkgs []string
+	seen := map[string]bool{}
+	for _, p := range changed {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			continue
+		}
+		files = append(files, shellQuote(relPath(p)))
+		if !strings.HasSuffix(p, ".go") {
+			continue
+		}
+		pkg := relPath(filepath.Dir(p))
+		if !filepath.IsAbs(pkg) && pkg != "." {
+			pkg = "./" + filepath.ToSlash(pkg)
+		}
+		if !seen[pkg] {
+			seen[pkg] = true
+			pkgs = append(pkgs, shellQuote(pkg))
+		}
+	}
+	if len(pkgs) == 0 {
+		pkgs = []string{shellQuote(t.buildpath)}
+	}
+
+	data := changeData{
+		Changed:    strings.Join(files, " "),
+		ChangedPkg: strings.Join(pkgs, " "),
+		PkgDir:     shellQuote(t.dir),
+	}
+	if len(files) > 0 {
+		data.ChangedFile = files[0]
+		data.ChangedDir = shellQuote(filepath.Dir(relPath(changed[0])))
+	}
+	return data
+}
+
+// relPath returns p relative to the current directory, if it is inside it.
+func relPath(p string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil || !below(wd, abs) {
+		return p
+	}
+	rel, _ := filepath.Rel(wd, abs)
+	return rel
+}
+
+// rerunCmd watches the directory of buildpath, which may be a pattern such
+// as ./..., and runs --cmd on every change. There is no program to run.
+func rerunCmd(buildpath string, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("--cmd takes no program arguments, put them into the template")
+	}
+	srcDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dir := strings.TrimSuffix(strings.TrimSuffix(buildpath, "..."), "/")
+	if isScript(buildpath) {
+		dir = filepath.Dir(buildpath)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	pkg, err := build.Import(dir, srcDir, build.FindOnly)
+	if err != nil {
+		return err
+	}
+	t := &target{buildpath: buildpath, dir: pkg.Dir}
+	if err = setupChain(watchRoot(t)); err != nil {
+		return err
+	}
+	if err = startFixtures(); err != nil {
+		return err
+	}
+
+	ch := make(chan bool)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	initialCycle(t, ch)
+
+	watchTree(t.dir, t, ch)
+	return nil
+}
+
+// runCmdTemplate runs --cmd for the changes of the cycle.
+func runCmdTemplate(t *target, changed []string) (bool, error) {
+	s, err := expandTemplate(*cmd_template, newChangeData(t, changed))
+	if err != nil {
+		log("--cmd: %s", err)
+		return false, err
+	}
+	log("running %s", s)
+
+	cmd := shellCommand(s)
+	cmd.Env = environ()
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+
+	if err := cmd.Run(); err != nil {
+		log("command failed: %s", err)
+		reportFailure("cmd", buf.String())
+		return false, err
+	}
+
+	reportSuccess("cmd")
+	fmt.Fprint(console, buf.String())
+	log("command succeeded")
+	return true, nil
+}
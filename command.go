@@ -0,0 +1,175 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// runData is handed to templates such as --run-cmd and --workdir.
+type runData struct {
+	Bin       string    // path of the freshly built binary
+	Args      string    // program arguments
+	Name      string    // binary name
+	PkgDir    string    // source directory of the package
+	BuildTime time.Time // modification time of the binary
+}
+
+func newRunData(bin, dir string, args []string) runData {
+	data := runData{
+		Bin:    bin,
+		Args:   shellJoin(args),
+		Name:   filepath.Base(bin),
+		PkgDir: dir,
+	}
+	if fi, err := os.Stat(bin); err == nil {
+		data.BuildTime = fi.ModTime()
+	}
+	return data
+}
+
+// quoted returns data with the string fields shell quoted, for templates
+// that are split into words.
+func (data runData) quoted() runData {
+	data.Bin = shellQuote(data.Bin)
+	data.Name = shellQuote(data.Name)
+	data.PkgDir = shellQuote(data.PkgDir)
+	return data
+}
+
+// expandTemplate executes the template s with data.
+func expandTemplate(s string, data interface{}) (string, error) {
+	t, err := template.New("").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer([]byte{})
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expandCommand executes the template s with data and splits the result
+// into words the way a shell would.
+func expandCommand(s string, data interface{}) ([]string, error) {
+	expanded, err := expandTemplate(s, data)
+	if err != nil {
+		return nil, err
+	}
+	words, err := splitWords(expanded)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return words, nil
+}
+
+// splitWords splits s at unquoted white space. Single quotes preserve
+// everything up to the closing quote, double quotes and backslashes work
+// as in sh.
+func splitWords(s string) (words []string, err error) {
+	var word []byte
+	inWord := false
+	quote := byte(0)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\\' && i+1 < len(s) && (quote == 0 || strings.IndexByte(`"\$`, s[i+1]) >= 0):
+			i++
+			word = append(word, s[i])
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word = append(word, c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return
+}
+
+// shellQuote quotes s so that splitWords turns it back into one word.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellCommand returns a command that runs s with the system shell, with
+// the build ID in the environment.
+func shellCommand(s string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", s)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", s)
+	}
+	cmd.Env = append(os.Environ(), buildEnv()...)
+	return cmd
+}
+
+var wrap = flag.String("wrap", "", "wrap every run of the program in this command template, e.g. \"strace -f -o {{.Out}}\"")
+
+// wrapData is handed to the --wrap template.
+type wrapData struct {
+	Out   string // output file of this run, in .rerun/traces
+	Stamp string // start time of this run
+}
+
+// wrapCommand returns the words that go before the program for --wrap.
+func wrapCommand(name string) ([]string, error) {
+	stamp := time.Now().Format("20060102-150405")
+	out := statePath(filepath.Join("traces", name+"-"+stamp+".out"))
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return nil, err
+	}
+	return expandCommand(*wrap, wrapData{Out: shellQuote(out), Stamp: stamp})
+}
@@ -0,0 +1,52 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: "", want: nil},
+		{in: "  \t\n", want: nil},
+		{in: "strace -f {{.Bin}}", want: []string{"strace", "-f", "{{.Bin}}"}},
+		{in: "  a   b\tc\nd ", want: []string{"a", "b", "c", "d"}},
+		{in: `sh -c 'echo "$HOME" \n'`, want: []string{"sh", "-c", `echo "$HOME" \n`}},
+		{in: `echo "a b" "c\"d" "\$x" "\y"`, want: []string{"echo", "a b", `c"d`, "$x", `\y`}},
+		{in: `a\ b c\\d`, want: []string{"a b", `c\d`}},
+		{in: `'' ""`, want: []string{"", ""}},
+		{in: `pre'fix'"ed"`, want: []string{"prefixed"}},
+		{in: `trailing\`, want: []string{`trailing\`}},
+		{in: `'open`, err: true},
+		{in: `"open`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitWords(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestShellQuoteSplits(t *testing.T) {
+	for _, s := range []string{"", "plain", "two words", "it's", `"quoted"`, `back\slash`, "$HOME", "tab\there", "new\nline"} {
+		got, err := splitWords(shellQuote(s))
+		if err != nil || len(got) != 1 || got[0] != s {
+			t.Errorf("splitWords(shellQuote(%q)) = %q, %v", s, got, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var print_commands = flag.Bool("print-commands", false, "print the command lines of the toolchain and the program, with the environment rerun changes, whenever they change")
+
+// A commandLine is the last command a phase ran, as it can be repeated by
+// hand: the program itself is the phase "run".
+type commandLine struct {
+	Phase string   `json:"phase"`
+	Dir   string   `json:"dir"`
+	Env   []string `json:"env,omitempty"`   // variables rerun sets or changes
+	Unset []string `json:"unset,omitempty"` // variables rerun removes
+	Args  []string `json:"args"`
+	Line  string   `json:"line"` // the command for a shell
+
+	masked string // Line without the values of the variables
+}
+
+var commands struct {
+	sync.Mutex
+	phases []string
+	last   map[string]commandLine
+}
+
+// recordCommand keeps cmd as the last command of phase, before it starts,
+// and prints it with --print-commands or --hermetic when it differs from
+// the last one.
+func recordCommand(phase string, cmd *exec.Cmd) {
+	c := commandLine{Phase: phase, Dir: cmd.Dir, Args: cmd.Args}
+	if c.Dir == "" {
+		c.Dir, _ = os.Getwd()
+	}
+	c.Env, c.Unset = envDelta(cmd.Env)
+	// The command lines are served and printed: no secrets of --redact.
+	c.Env, c.Args = redactStrings(c.Env), redactStrings(c.Args)
+
+	c.Line = commandText(c, cmd.Env, func(k, v string) string { return k + "=" + v })
+	c.masked = commandText(c, cmd.Env, func(k, v string) string { return k + "=..." })
+
+	commands.Lock()
+	defer commands.Unlock()
+	if commands.last == nil {
+		commands.last = map[string]commandLine{}
+	}
+	old, ok := commands.last[phase]
+	if !ok {
+		commands.phases = append(commands.phases, phase)
+	}
+	commands.last[phase] = c
+	if (*print_commands || *hermetic) && old.Line != c.Line {
+		log("%s: %s", phase, c.Line)
+	}
+}
+
+// commandText is the shell line of c, which runs with env. setting gives the
+// text of the variable k set to v.
+func commandText(c commandLine, env []string, setting func(k, v string) string) string {
+	line := "cd " + shellQuote(c.Dir) + " && "
+	if m := envMap(env); len(c.Unset) > len(m) {
+		// An allowlist, as with --hermetic, is shorter spelled out.
+		line += "env -i "
+		for _, k := range sortedKeys(m) {
+			line += shellQuote(setting(k, string(redactBytes([]byte(m[k]))))) + " "
+		}
+	} else if len(c.Env) > 0 || len(c.Unset) > 0 {
+		line += "env "
+		for _, k := range c.Unset {
+			line += "-u " + shellQuote(k) + " "
+		}
+		for _, kv := range c.Env {
+			k, v := splitVar(kv)
+			line += shellQuote(setting(k, v)) + " "
+		}
+	}
+	return line + shellJoin(c.Args)
+}
+
+// maskedCommands is lastCommands without the values of the variables, for
+// the control API.
+func maskedCommands() []commandLine {
+	list := lastCommands()
+	for i, c := range list {
+		var names []string
+		for _, kv := range c.Env {
+			k, _ := splitVar(kv)
+			names = append(names, k+"=...")
+		}
+		list[i].Env, list[i].Line = names, c.masked
+	}
+	return list
+}
+
+// splitVar splits the variable kv, KEY=value.
+func splitVar(kv string) (k, v string) {
+	if i := strings.IndexByte(kv, '='); i >= 0 {
+		return kv[:i], kv[i+1:]
+	}
+	return kv, ""
+}
+
+// envDelta returns the variables of env that aren't in the environment of
+// rerun, or have another value there, and those env leaves out. A nil env
+// is the environment of rerun.
+func envDelta(env []string) (set, unset []string) {
+	if env == nil {
+		return nil, nil
+	}
+	own := envMap(os.Environ())
+	next := envMap(env)
+	for _, k := range sortedKeys(next) {
+		if v, ok := own[k]; !ok || v != next[k] {
+			set = append(set, k+"="+next[k])
+		}
+	}
+	for _, k := range sortedKeys(own) {
+		if _, ok := next[k]; !ok {
+			unset = append(unset, k)
+		}
+	}
+	return set, unset
+}
+
+// envMap maps the variables of env to their values; the last one counts,
+// as for exec.
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+	return m
+}
+
+// lastCommands returns the recorded commands in the order the phases
+// first ran.
+func lastCommands() []commandLine {
+	commands.Lock()
+	defer commands.Unlock()
+	list := make([]commandLine, 0, len(commands.phases))
+	for _, p := range commands.phases {
+		list = append(list, commands.last[p])
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		// The program comes last, after what built it.
+		return list[i].Phase != "run" && list[j].Phase == "run"
+	})
+	return list
+}
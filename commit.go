@@ -0,0 +1,114 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var on = flag.String("on", "save", "what starts a cycle: save, any change of a file, or commit, a new commit of the git repository")
+
+// checkOn validates --on.
+func checkOn() error {
+	switch *on {
+	case "save":
+		return nil
+	case "commit":
+		if _, err := git(".", "rev-parse", "--show-toplevel"); err != nil {
+			return fmt.Errorf("--on commit: not in a git repository: %s", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("--on %q: choose save or commit", *on)
+}
+
+// watchCommits calls cb with the watched files a new commit changed,
+// whenever HEAD moves, e.g. on commit, merge or checkout. Uncommitted
+// saves don't start a cycle. It returns errGone once dir no longer exists.
+func watchCommits(dir string, cb scanCallback) error {
+	top, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	head, err := git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		head = "" // no commit yet
+	}
+	log("watching commits of %s", top)
+
+	for {
+		time.Sleep(time.Second)
+		if !exists(dir) {
+			return errGone
+		}
+		next, err := git(dir, "rev-parse", "HEAD")
+		if err != nil || next == head {
+			continue
+		}
+
+		var paths []string
+		root, _ := filepath.Abs(dir)
+		if files, err := commitFiles(top, head, next); err == nil {
+			for _, f := range files {
+				p := filepath.Join(top, f)
+				if rel, err := filepath.Rel(root, p); err != nil || strings.HasPrefix(rel, "..") {
+					continue
+				}
+				if reason := skipReason(dir, p, false); reason != "" {
+					explainf(p, time.Now(), "%s", reason)
+					continue
+				}
+				paths = append(paths, p)
+			}
+		}
+		log("commit %s", shortHash(next))
+		head = next
+		if len(paths) == 0 {
+			log("the commit doesn't change the watched files")
+			continue
+		}
+		cb(paths)
+	}
+}
+
+// commitFiles lists the files that differ between two commits, relative to
+// the top of the repository. All files of to count when from is empty.
+func commitFiles(top, from, to string) ([]string, error) {
+	var out string
+	var err error
+	if from == "" {
+		out, err = git(top, "ls-tree", "-r", "--name-only", to)
+	} else {
+		out, err = git(top, "diff", "--name-only", from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+// git runs git in dir and returns its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
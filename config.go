@@ -0,0 +1,299 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var config_file = flag.String("config", ".rerun.json", "configuration file")
+
+// config holds the settings of the configuration file that have no
+// command line flag. Any other key of the file sets the flag of the same
+// name, unless that flag was given on the command line.
+type config struct {
+	// Priority lists files whose changes trigger a cycle right away,
+	// without waiting for --debounce.
+	Priority []string `json:"priority"`
+
+	// Fixtures are started before the first cycle. They are not restarted
+	// when the configuration is reloaded.
+	Fixtures []fixture `json:"fixtures"`
+
+	// Env is exported to the tests and the program.
+	Env map[string]string `json:"env"`
+
+	// OnOutput lists the actions taken on lines of the program's output.
+	OnOutput []outputRule `json:"on_output"`
+
+	// Dirs gives subtrees their own extensions and actions.
+	Dirs map[string]*dirRule `json:"dirs"`
+}
+
+var conf config
+
+// configured holds the flags that were set by the configuration file.
+var configured = map[string]bool{}
+
+// startupFlags can't change while rerun runs.
+var startupFlags = map[string]bool{
+	"config":        true,
+	"watch":         true,
+	"goexec":        true,
+	"go":            true,
+	"pass-fd":       true,
+	"chain":         true,
+	"events":        true,
+	"daemon":        true,
+	"plugin":        true,
+	"plugin-dir":    true,
+	"bazel":         true,
+	"journal":       true,
+	"proxy":         true,
+	"app-port":      true,
+	"pprof-proxy":   true,
+	"tinygo":        true,
+	"target":        true,
+	"every":         true,
+	"serve-jsonrpc": true,
+	"jsonrpc-token": true,
+	"stdin-events":  true,
+	"matrix":        true,
+	"phases":        true,
+	"skip-install":  true,
+	"test-only":     true,
+	"build-only":    true,
+}
+
+// loadConfig reads the configuration file name. A missing file is only an
+// error if it was asked for with --config.
+func loadConfig(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) && !flagSet("config") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(name); err == nil {
+		configTime = fi.ModTime()
+	}
+
+	if err := applyConfig(name, b, false); err != nil {
+		return err
+	}
+	log("using config %s", name)
+	return nil
+}
+
+// applyConfig sets conf and the flags from the file contents b. On reload
+// the flags set by the previous contents return to their defaults first.
+// A setting that fails leaves all of them as they were; errors point at its
+// line and column.
+func applyConfig(name string, b []byte, reload bool) error {
+	if errs := configErrors(name, b); len(errs) > 0 {
+		return errs[0]
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	if err := compileRules(c.OnOutput); err != nil {
+		return locate(name, b, err)
+	}
+	if err := compileDirs(c.Dirs); err != nil {
+		return locate(name, b, err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	keys := jsonFields(reflect.TypeOf(config{}))
+
+	saved := saveFlags()
+	set := map[string]bool{}
+	if reload {
+		for key := range configured {
+			if _, ok := raw[key]; !ok && !startupFlags[key] {
+				resetFlag(flag.Lookup(key))
+			} else {
+				set[key] = true
+			}
+		}
+	}
+
+	for key, value := range raw {
+		if _, ok := keys[key]; ok || flagSet(key) {
+			continue
+		}
+		if reload && startupFlags[key] {
+			if v, _ := flagValue(value); v != flag.Lookup(key).Value.String() {
+				log("%s: %s only changes on restart", name, key)
+			}
+			continue
+		}
+		if err := setFlag(key, value); err != nil {
+			restoreFlags(saved)
+			return locate(name, b, err)
+		}
+		set[key] = true
+	}
+
+	configured = set
+	conf = c
+	loaded.name, loaded.b = name, b
+	return nil
+}
+
+// configTime is the modification time of the loaded configuration file.
+var configTime time.Time
+
+// reloadConfig applies the configuration file again if it changed, or
+// unconditionally if force is set. Later cycles use the new settings while
+// the program keeps running.
+func reloadConfig(force bool) {
+	name := *config_file
+	fi, err := os.Stat(name)
+	if err != nil || (!force && fi.ModTime().Equal(configTime)) {
+		return
+	}
+	configTime = fi.ModTime()
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		log("error: %s", err)
+		return
+	}
+
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+
+	if err := applyConfig(name, b, true); err != nil {
+		log("error: %s, keeping the previous settings", err)
+		return
+	}
+	updateRedaction()
+	log("reloaded config %s", name)
+}
+
+// watchConfig reloads the configuration on SIGHUP and whenever the file
+// changes.
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	notifyHangup(hup)
+
+	go func() {
+		tick := time.NewTicker(time.Second)
+		for {
+			select {
+			case <-hup:
+				reloadConfig(true)
+			case <-tick.C:
+				reloadConfig(false)
+			}
+		}
+	}()
+}
+
+// setFlag sets the flag key from a JSON value. Arrays set the flag once per
+// element, replacing the values of a repeatable one.
+func setFlag(key string, value json.RawMessage) error {
+	f := flag.Lookup(key)
+	if f == nil {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if l, ok := f.Value.(*listFlag); ok {
+		*l = nil
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(value, &list) != nil {
+		list = []json.RawMessage{value}
+	}
+	for _, v := range list {
+		s, _ := flagValue(v)
+		if err := f.Value.Set(s); err != nil {
+			return fmt.Errorf("%s: %q: %s, want %s", key, s, err, flagKind(f))
+		}
+	}
+	return nil
+}
+
+// flagValue returns the JSON value v as flag text.
+func flagValue(v json.RawMessage) (string, bool) {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s, true
+	}
+	return string(v), false
+}
+
+// listFlag is a flag that may be given several times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// resetFlag sets f back to its default.
+func resetFlag(f *flag.Flag) {
+	if l, ok := f.Value.(*listFlag); ok {
+		*l = nil
+		return
+	}
+	f.Value.Set(f.DefValue)
+}
+
+// saveFlags returns the values of all flags for restoreFlags.
+func saveFlags() map[string]interface{} {
+	saved := map[string]interface{}{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*listFlag); ok {
+			saved[f.Name] = append(listFlag(nil), *l...)
+		} else {
+			saved[f.Name] = f.Value.String()
+		}
+	})
+	return saved
+}
+
+// restoreFlags sets the flags that changed back to the values saveFlags
+// returned.
+func restoreFlags(saved map[string]interface{}) {
+	for name, v := range saved {
+		f := flag.Lookup(name)
+		switch v := v.(type) {
+		case listFlag:
+			*f.Value.(*listFlag) = v
+		case string:
+			if f.Value.String() != v {
+				f.Value.Set(v)
+			}
+		}
+	}
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
@@ -0,0 +1,45 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigReload(t *testing.T) {
+	saved, prev, prevConf := saveFlags(), configured, conf
+	t.Cleanup(func() {
+		restoreFlags(saved)
+		configured, conf = prev, prevConf
+	})
+	configured = map[string]bool{}
+
+	tests := []struct {
+		in       string
+		err      bool
+		cleanup  listFlag
+		interval time.Duration
+	}{
+		{`{"cleanup-files": ["/tmp/a.lock", "/tmp/b.lock"], "poll-interval": "300ms"}`, false, listFlag{"/tmp/a.lock", "/tmp/b.lock"}, 300 * time.Millisecond},
+		{`{"cleanup-files": ["/tmp/a.lock", "/tmp/b.lock"], "poll-interval": "300ms"}`, false, listFlag{"/tmp/a.lock", "/tmp/b.lock"}, 300 * time.Millisecond},
+		{`{"cleanup-files": "/tmp/c.lock"}`, false, listFlag{"/tmp/c.lock"}, 500 * time.Millisecond},
+		{`{"cleanup-files": ["/tmp/d.lock"], "poll-interval": "soon"}`, true, listFlag{"/tmp/c.lock"}, 500 * time.Millisecond},
+		{`{}`, false, nil, 500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		err := applyConfig(".rerun.json", []byte(tt.in), i > 0)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want one: %v", tt.in, err, tt.err)
+		}
+		if !reflect.DeepEqual(cleanup_files, tt.cleanup) {
+			t.Errorf("%s: cleanup-files = %q, want %q", tt.in, cleanup_files, tt.cleanup)
+		}
+		if *poll_interval != tt.interval {
+			t.Errorf("%s: poll-interval = %s, want %s", tt.in, *poll_interval, tt.interval)
+		}
+	}
+}
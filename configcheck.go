@@ -0,0 +1,531 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startupChecks validate the settings once the configuration file is
+// applied, for rerun and for rerun config validate.
+var startupChecks = []func() error{
+	checkOn,
+	func() error {
+		if err := checkMaxFileSize(); err != nil {
+			return fmt.Errorf("--max-file-size: %s", err)
+		}
+		return nil
+	},
+	checkPresets,
+	checkBackend,
+	checkNetfs,
+	checkCleanup,
+	checkStdinEvents,
+	checkMatrix,
+	checkPhases,
+}
+
+// loaded is the configuration file last applied, to point at the settings
+// an error of the startup checks is about.
+var loaded struct {
+	name string
+	b    []byte
+}
+
+// A configError is an error at a line and column of the configuration
+// file, or of the whole file when line is 0.
+type configError struct {
+	name      string
+	line, col int
+	msg       string
+}
+
+func (e *configError) Error() string {
+	if e.line == 0 {
+		return e.name + ": " + e.msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.name, e.line, e.col, e.msg)
+}
+
+// errorAt returns the error msg at offset off of b.
+func errorAt(name string, b []byte, off int64, msg string) *configError {
+	if off < 0 || off > int64(len(b)) {
+		return &configError{name: name, msg: msg}
+	}
+	before := b[:off]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return &configError{name, line, col, msg}
+}
+
+// keyOffsets maps the paths of the keys of the JSON document b, such as
+// "fixtures[0].name", to their offsets.
+func keyOffsets(b []byte) map[string]int64 {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	offsets := map[string]int64{}
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := tok.(string)
+				p := key
+				if path != "" {
+					p = path + "." + key
+				}
+				offsets[p] = openingQuote(b, dec.InputOffset())
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+		_, err = dec.Token()
+		return err
+	}
+	walk("")
+	return offsets
+}
+
+// openingQuote returns the offset of the quote that opens the string of b
+// which ends right before end. The decoder unescapes the keys, so their
+// text in b has no fixed length; a quote inside a string follows an odd
+// number of backslashes.
+func openingQuote(b []byte, end int64) int64 {
+	for i := end - 2; i >= 0; i-- {
+		if b[i] != '"' {
+			continue
+		}
+		n := 0
+		for j := i - 1; j >= 0 && b[j] == '\\'; j-- {
+			n++
+		}
+		if n%2 == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// configErrors checks the configuration file b: its syntax, its keys and
+// the types of their values. It returns nothing for a file rerun can
+// apply, but for the values the flags and the rules reject.
+func configErrors(name string, b []byte) []error {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return []error{errorAt(name, b, syntax.Offset, syntax.Error())}
+		}
+		return []error{&configError{name: name, msg: err.Error()}}
+	}
+	top, ok := doc.(map[string]interface{})
+	if !ok {
+		return []error{errorAt(name, b, 0, "the configuration is a JSON object of settings")}
+	}
+
+	offsets := keyOffsets(b)
+	var errs []error
+	at := func(path, format string, args ...interface{}) {
+		off, ok := offsets[path]
+		if !ok {
+			off = -1
+		}
+		errs = append(errs, errorAt(name, b, off, fmt.Sprintf(format, args...)))
+	}
+
+	fields := jsonFields(reflect.TypeOf(config{}))
+	var settings []string
+	for key := range fields {
+		settings = append(settings, key)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, f.Name)
+	})
+
+	for _, key := range objectKeys(top) {
+		v := top[key]
+		if t, ok := fields[key]; ok {
+			unknownKeys(v, t, key, at)
+			continue
+		}
+		f := flag.Lookup(key)
+		if f == nil {
+			at(key, "unknown setting %q%s", key, suggest(key, settings))
+			continue
+		}
+		_, list := f.Value.(*listFlag)
+		switch v.(type) {
+		case map[string]interface{}:
+			at(key, "%s: want %s, not an object", key, flagKind(f))
+		case []interface{}:
+			if !list {
+				at(key, "%s: want %s, not an array; only repeatable flags take one", key, flagKind(f))
+			}
+		}
+	}
+
+	// The types of the settings without a flag, with their offsets.
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		var typ *json.UnmarshalTypeError
+		if errors.As(err, &typ) {
+			off, ok := offsets[typ.Field]
+			if !ok {
+				off = typ.Offset
+			}
+			errs = append(errs, errorAt(name, b, off, fmt.Sprintf("%s: want %s, not %s", typ.Field, typ.Type, typ.Value)))
+		} else if len(errs) == 0 {
+			errs = append(errs, &configError{name: name, msg: err.Error()})
+		}
+	}
+	sortErrors(errs)
+	return errs
+}
+
+// sortErrors orders the errors of the configuration file by position.
+func sortErrors(errs []error) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, ok1 := errs[i].(*configError)
+		b, ok2 := errs[j].(*configError)
+		return ok1 && ok2 && (a.line < b.line || a.line == b.line && a.col < b.col)
+	})
+}
+
+// unknownKeys reports the keys of v, the value at path, that the structs
+// of type t have no field for.
+func unknownKeys(v interface{}, t reflect.Type, path string, at func(path, format string, args ...interface{})) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		var names []string
+		for key := range fields {
+			names = append(names, key)
+		}
+		for _, key := range objectKeys(obj) {
+			if ft, ok := fields[key]; ok {
+				unknownKeys(obj[key], ft, path+"."+key, at)
+				continue
+			}
+			hint := suggest(key, names)
+			if hint == "" {
+				sort.Strings(names)
+				hint = ", want one of " + strings.Join(names, ", ")
+			}
+			at(path+"."+key, "%s: unknown key %q%s", path, key, hint)
+		}
+	case reflect.Slice:
+		if list, ok := v.([]interface{}); ok {
+			for i, e := range list {
+				unknownKeys(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i), at)
+			}
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]interface{}); ok {
+			for _, key := range objectKeys(obj) {
+				unknownKeys(obj[key], t.Elem(), path+"."+key, at)
+			}
+		}
+	}
+}
+
+func sortedRaw(raw map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func objectKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonFields returns the types of the fields of the struct t by their
+// keys in JSON.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || key == "-" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+		fields[key] = f.Type
+	}
+	return fields
+}
+
+// flagKind describes the JSON values the flag f takes.
+func flagKind(f *flag.Flag) string {
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return "true or false"
+	}
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case int, int64, uint, uint64, float64:
+			return "a number"
+		case time.Duration:
+			return "a duration such as \"500ms\""
+		}
+	}
+	if _, ok := f.Value.(*listFlag); ok {
+		return "a string or an array of strings"
+	}
+	return "a string"
+}
+
+// locate puts an error of the setting key, whose message starts with
+// "key:", at the key in the configuration file b.
+func locate(name string, b []byte, err error) error {
+	msg := err.Error()
+	if i := strings.Index(msg, ":"); i > 0 {
+		if off, ok := keyOffsets(b)[msg[:i]]; ok {
+			return errorAt(name, b, off, msg)
+		}
+	}
+	return &configError{name: name, msg: msg}
+}
+
+// flagMentionRe matches the flags an error message names.
+var flagMentionRe = regexp.MustCompile(`--([a-z0-9-]+)`)
+
+// configSource adds to an error of the startup checks where the
+// configuration file sets the flags it is about.
+func configSource(err error) error {
+	if loaded.b == nil {
+		return err
+	}
+	offsets := keyOffsets(loaded.b)
+	var where []string
+	seen := map[string]bool{}
+	for _, m := range flagMentionRe.FindAllStringSubmatch(err.Error(), -1) {
+		key := m[1]
+		if off, ok := offsets[key]; ok && configured[key] && !seen[key] {
+			seen[key] = true
+			e := errorAt(loaded.name, loaded.b, off, "")
+			where = append(where, fmt.Sprintf("%s is set at %s:%d:%d", key, e.name, e.line, e.col))
+		}
+	}
+	if len(where) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s (%s)", err, strings.Join(where, ", "))
+}
+
+// suggest returns a did-you-mean hint for the misspelled name among
+// candidates, or "".
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", 0
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(strings.Replace(name, "_", "-", -1)), strings.Replace(c, "_", "-", -1))
+		if best == "" || d < bestDist || d == bestDist && c < best {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" || bestDist > 2 && bestDist > len(name)/3 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// configCommand checks the configuration file, or shows the settings.
+func configCommand(args []string) error {
+	const usage = "usage: rerun [flags] config validate [file] | show [-effective]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "validate":
+		return validateConfig(args[1:])
+	case "show":
+		return showConfig(args[1:])
+	}
+	return errors.New(usage)
+}
+
+// validateConfig checks a configuration file like rerun does at startup,
+// and reports every problem rather than the first.
+func validateConfig(args []string) error {
+	name := *config_file
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		return errors.New("usage: rerun [flags] config validate [file]")
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	errs := configErrors(name, b)
+	// The values of the flags, which the checks above leave to the flags.
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(b, &raw) == nil {
+		for _, key := range sortedRaw(raw) {
+			if flag.Lookup(key) == nil || flagSet(key) {
+				continue
+			}
+			if err := setFlag(key, raw[key]); err != nil {
+				errs = append(errs, locate(name, b, err))
+			}
+		}
+		sortErrors(errs)
+	}
+	if len(errs) == 0 {
+		if err := applyConfig(name, b, false); err != nil {
+			errs = append(errs, err)
+		} else {
+			for _, check := range append(startupChecks, checkRepro) {
+				if err := check(); err != nil {
+					errs = append(errs, configSource(err))
+				}
+			}
+		}
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", name, len(errs))
+	}
+	fmt.Printf("%s: ok\n", name)
+	return nil
+}
+
+// showConfig prints the settings that differ from the defaults, from the
+// configuration file or the command line, as a configuration file. With
+// -effective it prints every setting.
+func showConfig(args []string) error {
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	effective := fs.Bool("effective", false, "print every setting, the defaults included")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errors.New("usage: rerun [flags] config show [-effective]")
+	}
+	// Read without loadConfig, which logs: the output is a file.
+	b, err := ioutil.ReadFile(*config_file)
+	if err == nil {
+		err = applyConfig(*config_file, b, false)
+	} else if os.IsNotExist(err) && !flagSet("config") {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if *effective || configured[f.Name] || flagSet(f.Name) || f.Value.String() != f.DefValue {
+			settings[f.Name] = settingValue(f)
+		}
+	})
+	var c map[string]json.RawMessage
+	b, _ = json.Marshal(conf)
+	json.Unmarshal(b, &c)
+	for key, v := range c {
+		if *effective || (string(v) != "null" && string(v) != "[]" && string(v) != "{}") {
+			settings[key] = v
+		}
+	}
+
+	out, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// settingValue returns the value of the flag f for JSON.
+func settingValue(f *flag.Flag) interface{} {
+	if l, ok := f.Value.(*listFlag); ok {
+		return append([]string{}, *l...)
+	}
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch v := g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return v
+		}
+	}
+	return f.Value.String()
+}
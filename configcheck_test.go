@@ -0,0 +1,80 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyOffsets(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]int64
+	}{
+		{`{}`, map[string]int64{}},
+		{`{"a": 1, "b": "x"}`, map[string]int64{"a": 1, "b": 9}},
+		{
+			"{\n  \"fixtures\": [\n    {\"name\": \"db\"},\n    {\"name\": \"cache\", \"env\": {\"K\": \"v\"}}\n  ]\n}",
+			map[string]int64{"fixtures": 4, "fixtures[0].name": 23, "fixtures[1].name": 43, "fixtures[1].env": 60, "fixtures[1].env.K": 68},
+		},
+		// The keys are unescaped, their text isn't.
+		{`{"a<b": 1, "c": 2}`, map[string]int64{"a<b": 1, "c": 11}},
+		{`{"\u0041": 1, "c": 2}`, map[string]int64{"A": 1, "c": 14}},
+		{`{"q\"": 1, "c": 2}`, map[string]int64{`q"`: 1, "c": 11}},
+		{`{"\\": 1, "c": 2}`, map[string]int64{`\`: 1, "c": 10}},
+		{`{"l": [[1], {"k": 2}]}`, map[string]int64{"l": 1, "l[1].k": 13}},
+	}
+	for _, tt := range tests {
+		if got := keyOffsets([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keyOffsets(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"debounce", "ignore", "include", "ext", "show-diff", "netfs"}
+	tests := []struct {
+		name, want string
+	}{
+		{"deboucne", `, did you mean "debounce"?`},
+		{"ignor", `, did you mean "ignore"?`},
+		{"show_diff", `, did you mean "show-diff"?`},
+		{"Include", `, did you mean "include"?`},
+		{"exts", `, did you mean "ext"?`},
+		{"watchdog", ""},
+		{"x", `, did you mean "ext"?`},
+		{"completely-different", ""},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.name, candidates); got != tt.want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := suggest("debounce", nil); got != "" {
+		t.Errorf("suggest without candidates = %q, want nothing", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"debounce", "debounce", 0},
+		{"deboucne", "debounce", 2},
+		{"ignor", "ignore", 1},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
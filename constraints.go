@@ -0,0 +1,119 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var all_files = flag.Bool("all-files", false, "let changes of Go files excluded by build constraints trigger a cycle too")
+
+// constraintReason explains why the changed Go file p can't affect the
+// build: its name or build constraints exclude it for the target GOOS,
+// GOARCH, cgo and -tags of the build, or for the tinygo target. A file that
+// was part of the build before its change, such as one that just got
+// //go:build ignore, counts: the binary still has its code. So does one
+// seen for the first time, whose earlier content isn't known.
+func constraintReason(p string) string {
+	if *all_files || !strings.HasSuffix(p, ".go") {
+		return ""
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		// Removed files, and files that can't be read, count.
+		return ""
+	}
+
+	ctxt := targetContext()
+	key := ctxt.GOOS + "/" + ctxt.GOARCH + "/" + strconv.FormatBool(ctxt.CgoEnabled) + "/" + strings.Join(ctxt.BuildTags, ",")
+	constraints.Lock()
+	defer constraints.Unlock()
+	if constraints.key != key || constraints.files == nil {
+		constraints.key, constraints.files = key, map[string]constraintState{}
+	}
+	old, known := constraints.files[p]
+	// Every watcher asks about the same version of p: they get the same
+	// answer.
+	if known && old.mtime.Equal(info.ModTime()) && old.size == info.Size() {
+		return old.reason
+	}
+	match, err := ctxt.MatchFile(filepath.Dir(p), filepath.Base(p))
+	if err != nil {
+		return ""
+	}
+	st := constraintState{mtime: info.ModTime(), size: info.Size(), match: match}
+	if !match && known && !old.match {
+		st.reason = "excluded by build constraints for " + ctxt.GOOS + "/" + ctxt.GOARCH
+	}
+	constraints.files[p] = st
+	return st.reason
+}
+
+// constraints keeps whether the versions of the Go files seen were part of
+// the build, and what constraintReason answered for them.
+var constraints struct {
+	sync.Mutex
+	key   string // the target they were matched for
+	files map[string]constraintState
+}
+
+type constraintState struct {
+	mtime  time.Time
+	size   int64
+	match  bool
+	reason string
+}
+
+// targetContext returns the build context of the program: the target of
+// the cross flags, --kube or tinygo, and the tags of the build.
+func targetContext() build.Context {
+	ctxt := build.Default
+	ctxt.BuildTags = buildTags()
+	for _, kv := range crossEnv {
+		i := strings.IndexByte(kv, '=')
+		switch kv[:i] {
+		case "GOOS":
+			ctxt.GOOS = kv[i+1:]
+		case "GOARCH":
+			ctxt.GOARCH = kv[i+1:]
+		case "CGO_ENABLED":
+			ctxt.CgoEnabled = kv[i+1:] == "1"
+		}
+	}
+	if t := tinygoTarget; t != nil {
+		ctxt.GOOS, ctxt.GOARCH = t.GOOS, t.GOARCH
+		ctxt.BuildTags = append(ctxt.BuildTags, t.BuildTags...)
+	}
+	return ctxt
+}
+
+// buildTags returns the tags given with -tags in --build-flags, or the
+// first tag set of --matrix, which the program is built with.
+func buildTags() []string {
+	words, _ := splitWords(*build_flags)
+	words = append(programTags(), words...)
+	for i, w := range words {
+		var list string
+		switch {
+		case strings.HasPrefix(w, "-tags="), strings.HasPrefix(w, "--tags="):
+			list = w[strings.IndexByte(w, '=')+1:]
+		case (w == "-tags" || w == "--tags") && i+1 < len(words):
+			list = words[i+1]
+		default:
+			continue
+		}
+		return strings.FieldsFunc(list, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return nil
+}
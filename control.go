@@ -0,0 +1,414 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var http_addr = flag.String("http", "", "serve the control API on this address, e.g. localhost:7070, on localhost when the host is empty; off it requests need the token of --jsonrpc-token or a random one")
+
+// current is the session the keyboard and HTTP controls act on.
+var current struct {
+	t  *target
+	ch chan bool
+}
+
+// interactive is set when keyboard or HTTP controls are available.
+var interactive bool
+
+// toggles are the phases that can be switched on and off at runtime.
+var toggles = []struct {
+	key  byte
+	name string
+	flag *bool
+}{
+	{'t', "test", do_tests},
+	{'v', "vet", do_vet},
+	{'g', "generate", do_generate},
+}
+
+// toggle switches a phase on or off for the following cycles.
+func toggle(name string) error {
+	for _, tg := range toggles {
+		if tg.name == name {
+			cycleMu.Lock()
+			*tg.flag = !*tg.flag
+			cycleMu.Unlock()
+			log("%s", toggleStatus())
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown phase %q", name)
+}
+
+func toggleStatus() string {
+	var parts []string
+	for _, tg := range toggles {
+		state := "off"
+		if *tg.flag {
+			state = "on"
+		}
+		parts = append(parts, tg.name+" "+state)
+	}
+	if isStale() {
+		parts = append(parts, "stale (build failing)")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// trigger starts a cycle outside of the watcher.
+func trigger() {
+	if current.t == nil {
+		return
+	}
+	go refresh(current.t, current.ch, nil)
+}
+
+const keyHelp = "keys: t test, v vet, g generate, r rebuild, R rebuild with -race, s suspend or continue the program, f force a held restart, p previous build, e open error, h help"
+
+// readKeys reads single keystrokes from the terminal.
+func readKeys() {
+	if !isTerminal(os.Stdin) || *stdin_events {
+		return
+	}
+	interactive = true
+
+	raw := rawTerminal()
+	log("%s", keyHelp)
+
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(b); err != nil || n == 0 {
+				return
+			}
+			key(b[0], raw)
+		}
+	}()
+}
+
+func key(c byte, raw bool) {
+	for _, tg := range toggles {
+		if c == tg.key {
+			toggle(tg.name)
+			return
+		}
+	}
+
+	switch c {
+	case 'r':
+		log("rebuild requested")
+		trigger()
+	case 'R':
+		armRace()
+		trigger()
+	case 's':
+		if err := toggleSuspend(); err != nil {
+			log("%s", err)
+		}
+	case 'f':
+		if !forceRestart() {
+			log("no restart is pending")
+		}
+	case 'p':
+		if err := rollback(); err != nil {
+			log("%s", err)
+		}
+	case 'e':
+		if err := openError(true); err != nil {
+			log("%s", err)
+		}
+	case 'h', '?':
+		log("%s", keyHelp)
+		log("%s", toggleStatus())
+	case '\n', '\r':
+	default:
+		if raw {
+			log("unknown key %q, h for help", c)
+		}
+	}
+}
+
+// ttySaved holds the terminal settings from before rawTerminal.
+var ttySaved string
+
+// rawTerminal makes keystrokes available without waiting for enter, and
+// restores the terminal on exit.
+func rawTerminal() bool {
+	saved, err := stty("-g")
+	if err != nil {
+		return false
+	}
+	ttySaved = strings.TrimSpace(string(saved))
+
+	if !rawMode() {
+		return false
+	}
+	atExit(restoreTerminal)
+	return true
+}
+
+func rawMode() bool {
+	_, err := stty("-icanon", "-echo", "min", "1")
+	return err == nil
+}
+
+func restoreTerminal() {
+	if ttySaved != "" {
+		stty(ttySaved)
+	}
+}
+
+func stty(args ...string) ([]byte, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Output()
+}
+
+// serveControl serves the control API:
+//
+//	GET  /status              current toggles, and whether the build is stale
+//	POST /toggle?phase=test   switch a phase on or off
+//	POST /rebuild             start a cycle
+//	POST /rollback            start the build before the running one
+//	POST /next?race=1         build the next cycle with -race
+//	POST /suspend             stop the program with SIGSTOP, keeping its state
+//	POST /continue            let the suspended program go on
+//	POST /open                open the first error location in the editor
+//	GET  /diagnostics         errors of the failed phases, as LSP diagnostics
+//	GET  /commands            the last command lines of the phases and the program,
+//	                          as JSON, or as a shell script with ?format=sh, without
+//	                          the values of the variables
+//	GET  /debug/pprof/        profiles of rerun itself, with --http-pprof
+//
+// and the dashboard, to the pages of its own origin only.
+func serveControl() {
+	if *http_addr == "" {
+		return
+	}
+	interactive = true
+
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]bool{}
+		for _, tg := range toggles {
+			status[tg.name] = *tg.flag
+		}
+		if *keep_running {
+			status["stale"] = isStale()
+		}
+		status["suspended"] = isSuspended()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	http.HandleFunc("/commands", func(w http.ResponseWriter, r *http.Request) {
+		list := maskedCommands()
+		if r.FormValue("format") == "sh" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, c := range list {
+				fmt.Fprintf(w, "# %s\n%s\n", c.Phase, c.Line)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+
+	http.HandleFunc("/toggle", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if err := toggle(r.FormValue("phase")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, toggleStatus())
+	})
+
+	http.HandleFunc("/rebuild", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		trigger()
+		fmt.Fprintln(w, "rebuilding")
+	})
+
+	http.HandleFunc("/next", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if r.FormValue("race") != "1" {
+			http.Error(w, "nothing to set for the next cycle, try race=1", http.StatusBadRequest)
+			return
+		}
+		armRace()
+		fmt.Fprintln(w, "the next cycle builds with -race")
+	})
+
+	http.HandleFunc("/suspend", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if err := suspendProgram(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "suspended")
+	})
+
+	http.HandleFunc("/continue", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if err := continueProgram(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "continuing")
+	})
+
+	http.HandleFunc("/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if err := rollback(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "rolling back")
+	})
+
+	http.HandleFunc("/open", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		if err := openError(false); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "opening")
+	})
+
+	serveDashboard()
+	serveCover()
+	serveDiagnostics()
+
+	if host, port, err := net.SplitHostPort(*http_addr); err == nil && host == "" {
+		*http_addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	l, err := listenTCP("http", http_addr)
+	if err != nil {
+		log("control API: %s", err)
+		return
+	}
+	var token string
+	if a, ok := l.Addr().(*net.TCPAddr); ok && !a.IP.IsLoopback() {
+		if token, err = controlToken(); err != nil {
+			l.Close()
+			log("control API: %s", err)
+			return
+		}
+		log("control API and dashboard on http://%s/?token=%s", *http_addr, token)
+	} else {
+		log("control API and dashboard on http://%s", *http_addr)
+	}
+	h := http.Handler(http.DefaultServeMux)
+	if *http_pprof {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+				ownProfiles(w, r)
+				return
+			}
+			http.DefaultServeMux.ServeHTTP(w, r)
+		})
+	}
+	h = guardControl(h, token)
+	go func() {
+		if err := http.Serve(l, h); err != nil {
+			log("control API: %s", err)
+		}
+	}()
+}
+
+// controlToken is --jsonrpc-token, or a random token.
+func controlToken() (string, error) {
+	if *jsonrpc_token != "" {
+		return *jsonrpc_token, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// guardControl keeps the control API to the pages of its own origin, so no
+// web site the browser shows can use it, not even through a name that
+// resolves to the loopback address. With a token, as off the loopback
+// interface, requests must also give it: in the URL rerun logs, in the
+// cookie that URL sets, or as an Authorization: Bearer header.
+func guardControl(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" && !loopbackHost(r.Host) {
+			http.Error(w, "unknown host "+r.Host, http.StatusForbidden)
+			return
+		}
+		if o := r.Header.Get("Origin"); o != "" && o != "http://"+r.Host {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		if token != "" {
+			given := r.URL.Query().Get("token")
+			if c, err := r.Cookie("rerun_token"); err == nil && given == "" {
+				given = c.Value
+			}
+			if a := r.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") && given == "" {
+				given = strings.TrimPrefix(a, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "the token rerun logged with the address is required", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("token") != "" {
+				http.SetCookie(w, &http.Cookie{Name: "rerun_token", Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost reports whether the Host header hostport names the loopback
+// interface.
+func loopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// post rejects requests that would change state unless they are POSTs.
+func post(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cover = flag.Bool("cover", false, "write a coverage profile and HTML report with the tests, served at /cover with --http")
+
+// coverArgs returns the flags of go test that write the coverage profile.
+func coverArgs() []string {
+	if !*cover {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath("cover.out")), 0755); err != nil {
+		log("--cover: %s", err)
+		return nil
+	}
+	return []string{"-coverprofile=" + statePath("cover.out")}
+}
+
+// renderCover turns the profile of the last test run into the HTML report
+// and logs the total coverage.
+func renderCover() {
+	profile := statePath("cover.out")
+	if !*cover || !exists(profile) {
+		return
+	}
+
+	report := statePath("cover.html")
+	if out, err := gocmd("tool", "cover", "-html="+profile, "-o", report).CombinedOutput(); err != nil {
+		log("coverage report: %s", strings.TrimSpace(string(out)))
+		return
+	}
+
+	total := "unknown"
+	if out, err := gocmd("tool", "cover", "-func="+profile).Output(); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if f := strings.Fields(lines[len(lines)-1]); len(f) > 0 {
+			total = f[len(f)-1]
+		}
+	}
+	log("coverage %s, report in %s", total, report)
+}
+
+// coverReload reloads the report when it changes, keeping the selected
+// file.
+const coverReload = `<script>
+(function() {
+	var files = document.getElementById('files');
+	var saved = sessionStorage.getItem('rerun-cover-file');
+	if (files && saved) {
+		files.value = saved;
+		files.dispatchEvent(new Event('change'));
+	}
+	if (files) {
+		files.addEventListener('change', function() {
+			sessionStorage.setItem('rerun-cover-file', files.value);
+		});
+	}
+	var stamp = null;
+	setInterval(function() {
+		fetch('/cover.stamp').then(r => r.text()).then(s => {
+			if (stamp !== null && s !== stamp) {
+				location.reload();
+			}
+			stamp = s;
+		});
+	}, 2000);
+})();
+</script>
+`
+
+// serveCover adds the coverage report to the control API:
+//
+//	GET /cover         the report, reloading itself after every test run
+//	GET /cover.stamp   modification time of the report
+func serveCover() {
+	http.HandleFunc("/cover", func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadFile(statePath("cover.html"))
+		if err != nil {
+			http.Error(w, "no coverage report yet, run with --test --cover", http.StatusNotFound)
+			return
+		}
+		page := strings.Replace(string(b), "</body>", coverReload+"</body>", 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+
+	http.HandleFunc("/cover.stamp", func(w http.ResponseWriter, r *http.Request) {
+		if fi, err := os.Stat(statePath("cover.html")); err == nil {
+			fmt.Fprint(w, fi.ModTime().UnixNano())
+		}
+	})
+}
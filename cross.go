@@ -0,0 +1,153 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Cross compilation for a remote --executor.
+var (
+	cross_goos    = flag.String("goos", "", "GOOS of the binary, for a remote --executor")
+	cross_goarch  = flag.String("goarch", "", "GOARCH of the binary, for a remote --executor")
+	cross_goarm   = flag.String("goarm", "", "GOARM of the binary, e.g. 6 or 7")
+	cross_goamd64 = flag.String("goamd64", "", "GOAMD64 of the binary, e.g. v3")
+	cross_cc      = flag.String("cc", "", "C compiler of a cgo cross toolchain, e.g. aarch64-linux-gnu-gcc; turns on cgo")
+	cross_cxx     = flag.String("cxx", "", "C++ compiler of a cgo cross toolchain")
+)
+
+// crossEnv is added to the environment of the go command when cross
+// compiling. The test phase doesn't get it, the tests run here.
+var crossEnv []string
+
+func crossCompiling() bool {
+	return len(crossEnv) > 0
+}
+
+// setupCross builds the binary of t for the target of the cross flags. go
+// install refuses to install cross-compiled binaries to GOBIN, so it is
+// built into .rerun/cross instead.
+func setupCross(t *target) error {
+	for _, v := range []struct{ name, value string }{
+		{"GOOS", *cross_goos}, {"GOARCH", *cross_goarch}, {"GOARM", *cross_goarm},
+		{"GOAMD64", *cross_goamd64}, {"CC", *cross_cc}, {"CXX", *cross_cxx},
+	} {
+		if v.value != "" {
+			crossEnv = append(crossEnv, v.name+"="+v.value)
+		}
+	}
+	if *cross_cc != "" {
+		crossEnv = append(crossEnv, "CGO_ENABLED=1")
+	}
+	if !crossCompiling() {
+		return nil
+	}
+	if tinygoEnabled() || *bazel_target != "" {
+		return fmt.Errorf("the cross compilation flags don't apply with --tinygo or --bazel")
+	}
+
+	bin, err := filepath.Abs(statePath("cross/" + filepath.Base(t.bin)))
+	if err != nil {
+		return err
+	}
+	t.bin = bin
+	install = func(buildpath string) (bool, error) {
+		return gobuildTo(bin, buildpath)
+	}
+	log("cross compiling with %s to %s", strings.Join(crossEnv, " "), bin)
+	return nil
+}
+
+// gobuildTo builds buildpath to bin, as the install phase.
+func gobuildTo(bin, buildpath string) (bool, error) {
+	out, err := runGo(nil, goArgs("build", "-o", bin, buildpath)...)
+	if err != nil {
+		log("build failed")
+		reportFailure("build", out)
+		return false, err
+	}
+	reportSuccess("build")
+	log("build succeeded")
+	return true, nil
+}
+
+// machines maps the output of uname -m to GOARCH.
+var machines = map[string]string{
+	"x86_64": "amd64", "amd64": "amd64", "i386": "386", "i686": "386",
+	"aarch64": "arm64", "arm64": "arm64", "armv6l": "arm", "armv7l": "arm", "armv8l": "arm",
+	"riscv64": "riscv64", "ppc64le": "ppc64le", "s390x": "s390x", "mips": "mips", "mips64": "mips64",
+}
+
+// binaryArch returns the GOOS and GOARCH the binary bin was built for.
+func binaryArch(bin string) (goos, goarch string, err error) {
+	if f, err := elf.Open(bin); err == nil {
+		defer f.Close()
+		arch := map[elf.Machine]string{
+			elf.EM_X86_64: "amd64", elf.EM_386: "386", elf.EM_AARCH64: "arm64", elf.EM_ARM: "arm",
+			elf.EM_RISCV: "riscv64", elf.EM_PPC64: "ppc64le", elf.EM_S390: "s390x", elf.EM_MIPS: "mips",
+		}[f.Machine]
+		if arch == "" {
+			arch = strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+		}
+		goos := "linux"
+		if f.OSABI == elf.ELFOSABI_FREEBSD {
+			goos = "freebsd"
+		}
+		return goos, arch, nil
+	}
+	if f, err := macho.Open(bin); err == nil {
+		defer f.Close()
+		arch := map[macho.Cpu]string{macho.CpuAmd64: "amd64", macho.CpuArm64: "arm64"}[f.Cpu]
+		return "darwin", arch, nil
+	}
+	if f, err := pe.Open(bin); err == nil {
+		defer f.Close()
+		arch := map[uint16]string{pe.IMAGE_FILE_MACHINE_AMD64: "amd64", pe.IMAGE_FILE_MACHINE_I386: "386", pe.IMAGE_FILE_MACHINE_ARM64: "arm64"}[f.Machine]
+		return "windows", arch, nil
+	}
+	return "", "", fmt.Errorf("%s isn't an executable rerun knows", bin)
+}
+
+// remoteMachine runs uname -s -m with argv and returns the system and the
+// machine, or "" for a remote without uname.
+func remoteMachine(argv []string) (system, machine string) {
+	out, err := exec.Command(argv[0], argv[1:]...).Output()
+	if err != nil {
+		return "", ""
+	}
+	f := strings.Fields(string(out))
+	if len(f) != 2 {
+		return "", ""
+	}
+	return f[0], f[1]
+}
+
+// checkArch compares the binary bin with the machine of the remote, so a
+// binary for the wrong architecture isn't copied.
+func checkArch(bin, system, machine string) error {
+	if system == "" {
+		return nil
+	}
+	remoteOS, remoteArch := strings.ToLower(system), machines[machine]
+	goos, goarch, err := binaryArch(bin)
+	if err != nil {
+		// Not a binary the debug packages read, such as a script.
+		return nil
+	}
+	if goos != remoteOS || (remoteArch != "" && goarch != remoteArch) {
+		return fmt.Errorf("the binary is %s/%s, the remote is %s %s: build for it with --goos %s --goarch %s", goos, goarch, system, machine, remoteOS, remoteArch)
+	}
+	if machine == "armv6l" && *cross_goarm != "" && *cross_goarm != "6" && *cross_goarm != "5" {
+		return fmt.Errorf("the remote is armv6l, which doesn't run GOARM=%s: use --goarm 6", *cross_goarm)
+	}
+	return nil
+}
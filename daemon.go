@@ -0,0 +1,304 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var daemon_socket = flag.String("daemon", "", "get the changes from the rerun daemon on this socket instead of watching, e.g. ../.rerun/daemon.sock for a daemon of the parent directory; the sessions of a tree share the socket")
+
+// A daemonMessage is one line of the daemon protocol. A client sends the
+// root it watches, the daemon answers with its own root, or an error, and
+// then sends the changed files below the client's root after every batch.
+type daemonMessage struct {
+	Root    string   `json:"root,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// A daemon keeps one watch of a tree and hands its changes to all the
+// rerun clients that watch a part of it.
+type daemon struct {
+	root string
+
+	sync.Mutex
+	clients map[net.Conn]string // root of every client
+}
+
+// daemonCommand implements "rerun [flags] daemon [-socket PATH] [dir]". It
+// watches dir, the current directory by default, until it is interrupted.
+func daemonCommand(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", "", "socket the clients connect to, .rerun/daemon.sock in the watched directory by default, outside the directories of the sessions")
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if *socket == "" {
+		// The daemon claims no session: this is the shared state directory.
+		*socket = filepath.Join(dir, statePath("daemon.sock"))
+	}
+
+	if c, err := net.Dial("unix", *socket); err == nil {
+		c.Close()
+		return fmt.Errorf("daemon: another daemon listens on %s", *socket)
+	}
+	os.Remove(*socket)
+	if err := os.MkdirAll(filepath.Dir(*socket), 0755); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("daemon: %s", err)
+	}
+	atExit(func() {
+		l.Close()
+		os.Remove(*socket)
+	})
+	handleSignals()
+
+	// The point of the daemon is to save the watches of its clients.
+	if !flagSet("events") {
+		*events = true
+	}
+
+	d := &daemon{root: dir, clients: map[net.Conn]string{}}
+	log("daemon: serving the changes of %s on %s", dir, *socket)
+	go d.accept(l)
+
+	for {
+		if !*events {
+			if err := scanChanges(dir, d.broadcast); err != errGone {
+				return fmt.Errorf("daemon: %s", err)
+			}
+		} else if err := watchEvents(dir, d.broadcast); err != errGone {
+			log("event backend unavailable (%s), polling instead", err)
+			*events = false
+			continue
+		}
+		log("%s disappeared, waiting for it to return", dir)
+		for !exists(dir) {
+			time.Sleep(time.Second)
+		}
+		d.broadcast([]string{dir})
+	}
+}
+
+func (d *daemon) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go d.register(conn)
+	}
+}
+
+// register reads the root of a new client and adds it, if the root is
+// inside the tree of the daemon.
+func (d *daemon) register(conn net.Conn) {
+	var m daemonMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewDecoder(conn).Decode(&m); err != nil {
+		conn.Close()
+		return
+	}
+
+	reply := daemonMessage{Root: d.root}
+	if !below(d.root, m.Root) {
+		reply = daemonMessage{Error: fmt.Sprintf("%s isn't inside %s", m.Root, d.root)}
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := json.NewEncoder(conn).Encode(reply); err != nil || reply.Error != "" {
+		conn.Close()
+		return
+	}
+
+	d.Lock()
+	d.clients[conn] = m.Root
+	n := len(d.clients)
+	d.Unlock()
+	log("daemon: client for %s connected, %d client(s)", m.Root, n)
+
+	// A client says nothing more, reading finds out when it goes away.
+	conn.SetReadDeadline(time.Time{})
+	var b [1]byte
+	conn.Read(b[:])
+	d.drop(conn)
+}
+
+func (d *daemon) drop(conn net.Conn) {
+	d.Lock()
+	root, ok := d.clients[conn]
+	delete(d.clients, conn)
+	n := len(d.clients)
+	d.Unlock()
+	conn.Close()
+	if ok {
+		log("daemon: client for %s left, %d client(s)", root, n)
+	}
+}
+
+// broadcast sends every client the changed files below its root. Clients
+// that don't take them within a second are dropped.
+func (d *daemon) broadcast(paths []string) {
+	d.Lock()
+	clients := make(map[net.Conn]string, len(d.clients))
+	for conn, root := range d.clients {
+		clients[conn] = root
+	}
+	d.Unlock()
+
+	for conn, root := range clients {
+		var changed []string
+		for _, p := range paths {
+			if below(root, p) {
+				changed = append(changed, p)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if err := json.NewEncoder(conn).Encode(daemonMessage{Changed: changed}); err != nil {
+			d.drop(conn)
+		}
+	}
+}
+
+// below reports whether p is root or inside it.
+func below(root, p string) bool {
+	return p == root || strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// watchDaemon gets the changes below dir from the daemon on --daemon and
+// calls cb with those the rules of this rerun don't leave out.
+func watchDaemon(dir string, cb scanCallback) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", *daemon_socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	if err := json.NewEncoder(conn).Encode(daemonMessage{Root: root}); err != nil {
+		return err
+	}
+	var hello daemonMessage
+	if err := dec.Decode(&hello); err != nil {
+		return err
+	}
+	if hello.Error != "" {
+		return errors.New(hello.Error)
+	}
+	log("watching: %s (rerun daemon of %s)", dir, hello.Root)
+
+	batches := make(chan []string, 64)
+	go func() {
+		defer close(batches)
+		for {
+			var m daemonMessage
+			if dec.Decode(&m) != nil {
+				return
+			}
+			batches <- m.Changed
+		}
+	}()
+
+	for changed := range batches {
+		for len(changed) > 0 {
+			var paths []string
+			for _, p := range changed {
+				if p == root && !exists(root) {
+					return errGone
+				}
+				if !changeSkipped(root, p) {
+					paths = append(paths, p)
+				}
+			}
+			if paths = dropLarge(paths); len(paths) == 0 {
+				break
+			}
+			started := snapshotPaths(paths)
+			cb(paths)
+			changed = requeueDaemon(batches, started)
+		}
+	}
+	return errors.New("the daemon went away")
+}
+
+// changeSkipped applies the watch rules to p, a change reported by the
+// daemon or a watch plugin. Those don't know the rules, so the directories
+// above p count too.
+func changeSkipped(root, p string) bool {
+	fi, err := os.Stat(p)
+	isDir := err == nil && fi.IsDir()
+	for d := filepath.Dir(p); below(root, d); d = filepath.Dir(d) {
+		if reason := skipReason(root, d, true); reason != "" {
+			explainf(p, time.Now(), "%s", reason)
+			return true
+		}
+		if d == root {
+			break
+		}
+	}
+	if reason := skipReason(root, p, isDir); reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return true
+	}
+	if err == nil && selfWritten(p, fi.ModTime()) {
+		explainf(p, fi.ModTime(), "written by the hooks of the last cycle")
+		return true
+	}
+	if reason := constraintReason(p); !isDir && reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return true
+	}
+	return false
+}
+
+// requeueDaemon returns the changes the daemon reported while a cycle ran,
+// for the next cycle, but for those the cycle made itself. started holds
+// the files the cycle started with.
+func requeueDaemon(batches chan []string, started treeSnapshot) []string {
+	var next []string
+	for {
+		select {
+		case changed, ok := <-batches:
+			if !ok {
+				return next
+			}
+			for _, p := range changed {
+				if reason := cycleWrite(p, started); reason != "" {
+					explainf(p, time.Now(), "changed while a cycle ran, %s", reason)
+				} else {
+					next = append(next, p)
+				}
+			}
+		default:
+			return next
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The dashboard keeps this much history.
+const (
+	dashCycles = 30
+	dashLines  = 200
+)
+
+type cycleSummary struct {
+	Time     time.Time     `json:"time"`
+	OK       bool          `json:"ok"`
+	Phases   []phaseResult `json:"phases"`
+	Duration float64       `json:"duration"`
+}
+
+type errorSummary struct {
+	Time  time.Time `json:"time"`
+	Phase string    `json:"phase"`
+	Out   string    `json:"out"`
+}
+
+var dash struct {
+	sync.Mutex
+	cycles  []cycleSummary
+	lastErr *errorSummary
+}
+
+// recordCycle keeps the outcome of c for the dashboard.
+func recordCycle(c *cycle) {
+	if *http_addr == "" {
+		return
+	}
+	dash.Lock()
+	defer dash.Unlock()
+	dash.cycles = append(dash.cycles, cycleSummary{c.start, c.ok, c.phases, time.Since(c.start).Seconds()})
+	if len(dash.cycles) > dashCycles {
+		dash.cycles = dash.cycles[1:]
+	}
+}
+
+// recordError keeps the output of the last failed phase for the dashboard.
+func recordError(phase, out string) {
+	if *http_addr == "" {
+		return
+	}
+	dash.Lock()
+	dash.lastErr = &errorSummary{time.Now(), phase, out}
+	dash.Unlock()
+}
+
+// tail keeps the last lines the program printed and hands new ones to the
+// browsers following the log.
+var tail = &lineTail{subs: map[chan string]bool{}}
+
+type lineTail struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+	subs    map[chan string]bool
+}
+
+func (t *lineTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.partial + string(p)
+	lines := strings.Split(s, "\n")
+	t.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		t.lines = append(t.lines, line)
+		for c := range t.subs {
+			select {
+			case c <- line:
+			default:
+			}
+		}
+	}
+	if len(t.lines) > dashLines {
+		t.lines = t.lines[len(t.lines)-dashLines:]
+	}
+	return len(p), nil
+}
+
+// follow returns the kept lines and a channel for the following ones.
+func (t *lineTail) follow() ([]string, chan string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := make(chan string, 64)
+	t.subs[c] = true
+	return append([]string(nil), t.lines...), c
+}
+
+func (t *lineTail) unfollow(c chan string) {
+	t.mu.Lock()
+	delete(t.subs, c)
+	t.mu.Unlock()
+}
+
+// childOutput returns where the output of the program written to w goes.
+// With the dashboard or --serve-jsonrpc it is also kept for the log tail,
+// and with --proxy it is read for the port the program listens on, and
+// with on_output rules for the lines they act on.
+func childOutput(w io.Writer) io.Writer {
+	ws := []io.Writer{w}
+	if *http_addr != "" || *serve_jsonrpc != "" {
+		ws = append(ws, tail)
+	}
+	if detecting() {
+		ws = append(ws, &portScanner{})
+	}
+	if len(conf.OnOutput) > 0 {
+		ws = append(ws, &ruleScanner{})
+	}
+	if outputLog != nil {
+		ws = append(ws, outputLog)
+	}
+	return io.MultiWriter(ws...)
+}
+
+// serveDashboard adds the dashboard to the control API:
+//
+//	GET  /                 the dashboard page
+//	GET  /dashboard.json   state shown by the page
+//	GET  /log              output of the program, as server-sent events
+//	POST /restart          start the program again without rebuilding
+func serveDashboard() {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, dashboardHTML)
+	})
+
+	http.HandleFunc("/dashboard.json", func(w http.ResponseWriter, r *http.Request) {
+		toggled := map[string]bool{}
+		for _, tg := range toggles {
+			toggled[tg.name] = *tg.flag
+		}
+		launchMu.Lock()
+		pid, starts := childPid, launches
+		launchMu.Unlock()
+
+		dash.Lock()
+		defer dash.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Toggles  map[string]bool `json:"toggles"`
+			Pid      int             `json:"pid"`
+			Launches int             `json:"launches"`
+			Cycles   []cycleSummary  `json:"cycles"`
+			LastErr  *errorSummary   `json:"last_error"`
+			Stale    bool            `json:"stale"`
+		}{toggled, pid, starts, dash.cycles, dash.lastErr, isStale()})
+	})
+
+	http.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		lines, c := tail.follow()
+		defer tail.unfollow(c)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for _, line := range lines {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case line := <-c:
+				fmt.Fprintf(w, "data: %s\n\n", line)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	http.HandleFunc("/restart", func(w http.ResponseWriter, r *http.Request) {
+		if !post(w, r) {
+			return
+		}
+		go restart()
+		fmt.Fprintln(w, "restarting")
+	})
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rerun</title>
+<style>
+body { font: 14px sans-serif; margin: 1em 2em; }
+pre { background: #f4f4f4; padding: .5em; overflow: auto; }
+#log { height: 20em; }
+#chart { display: flex; align-items: flex-end; height: 6em; gap: 2px; }
+#chart div { width: 10px; background: #4a4; }
+#chart div.fail { background: #c44; }
+.fail { color: #c44; }
+</style>
+</head>
+<body>
+<h1>rerun</h1>
+<p id="status"></p>
+<p>
+<button onclick="post('/rebuild')">Rebuild</button>
+<button onclick="post('/restart')">Restart</button>
+<button onclick="post('/rollback')">Previous build</button>
+<a href="/cover">Coverage</a>
+</p>
+<h2>Cycles</h2>
+<div id="chart"></div>
+<h2>Last error</h2>
+<pre id="error">none</pre>
+<h2>Log</h2>
+<pre id="log"></pre>
+<script>
+function post(url) { fetch(url, {method: 'POST'}); }
+
+function update() {
+	fetch('/dashboard.json').then(r => r.json()).then(s => {
+		var cycles = s.cycles || [];
+		var last = cycles[cycles.length - 1];
+		var toggles = Object.keys(s.toggles).map(k => k + ' ' + (s.toggles[k] ? 'on' : 'off'));
+		var status = document.getElementById('status');
+		status.textContent = (s.pid ? 'running, pid ' + s.pid : 'not running') +
+			(s.stale ? ' stale (build failing)' : '') +
+			', ' + s.launches + ' starts, ' + toggles.join(', ') +
+			(last ? ', last cycle ' + (last.ok ? 'ok' : 'failed') : '');
+		status.className = last && !last.ok ? 'fail' : '';
+
+		var chart = document.getElementById('chart');
+		chart.textContent = '';
+		var max = Math.max.apply(null, cycles.map(c => c.duration).concat([0.001]));
+		cycles.forEach(c => {
+			var bar = document.createElement('div');
+			bar.style.height = (100 * c.duration / max) + '%';
+			bar.className = c.ok ? '' : 'fail';
+			bar.title = c.phases.map(p => p.name + ' ' + p.duration.toFixed(2) + 's').join('\n');
+			chart.appendChild(bar);
+		});
+
+		var e = s.last_error;
+		document.getElementById('error').textContent = e ? e.time + ' ' + e.phase + '\n' + e.out : 'none';
+	});
+}
+update();
+setInterval(update, 2000);
+
+var log = document.getElementById('log');
+new EventSource('/log').onmessage = ev => {
+	log.textContent += ev.data + '\n';
+	if (log.textContent.length > 100000) {
+		log.textContent = log.textContent.slice(-50000);
+	}
+	log.scrollTop = log.scrollHeight;
+};
+</script>
+</body>
+</html>
+`
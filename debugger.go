@@ -0,0 +1,103 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var debugger = flag.Bool("debugger", false, "a debugger is attached to the program --executor runs: hold restarts until f forces them; a local debugger is detected on Linux, one that --run-cmd or --wrap starts only with this flag")
+
+// held is the restart that waits for the debugger to detach.
+var held struct {
+	sync.Mutex
+	pending  bool
+	relaunch bool
+	forced   bool
+}
+
+// resume tells the run loop that the held restart can go ahead.
+var resume = make(chan bool, 1)
+
+// checkDebugger rejects --debugger for a local program where rerun can't
+// see the debugger detach: it would take the program for traced until f
+// forced each restart.
+func checkDebugger() error {
+	if *debugger && runner.local() && !tracesSeen {
+		return fmt.Errorf("--debugger: rerun can't see a debugger detach on %s, so every restart would wait for f; declare one only for the program of --executor", runtime.GOOS)
+	}
+	return nil
+}
+
+// attached reports whether a debugger traces pid. Where that can't be seen,
+// --debugger declares it.
+func attached(pid int) bool {
+	if runner.local() {
+		if traced, ok := tracedBy(pid); ok {
+			return traced
+		}
+	}
+	return *debugger
+}
+
+// holdRestart reports whether the restart of pid, asked for with relaunch,
+// must wait for the debugger, and whether it was forced while the debugger
+// is still attached. The last restart asked for is made once it detaches.
+func holdRestart(pid int, relaunch bool) (hold, forced bool) {
+	held.Lock()
+	defer held.Unlock()
+	if held.forced {
+		held.forced = false
+		return false, true
+	}
+	if held.pending {
+		held.relaunch = relaunch
+		return true, false
+	}
+	if !attached(pid) {
+		return false, false
+	}
+	held.pending, held.relaunch = true, relaunch
+	log("restart pending (debugger attached), f forces it")
+
+	go func() {
+		for {
+			time.Sleep(500 * time.Millisecond)
+			held.Lock()
+			if !held.pending {
+				held.Unlock()
+				return
+			}
+			if !attached(pid) {
+				held.pending = false
+				relaunch := held.relaunch
+				held.Unlock()
+				log("debugger detached, restarting")
+				resume <- relaunch
+				return
+			}
+			held.Unlock()
+		}
+	}()
+	return true, false
+}
+
+// forceRestart makes the held restart now. It reports false if there was
+// none.
+func forceRestart() bool {
+	held.Lock()
+	defer held.Unlock()
+	if !held.pending {
+		return false
+	}
+	held.pending, held.forced = false, true
+	log("forcing the restart")
+	resume <- held.relaunch
+	return true
+}
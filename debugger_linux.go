@@ -0,0 +1,46 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// tracesSeen tells that tracedBy sees the debuggers here.
+const tracesSeen = true
+
+// tracedBy reports whether pid is traced, from the TracerPid of
+// /proc/PID/status, or one of its descendants when the program runs behind
+// --run-cmd or --wrap. A wrapper that traces its own children, as strace -f
+// or rr record do, doesn't count; one that debugs them, as a "dlv exec",
+// needs --debugger.
+func tracedBy(pid int) (traced, ok bool) {
+	pids := []int{pid}
+	if *run_cmd != "" || *wrap != "" {
+		pids = descendants(pid)
+	}
+	own := map[int]bool{}
+	for _, p := range pids {
+		own[p] = true
+	}
+	for _, p := range pids {
+		b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(p) + "/status")
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if !strings.HasPrefix(line, "TracerPid:") {
+				continue
+			}
+			tracer, _ := strconv.Atoi(strings.TrimSpace(line[len("TracerPid:"):]))
+			if tracer != 0 && (!own[tracer] || *debugger) {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
@@ -0,0 +1,15 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+// tracesSeen tells that tracedBy can't see the debuggers here.
+const tracesSeen = false
+
+// tracedBy can't tell whether pid is traced here.
+func tracedBy(pid int) (traced, ok bool) {
+	return false, false
+}
@@ -0,0 +1,27 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func detachProcess(cmd *exec.Cmd) error {
+	return errors.New("rerun start -d needs a Unix system, run rerun as a service instead")
+}
+
+// terminateRerun ends the rerun pid. Without signals to ask, the program
+// may outlive it.
+func terminateRerun(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
@@ -0,0 +1,34 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachProcess starts cmd in a session of its own, so closing the
+// terminal doesn't hang it up.
+func detachProcess(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return nil
+}
+
+// terminateRerun asks the rerun pid to shut down. A detached rerun leads
+// its session, so its process group gets the signal along with the
+// program, as on ^C; another one gets it alone.
+func terminateRerun(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != syscall.ESRCH {
+		return err
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
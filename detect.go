@@ -0,0 +1,79 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// findMain looks for the main packages below the current directory. A
+// unique one is picked, otherwise the user chooses among the candidates.
+func findMain() (string, error) {
+	mains, err := mainPackages("./...")
+	if err != nil {
+		return "", fmt.Errorf("no import path given and go list failed: %s", err)
+	}
+
+	switch len(mains) {
+	case 0:
+		return "", errors.New("no import path given and no main package found")
+	case 1:
+		log("found main package %s", mains[0])
+		return mains[0], nil
+	}
+	return choose(mains)
+}
+
+// mainPackages returns the import paths of the main packages matching
+// pattern.
+func mainPackages(pattern string) ([]string, error) {
+	out, err := gocmd("list", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{end}}`, pattern).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var mains []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			mains = append(mains, line)
+		}
+	}
+	return mains, nil
+}
+
+// choose lets the user pick one of the main packages.
+func choose(mains []string) (string, error) {
+	fmt.Println("main packages:")
+	for i, m := range mains {
+		fmt.Printf("  %d) %s\n", i+1, m)
+	}
+	if !isTerminal(os.Stdin) {
+		return "", errors.New("several main packages found, pass one as argument")
+	}
+
+	fmt.Printf("choose [1-%d]: ", len(mains))
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(mains) {
+		return "", errors.New("no main package chosen")
+	}
+	return mains[n-1], nil
+}
+
+// isTerminal reports whether f is a character device other than the null
+// device, such as a tty.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
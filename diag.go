@@ -0,0 +1,132 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A diagnostic is a line of toolchain output that points at a source
+// location, such as "./main.go:12:5: undefined: foo".
+type diagnostic struct {
+	File string
+	Line int
+	Col  int
+	Msg  string
+}
+
+var diagRe = regexp.MustCompile(`^\s*([^\s:][^:]*\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+func parseDiagnostic(line string) (d diagnostic, ok bool) {
+	m := diagRe.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	d.File = m[1]
+	d.Line, _ = strconv.Atoi(m[2])
+	d.Col, _ = strconv.Atoi(m[3])
+	d.Msg = m[4]
+	return d, true
+}
+
+func parseDiagnostics(out string) (diags []diagnostic) {
+	for _, line := range strings.Split(out, "\n") {
+		if d, ok := parseDiagnostic(line); ok {
+			diags = append(diags, d)
+		}
+	}
+	return
+}
+
+// key identifies a diagnostic across builds. The line number is left out
+// so that an error doesn't count as new just because code above it moved.
+func (d diagnostic) key() string {
+	return d.File + ": " + d.Msg
+}
+
+// lastFailure holds the diagnostics of the previous failure of each phase.
+var lastFailure = map[string]map[string]bool{}
+
+// lastOutput holds the output of the previous failure of each phase, and
+// waiting the phases that wait for sources, with the reason.
+var (
+	lastOutput = map[string]string{}
+	waiting    = map[string]string{}
+)
+
+// waitingRe matches failures that mean the sources are in flux, as during a
+// branch switch or with a file saved half-way, rather than broken.
+var waitingRe = regexp.MustCompile(`no Go files in \S+|build constraints exclude all Go files in \S+|(\S+: )?expected 'package', found 'EOF'|found packages \S+ \(\S+\) and \S+ \(\S+\)`)
+
+// reportFailure prints the output of a failed phase. Diagnostics that were
+// already reported by the previous failure of the same phase are collapsed
+// and new ones are marked with a '+'.
+func reportFailure(phase, out string) {
+	recordError(phase, out)
+	recordDiagnostics(phase, out)
+	noteLocation(out)
+	clearSpin()
+
+	// Don't repeat the same failure while the sources settle.
+	if m := waitingRe.FindString(out); m != "" {
+		if waiting[phase] != m {
+			waiting[phase] = m
+			log("waiting for sources: %s", m)
+		}
+		return
+	}
+	delete(waiting, phase)
+	if *diff_errors && lastOutput[phase] == out {
+		log("same output as the previous failure")
+		return
+	}
+	lastOutput[phase] = out
+	prev := lastFailure[phase]
+	seen := map[string]bool{}
+	collapsed := 0
+
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		d, ok := parseDiagnostic(line)
+		if !ok {
+			fmt.Fprintln(console, line)
+			continue
+		}
+		seen[d.key()] = true
+		switch {
+		case !*diff_errors || prev == nil:
+			fmt.Fprintln(console, line)
+		case prev[d.key()]:
+			collapsed++
+		default:
+			fmt.Fprintln(console, "+ "+line)
+		}
+	}
+
+	if collapsed > 0 {
+		log("%d unchanged diagnostic(s) collapsed", collapsed)
+	}
+	lastFailure[phase] = seen
+}
+
+// reportSuccess forgets the failure history of a phase.
+func reportSuccess(phase string) {
+	if waiting[phase] != "" {
+		log("sources are back")
+		delete(waiting, phase)
+	}
+	delete(lastOutput, phase)
+	delete(lastFailure, phase)
+	recordDiagnostics(phase, "")
+}
+
+func (d diagnostic) String() string {
+	if d.Col > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Col, d.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Msg)
+}
@@ -0,0 +1,164 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A dirRule gives a subtree of the watched tree its own extensions and
+// action, as in the "dirs" setting:
+//
+//	"dirs": {
+//		"./web":      {"ext": ["ts", "css"], "action": "hook npm run build"},
+//		"./internal": {"ext": ["go"], "action": "rebuild"}
+//	}
+//
+// The actions are rebuild, the default, which runs a cycle; restart, which
+// restarts the program without building; hook CMD, which runs the shell
+// command CMD instead of a cycle; and ignore. The rule of the deepest
+// directory applies.
+type dirRule struct {
+	Ext    []string `json:"ext"`
+	Action string   `json:"action"`
+
+	name string // as in the file
+	dir  string
+	exts map[string]bool
+	hook string
+}
+
+// compileDirs checks the rules of the dirs setting and resolves their
+// directories against the current directory.
+func compileDirs(dirs map[string]*dirRule) error {
+	for name, r := range dirs {
+		if r == nil {
+			return fmt.Errorf("dirs: %s has no rule", name)
+		}
+		dir, err := filepath.Abs(name)
+		if err != nil {
+			return fmt.Errorf("dirs: %s", err)
+		}
+		r.name, r.dir = name, dir
+		r.exts = nil
+		for _, e := range r.Ext {
+			if r.exts == nil {
+				r.exts = map[string]bool{}
+			}
+			r.exts[strings.TrimPrefix(e, ".")] = true
+		}
+		r.hook = ""
+		switch action := strings.TrimSpace(r.Action); {
+		case action == "", action == "rebuild", action == "restart", action == "ignore":
+			r.Action = action
+		case strings.HasPrefix(action, "hook "):
+			r.Action, r.hook = "hook", strings.TrimSpace(action[len("hook "):])
+		default:
+			return fmt.Errorf("dirs: %s: unknown action %q, want rebuild, restart, ignore or hook CMD", name, r.Action)
+		}
+	}
+	return nil
+}
+
+// warnOutsideDirs reports the rules for directories the watch of root
+// doesn't see.
+func warnOutsideDirs(root string) {
+	root, _ = filepath.Abs(root)
+	for _, name := range sortedDirs() {
+		if r := conf.Dirs[name]; !below(root, r.dir) {
+			log("dirs %s isn't inside the watched tree %s, see --watch", name, root)
+		}
+	}
+}
+
+func sortedDirs() []string {
+	names := make([]string, 0, len(conf.Dirs))
+	for name := range conf.Dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dirRuleFor returns the rule of the deepest directory above p, or nil.
+func dirRuleFor(p string) *dirRule {
+	if len(conf.Dirs) == 0 {
+		return nil
+	}
+	p, _ = filepath.Abs(p)
+	var best *dirRule
+	for _, r := range conf.Dirs {
+		if below(r.dir, p) && (best == nil || len(r.dir) > len(best.dir)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// skip returns why the rule leaves out p, or "".
+func (r *dirRule) skip(p string, isDir bool) string {
+	if r.Action == "ignore" {
+		return "dirs " + r.name + ": ignored"
+	}
+	if !isDir && r.exts != nil && !included(p) && !r.exts[strings.TrimPrefix(filepath.Ext(p), ".")] {
+		return "dirs " + r.name + ": not a watched extension"
+	}
+	return ""
+}
+
+// ownExts reports whether a rule with its own extensions covers p, so the
+// extensions of --ext and the presets don't apply.
+func ownExts(p string) bool {
+	r := dirRuleFor(p)
+	return r != nil && r.exts != nil
+}
+
+// dirActions sorts the changed files by the action of their rules.
+func dirActions(changed []string) (hooks []*dirRule, restart, rebuild bool) {
+	if len(changed) == 0 {
+		return nil, false, true
+	}
+	seen := map[*dirRule]bool{}
+	for _, p := range changed {
+		r := dirRuleFor(p)
+		switch {
+		case r == nil || r.Action == "" || r.Action == "rebuild":
+			rebuild = true
+		case r.Action == "restart":
+			restart = true
+		case r.Action == "hook" && !seen[r]:
+			seen[r] = true
+			hooks = append(hooks, r)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].name < hooks[j].name })
+	return hooks, restart, rebuild
+}
+
+// runDirHooks runs the hooks of the rules, with the changed files below
+// each in $RERUN_CHANGED.
+func runDirHooks(hooks []*dirRule, changed []string) (bool, error) {
+	for _, r := range hooks {
+		var files []string
+		for _, p := range changed {
+			if dirRuleFor(p) == r {
+				files = append(files, relPath(p))
+			}
+		}
+		cmd := shellCommand(r.hook)
+		cmd.Env = append(cmd.Env, "RERUN_CHANGED="+strings.Join(files, " "))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log("dirs %s: hook failed", r.name)
+			reportFailure("hook", fmt.Sprintf("%s: %s\n%s", r.hook, err, out))
+			return false, err
+		}
+		log("dirs %s: hook succeeded", r.name)
+	}
+	reportSuccess("hook")
+	return true, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+var test_in_docker = flag.String("test-in-docker", "", "run the test phase in a container of this image, e.g. golang:1.22-alpine")
+
+// testCommand returns the go command of the test phase, run with args. With
+// --test-in-docker it runs in a container that mounts the module, or the
+// current directory outside of one, and the module cache. The build cache
+// is a volume of its own, as the container builds for another platform.
+func testCommand(args ...string) *exec.Cmd {
+	if *test_in_docker == "" {
+		cmd := gocmd(args...)
+		cmd.Env = testEnv()
+		return cmd
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = "."
+	}
+	root := wd
+	if mod := goEnv("GOMOD"); mod != "" && mod != os.DevNull && below(filepath.Dir(mod), wd) {
+		root = filepath.Dir(mod)
+	}
+	// The module has the same path inside, so file names in the output
+	// point to the sources, except on Windows.
+	mount := filepath.ToSlash(root)
+	if runtime.GOOS == "windows" {
+		mount = "/src"
+	}
+	rel, _ := filepath.Rel(root, wd)
+
+	docker := []string{"run", "--rm", "-i",
+		"-v", root + ":" + mount,
+		"-w", path.Join(mount, filepath.ToSlash(rel)),
+		"-v", "rerun-go-build:/root/.cache/go-build",
+	}
+	if modcache := goEnv("GOMODCACHE"); modcache != "" {
+		docker = append(docker, "-v", modcache+":/go/pkg/mod")
+	}
+	for _, kv := range append(extraEnv(), "GOFLAGS="+currentGoflags()) {
+		docker = append(docker, "-e", kv)
+	}
+	docker = append(append(docker, *test_in_docker, "go"), args...)
+
+	cmd := exec.Command("docker", docker...)
+	cmd.Env = os.Environ()
+	return cmd
+}
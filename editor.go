@@ -0,0 +1,140 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+var editor = flag.String("editor", "", "command template that opens an error location, e.g. \"code -g {{.File}}:{{.Line}}:{{.Col}}\", by default derived from $VISUAL or $EDITOR")
+
+// location is handed to the --editor template.
+type location struct {
+	File string
+	Line int
+	Col  int
+}
+
+// firstError is the location of the first diagnostic of the last failure.
+var firstError struct {
+	sync.Mutex
+	loc *location
+}
+
+// noteLocation remembers the first source location in the output of a
+// failed phase.
+func noteLocation(out string) {
+	diags := parseDiagnostics(out)
+	if len(diags) == 0 {
+		return
+	}
+	d := diags[0]
+	loc := &location{File: d.File, Line: d.Line, Col: d.Col}
+	if loc.Col == 0 {
+		loc.Col = 1
+	}
+	firstError.Lock()
+	firstError.loc = loc
+	firstError.Unlock()
+}
+
+// findSource resolves the file name of a diagnostic, which is relative to
+// the current directory, or to the package directory for test output.
+func findSource(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	if current.t != nil {
+		if p := filepath.Join(current.t.dir, name); !exists(name) && exists(p) {
+			return p
+		}
+	}
+	if p, err := filepath.Abs(name); err == nil {
+		return p
+	}
+	return name
+}
+
+// guiEditors open a window and don't need the terminal.
+var guiEditors = map[string]bool{
+	"code": true, "code-insiders": true, "codium": true, "cursor": true, "windsurf": true,
+	"subl": true, "zed": true, "idea": true, "goland": true, "gvim": true, "mvim": true,
+}
+
+// editorCommand returns the command that opens loc, and whether the editor
+// runs in the terminal.
+func editorCommand(loc *location) ([]string, bool, error) {
+	if *editor != "" {
+		q := *loc
+		q.File = shellQuote(q.File)
+		argv, err := expandCommand(*editor, q)
+		if err != nil || len(argv) == 0 {
+			return nil, false, fmt.Errorf("--editor: %v", err)
+		}
+		return argv, !guiEditors[filepath.Base(argv[0])], nil
+	}
+
+	ed := os.Getenv("VISUAL")
+	if ed == "" {
+		ed = os.Getenv("EDITOR")
+	}
+	words, err := splitWords(ed)
+	if err != nil || len(words) == 0 {
+		return nil, false, errors.New("set $EDITOR or --editor to open errors")
+	}
+
+	pos := fmt.Sprintf("%s:%d:%d", loc.File, loc.Line, loc.Col)
+	name := filepath.Base(words[0])
+	gui := guiEditors[name]
+	switch name {
+	case "code", "code-insiders", "codium", "cursor", "windsurf":
+		return append(words, "-g", pos), !gui, nil
+	case "subl", "zed", "hx", "helix":
+		return append(words, pos), !gui, nil
+	case "idea", "goland":
+		return append(words, "--line", strconv.Itoa(loc.Line), loc.File), !gui, nil
+	}
+	// vi, vim, nvim, nano, emacs, micro, kak and most others.
+	return append(words, "+"+strconv.Itoa(loc.Line), loc.File), true, nil
+}
+
+// openError opens the first error location of the last failure. Editors
+// that run in the terminal can only be opened from the keyboard, which
+// hands them the terminal until they exit.
+func openError(fromKeys bool) error {
+	firstError.Lock()
+	loc := firstError.loc
+	firstError.Unlock()
+	if loc == nil {
+		return errors.New("no error location to open")
+	}
+	loc = &location{File: findSource(loc.File), Line: loc.Line, Col: loc.Col}
+
+	argv, terminal, err := editorCommand(loc)
+	if err != nil {
+		return err
+	}
+	if terminal && !fromKeys {
+		return errors.New("the editor runs in the terminal, open it with the e key")
+	}
+
+	log("opening %s:%d", loc.File, loc.Line)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if !fromKeys {
+		return cmd.Start()
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	restoreTerminal()
+	defer rawMode()
+	return cmd.Run()
+}
@@ -0,0 +1,12 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build freebsd || openbsd || netbsd || dragonfly
+
+package main
+
+import "syscall"
+
+// watchOpen opens the watched files and directories.
+const watchOpen = syscall.O_RDONLY
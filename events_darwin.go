@@ -0,0 +1,11 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "syscall"
+
+// watchOpen opens the watched files and directories for their events only,
+// which doesn't keep their volume from being unmounted.
+const watchOpen = syscall.O_EVTONLY
@@ -0,0 +1,359 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || freebsd || openbsd || netbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// vnodeNotes are the kqueue events of a watched file or directory.
+const vnodeNotes = syscall.NOTE_WRITE | syscall.NOTE_EXTEND | syscall.NOTE_ATTRIB | syscall.NOTE_DELETE | syscall.NOTE_RENAME
+
+// fdReserve are the descriptors left to rerun and the toolchain when the
+// watches take the limit of the process.
+const fdReserve = 256
+
+// kqueue watches a tree with one descriptor per directory and per watched
+// file: a write of a directory is an entry created, removed or renamed,
+// which the entries it had tell apart. What doesn't fit into the
+// descriptor limit is polled instead.
+type kqueue struct {
+	fd      int
+	root    string
+	paths   map[int]string             // watched descriptor to path
+	fds     map[string]int             // path to watched descriptor
+	entries map[string]map[string]bool // names in the watched directories
+	changes chan string
+	report  map[string]bool // the names of --inotify-events
+	limit   int
+	full    bool
+	polled  []string
+	err     error
+
+	// mu orders the sends of the pollers on changes before it is closed,
+	// and done, closed with it, stops them.
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+func watchEvents(dir string, cb scanCallback) error {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+
+	w := &kqueue{
+		fd:      fd,
+		root:    dir,
+		paths:   map[int]string{},
+		fds:     map[string]int{},
+		entries: map[string]map[string]bool{},
+		changes: make(chan string, 256),
+		report:  map[string]bool{},
+		limit:   watchLimit(),
+		done:    make(chan struct{}),
+	}
+	for _, name := range splitList(*inotify_mask) {
+		w.report[name] = true
+	}
+
+	w.poll(w.addTree(dir))
+
+	log("watching: %s (kqueue)", dir)
+	go w.read()
+
+	b := newBatch(dir)
+	b.settle = *event_latency
+	interval := 100 * time.Millisecond
+	if *event_latency > 0 && *event_latency < interval {
+		interval = *event_latency
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case p, ok := <-w.changes:
+			if !ok {
+				return w.err
+			}
+			b.add(p, time.Now())
+		case <-tick.C:
+		}
+
+		if b.ready() {
+			started := snapshotPaths(b.paths)
+			b.flush(cb)
+			requeue(w.changes, b, started)
+		}
+	}
+}
+
+// watchLimit returns how many descriptors the watches may take: at most
+// --max-watches, and what the limit of the process leaves.
+func watchLimit() int {
+	limit := 1 << 30
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil && int64(rl.Cur) > fdReserve {
+		limit = int(int64(rl.Cur) - fdReserve)
+	}
+	if *max_watches > 0 && *max_watches < limit {
+		limit = *max_watches
+	}
+	return limit
+}
+
+// addTree watches dir, the directories below it and the files that count,
+// and returns the subtrees that didn't fit into the limit.
+func (w *kqueue) addTree(dir string) (overflow []string) {
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if skipped(w.root, p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if w.full || !w.add(p, info.IsDir()) && w.full {
+			if info.IsDir() {
+				overflow = append(overflow, p)
+				return filepath.SkipDir
+			}
+			// The files left in the directory are polled with it.
+			overflow = append(overflow, filepath.Dir(p))
+		}
+		return nil
+	})
+	return
+}
+
+// add watches p, and for a directory its entries, and reports whether it
+// could.
+func (w *kqueue) add(p string, isDir bool) bool {
+	if _, ok := w.fds[p]; ok {
+		return true
+	}
+	if len(w.fds) >= w.limit {
+		w.full = true
+		return false
+	}
+	fd, err := syscall.Open(p, watchOpen|syscall.O_CLOEXEC, 0)
+	if err == syscall.EMFILE || err == syscall.ENFILE {
+		w.full = true
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	var ev [1]syscall.Kevent_t
+	syscall.SetKevent(&ev[0], fd, syscall.EVFILT_VNODE, syscall.EV_ADD|syscall.EV_CLEAR)
+	ev[0].Fflags = vnodeNotes
+	if _, err := syscall.Kevent(w.fd, ev[:], nil, nil); err != nil {
+		syscall.Close(fd)
+		return false
+	}
+	w.paths[fd], w.fds[p] = p, fd
+	if isDir {
+		w.entries[p] = readNames(p)
+	}
+	return true
+}
+
+// remove drops the watches of p, which is gone, and of what was below it.
+func (w *kqueue) remove(p string) {
+	for q, fd := range w.fds {
+		if q == p || below(p, q) {
+			syscall.Close(fd)
+			delete(w.paths, fd)
+			delete(w.fds, q)
+			delete(w.entries, q)
+		}
+	}
+}
+
+func readNames(dir string) map[string]bool {
+	names := map[string]bool{}
+	f, err := os.Open(dir)
+	if err != nil {
+		return names
+	}
+	defer f.Close()
+	list, _ := f.Readdirnames(-1)
+	for _, name := range list {
+		names[name] = true
+	}
+	return names
+}
+
+// poll reports the exhausted limit and falls back to polling for the
+// overflowed subtrees.
+func (w *kqueue) poll(overflow []string) {
+	var trees []string
+	for _, p := range overflow {
+		covered := false
+		for _, t := range append(w.polled, trees...) {
+			covered = covered || below(t, p)
+		}
+		if !covered {
+			trees = append(trees, p)
+		}
+	}
+	if len(trees) == 0 {
+		return
+	}
+	w.polled = append(w.polled, trees...)
+
+	if *max_watches > 0 && w.limit == *max_watches {
+		log("--max-watches %d reached, polling %d subtree(s) that have no watch", *max_watches, len(trees))
+	} else {
+		log("kqueue watches took the descriptor limit of %d, polling %d subtree(s) that have no watch; raise it with ulimit -n", w.limit+fdReserve, len(trees))
+	}
+	go pollTrees(w.root, trees, w.done, func(paths []string) {
+		for _, p := range paths {
+			w.notify(p)
+		}
+	})
+}
+
+// notify queues a changed path. When the queue is full the change is
+// dropped; the queued ones trigger the cycle anyway. Once the watch ended
+// there is no queue.
+func (w *kqueue) notify(p string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	select {
+	case w.changes <- p:
+	default:
+	}
+}
+
+// stop ends the watch with err: the pollers stop, and watchEvents returns
+// once it read the changes queued before.
+func (w *kqueue) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+	close(w.done)
+	close(w.changes)
+}
+
+func (w *kqueue) read() {
+	events := make([]syscall.Kevent_t, 64)
+	for {
+		n, err := syscall.Kevent(w.fd, nil, events, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			w.stop(err)
+			return
+		}
+		for _, ev := range events[:n] {
+			if w.handle(int(ev.Ident), uint32(ev.Fflags)) {
+				w.remove(w.root)
+				syscall.Close(w.fd)
+				w.stop(errGone)
+				return
+			}
+		}
+	}
+}
+
+// handle processes the events of the watched descriptor fd and reports
+// whether the root is gone.
+func (w *kqueue) handle(fd int, notes uint32) (gone bool) {
+	p, ok := w.paths[fd]
+	if !ok {
+		return false
+	}
+	names, isDir := w.entries[p]
+	if notes&(syscall.NOTE_DELETE|syscall.NOTE_RENAME) != 0 {
+		if p == w.root {
+			return true
+		}
+		w.remove(p)
+		// A file replaced by a rename, as editors save, is watched anew.
+		if info, err := os.Lstat(p); err == nil && !isDir && !info.IsDir() {
+			w.add(p, false)
+			w.changed(p, false, "move")
+		}
+		// Otherwise the write of the directory reports it.
+		return false
+	}
+
+	if isDir && notes&syscall.NOTE_WRITE != 0 {
+		now := readNames(p)
+		for name := range names {
+			if !now[name] {
+				q := filepath.Join(p, name)
+				_, wasDir := w.entries[q]
+				w.remove(q)
+				w.changed(q, wasDir, "delete")
+			}
+		}
+		for name := range now {
+			if names[name] {
+				continue
+			}
+			q := filepath.Join(p, name)
+			info, err := os.Lstat(q)
+			if err != nil {
+				continue
+			}
+			if !skipped(w.root, q, info.IsDir()) {
+				if info.IsDir() {
+					w.poll(w.addTree(q))
+				} else if !w.add(q, false) && w.full {
+					w.poll([]string{p})
+				}
+			}
+			w.changed(q, info.IsDir(), "create")
+		}
+		w.entries[p] = now
+	}
+	if !isDir && notes&(syscall.NOTE_WRITE|syscall.NOTE_EXTEND) != 0 {
+		w.changed(p, false, "modify")
+	}
+	if notes&syscall.NOTE_ATTRIB != 0 {
+		w.changed(p, isDir, "attrib")
+	}
+	return false
+}
+
+// changed queues p for an event of --inotify-events kind, if it counts as
+// a change of the tree.
+func (w *kqueue) changed(p string, isDir bool, kind string) {
+	if reason := skipReason(w.root, p, isDir); reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return
+	}
+	if fi, err := os.Stat(p); err == nil && selfWritten(p, fi.ModTime()) {
+		explainf(p, fi.ModTime(), "written by the hooks of the last cycle")
+		return
+	}
+	if reason := constraintReason(p); !isDir && reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return
+	}
+	if !w.report[kind] {
+		explainf(p, time.Now(), "the event isn't one of --inotify-events %s", *inotify_mask)
+		return
+	}
+	w.notify(p)
+}
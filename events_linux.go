@@ -0,0 +1,343 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyBits are the events selected by the names of --inotify-events.
+var inotifyBits = map[string]uint32{
+	"modify": syscall.IN_MODIFY,
+	"attrib": syscall.IN_ATTRIB,
+	"create": syscall.IN_CREATE,
+	"delete": syscall.IN_DELETE,
+	"move":   syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO,
+}
+
+// treeMask are the events needed to keep up with new and moved
+// directories, whether they count as changes or not.
+const treeMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+func reportedEvents() (mask uint32) {
+	for _, name := range splitList(*inotify_mask) {
+		mask |= inotifyBits[name]
+	}
+	return
+}
+
+// inotify watches a tree with one inotify watch per directory. Directories
+// that can't get a watch because the per-user limit is exhausted are
+// polled instead.
+type inotify struct {
+	fd      int
+	root    string
+	dirs    map[int32]string
+	changes chan string
+	report  uint32 // events that count as changes
+	crossed map[string]string
+	remote  []string // subtrees on crossed mounts
+	polled  []string // subtrees without a watch
+	full    bool
+	gone    bool
+	err     error
+
+	// mu orders the sends of the pollers on changes before it is closed,
+	// and done, closed with it, stops them.
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+func watchEvents(dir string, cb scanCallback) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	w := &inotify{
+		fd:      fd,
+		root:    dir,
+		dirs:    map[int32]string{},
+		changes: make(chan string, 256),
+		report:  reportedEvents(),
+		crossed: crossedMounts(dir),
+		done:    make(chan struct{}),
+	}
+
+	overflow, err := w.addTree(dir)
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	w.poll(overflow)
+	w.pollRemote()
+
+	log("watching: %s (inotify)", dir)
+	go w.read()
+
+	b := newBatch(dir)
+	b.settle = *event_latency
+	interval := 100 * time.Millisecond
+	if *event_latency > 0 && *event_latency < interval {
+		interval = *event_latency
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case p, ok := <-w.changes:
+			if !ok {
+				return w.err
+			}
+			b.add(p, time.Now())
+		case <-tick.C:
+		}
+
+		if b.ready() {
+			started := snapshotPaths(b.paths)
+			b.flush(cb)
+			requeue(w.changes, b, started)
+		}
+	}
+}
+
+// addTree adds a watch for every directory below dir and returns the
+// subtrees that didn't fit into the watch limit.
+func (w *inotify) addTree(dir string) (overflow []string, err error) {
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if skipped(w.root, p, true) {
+			return filepath.SkipDir
+		}
+		if _, ok := w.crossed[p]; ok {
+			w.remote = append(w.remote, p)
+			return filepath.SkipDir
+		}
+		if w.full || (*max_watches > 0 && len(w.dirs) >= *max_watches) {
+			w.full = true
+			overflow = append(overflow, p)
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, p, w.report|treeMask)
+		if err == syscall.ENOSPC {
+			w.full = true
+			overflow = append(overflow, p)
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = p
+		return nil
+	})
+	return
+}
+
+// poll reports the exhausted watch limit and falls back to polling for the
+// overflowed subtrees that aren't polled yet.
+func (w *inotify) poll(overflow []string) {
+	var trees []string
+	for _, p := range overflow {
+		covered := false
+		for _, t := range append(w.polled, trees...) {
+			covered = covered || below(t, p)
+		}
+		if !covered {
+			trees = append(trees, p)
+		}
+	}
+	if len(trees) == 0 {
+		return
+	}
+	w.polled = append(w.polled, trees...)
+
+	needed := len(w.dirs)
+	for _, tree := range w.polled {
+		needed += countDirs(w.root, tree)
+	}
+	if *max_watches > 0 && len(w.dirs) >= *max_watches {
+		log("--max-watches %d reached: tree needs %d watches, polling %d subtree(s) that have no watch", *max_watches, needed, len(trees))
+	} else {
+		limit := "unknown"
+		if b, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches"); err == nil {
+			limit = strings.TrimSpace(string(b))
+		}
+		log("inotify watch limit exhausted: tree needs %d watches, fs.inotify.max_user_watches is %s", needed, limit)
+		log("polling %d subtree(s) that have no watch, raise the limit with: sysctl fs.inotify.max_user_watches=%d", len(trees), needed*2)
+	}
+
+	go pollTrees(w.root, trees, w.done, func(paths []string) {
+		for _, p := range paths {
+			w.notify(p)
+		}
+	})
+}
+
+// pollRemote polls the content hashes of the subtrees on network or
+// virtualized filesystems, whose changes from the other side inotify
+// doesn't see.
+func (w *inotify) pollRemote() {
+	if len(w.remote) == 0 {
+		return
+	}
+	for _, p := range w.remote {
+		log("%s is on %s, across the boundary of a VM or the network; polling its content hashes every %s", p, w.crossed[p], hashInterval())
+	}
+	go func() {
+		h := newHashPoller(w.root, w.remote)
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(hashInterval()):
+			}
+			for _, p := range h.next() {
+				w.notify(p)
+			}
+		}
+	}()
+}
+
+// notify queues a changed path. When the queue is full the change is
+// dropped; the queued ones trigger the cycle anyway. Once the watch ended
+// there is no queue.
+func (w *inotify) notify(p string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	select {
+	case w.changes <- p:
+	default:
+	}
+}
+
+// stop ends the watch with err: the pollers stop, and watchEvents returns
+// once it read the changes queued before.
+func (w *inotify) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+	close(w.done)
+	close(w.changes)
+}
+
+func (w *inotify) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			w.stop(err)
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n && !w.gone; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := ""
+			if ev.Len > 0 {
+				b := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				name = strings.TrimRight(string(b), "\x00")
+			}
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if p, ok := w.handle(ev.Wd, ev.Mask, name); ok {
+				w.notify(p)
+			}
+		}
+
+		if w.gone {
+			syscall.Close(w.fd)
+			w.stop(errGone)
+			return
+		}
+	}
+}
+
+// handle processes a single event and returns the changed path, if the
+// event counts as a change of the tree.
+func (w *inotify) handle(wd int32, mask uint32, name string) (string, bool) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.root, true
+	}
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return "", false
+	}
+	if dir == w.root && mask&(syscall.IN_IGNORED|syscall.IN_MOVE_SELF) != 0 {
+		w.gone = true
+		return "", false
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		return "", false
+	}
+	if mask&syscall.IN_MOVE_SELF != 0 {
+		return "", false
+	}
+	p := filepath.Join(dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	if reason := skipReason(w.root, p, isDir); reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return "", false
+	}
+
+	if fi, err := os.Stat(p); err == nil && selfWritten(p, fi.ModTime()) {
+		explainf(p, fi.ModTime(), "written by the hooks of the last cycle")
+		return "", false
+	}
+	if reason := constraintReason(p); !isDir && reason != "" {
+		explainf(p, time.Now(), "%s", reason)
+		return "", false
+	}
+
+	if isDir && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		overflow, err := w.addTree(p)
+		if err != nil {
+			log("inotify: %s", err)
+		}
+		w.poll(overflow)
+	}
+	if mask&w.report == 0 {
+		explainf(p, time.Now(), "the event isn't one of --inotify-events %s", *inotify_mask)
+		return "", false
+	}
+	return p, true
+}
+
+func countDirs(root, tree string) (n int) {
+	filepath.Walk(tree, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if skipped(root, p, true) {
+			return filepath.SkipDir
+		}
+		n++
+		return nil
+	})
+	return
+}
@@ -0,0 +1,13 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package main
+
+import "errors"
+
+func watchEvents(dir string, cb scanCallback) error {
+	return errors.New("not supported on this platform")
+}
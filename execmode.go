@@ -0,0 +1,60 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strconv"
+	"time"
+)
+
+// With --exec-mode rerun builds the program once and execs it, so the
+// program keeps rerun's pid, e.g. 1 in a container. A sidecar, rerun again
+// with $RERUN_EXEC_PID set, watches and builds, and has the program exec
+// the new binary, $RERUN_EXEC_BIN, with SIGUSR2.
+var (
+	exec_mode    = flag.Bool("exec-mode", false, "after the first build, exec the program in place of rerun, e.g. as PID 1 of a container, and rebuild from a sidecar that swaps the binary with SIGUSR2 (Linux)")
+	exec_timeout = flag.Duration("exec-timeout", 5*time.Second, "how long the sidecar of --exec-mode waits for the program to exec the new binary")
+)
+
+// execPid is, in the sidecar, the pid of the program rerun became.
+var execPid int
+
+// setupExecMode becomes the program, or in the sidecar, takes the program
+// for the running one and leaves the first build to it.
+func setupExecMode(buildpath string, args []string) error {
+	if !*exec_mode {
+		return nil
+	}
+	if s := os.Getenv("RERUN_EXEC_PID"); s != "" {
+		pid, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		execPid = pid
+		*no_initial_run = true
+		*resume_last = false
+		launched(pid)
+		log("exec-mode: watching for the program, pid %d", pid)
+		go watchExecParent()
+		return nil
+	}
+	if isScript(buildpath) || isPattern(buildpath) || *cmd_template != "" || *bazel_target != "" || tinygoEnabled() || *use_gomobile != "" || !runner.local() {
+		return errors.New("it builds and runs a single Go package here")
+	}
+	return becomeProgram(buildpath, args)
+}
+
+// watchExecParent ends the sidecar along with the program, its parent.
+func watchExecParent() {
+	for range time.Tick(time.Second) {
+		if os.Getppid() != execPid {
+			log("exec-mode: the program exited")
+			exit(0)
+		}
+	}
+}
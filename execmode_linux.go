@@ -0,0 +1,67 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// becomeProgram builds the program, starts the sidecar and execs the
+// program. It returns only on failure.
+func becomeProgram(buildpath string, args []string) error {
+	t, err := resolveMain(buildpath)
+	if err != nil {
+		return err
+	}
+	if ok, _ := install(t.buildpath); !ok {
+		return errors.New("the first build failed")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	pid := os.Getpid()
+	sidecar := exec.Command(self, os.Args[1:]...)
+	sidecar.Env = append(os.Environ(), "RERUN_EXEC_PID="+strconv.Itoa(pid))
+	sidecar.Stdout, sidecar.Stderr = os.Stdout, os.Stderr
+	if err := sidecar.Start(); err != nil {
+		return err
+	}
+
+	log("exec-mode: becoming %s, pid %d; sidecar pid %d", t.bin, pid, sidecar.Process.Pid)
+	env := append(environ(), "RERUN_EXEC_BIN="+t.bin)
+	err = syscall.Exec(t.bin, append([]string{t.bin}, args...), env)
+	sidecar.Process.Kill()
+	return err
+}
+
+// swapExec has the program exec the new build bin with SIGUSR2, and waits
+// until /proc shows it runs bin. The sidecar can't stop the program.
+func swapExec(bin string, relaunch bool) {
+	if !relaunch {
+		log("exec-mode: the program keeps running the last build")
+		return
+	}
+	exe := "/proc/" + strconv.Itoa(execPid) + "/exe"
+	if err := syscall.Kill(execPid, syscall.SIGUSR2); err != nil {
+		log("exec-mode: %s", err)
+		return
+	}
+	for deadline := time.Now().Add(*exec_timeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		running, err1 := os.Stat(exe)
+		built, err2 := os.Stat(bin)
+		if err1 == nil && err2 == nil && os.SameFile(running, built) {
+			log("exec-mode: the program runs the new build")
+			return
+		}
+	}
+	log("exec-mode: the program didn't exec %s within %s, see --exec-mode in the README for the SIGUSR2 handler", bin, *exec_timeout)
+}
@@ -0,0 +1,15 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import "errors"
+
+func becomeProgram(buildpath string, args []string) error {
+	return errors.New("it needs Linux")
+}
+
+func swapExec(bin string, relaunch bool) {}
@@ -0,0 +1,184 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var executor_spec = flag.String("executor", "local", "where the program runs: local, ssh:HOST[:DIR], docker:CONTAINER[:DIR] or kubectl:[NAMESPACE/]POD[/CONTAINER][:DIR] or plugin:NAME")
+
+// An executor runs the program somewhere: it makes the binary available
+// there, wraps the command line that starts it, and stops it.
+type executor interface {
+	deploy(bin string) (string, error)
+	command(argv, env []string, dir string) []string
+	stop()
+	local() bool
+}
+
+// runner is the executor of the program.
+var runner executor = localExecutor{}
+
+type localExecutor struct{}
+
+func (localExecutor) deploy(bin string) (string, error) { return bin, nil }
+
+func (localExecutor) command(argv, env []string, dir string) []string { return argv }
+
+// stop does nothing, run signals the local process.
+func (localExecutor) stop() {}
+
+func (localExecutor) local() bool { return true }
+
+// A remoteExecutor copies the binary to a host, container or pod and runs
+// it there with ssh, docker exec or kubectl exec. Ending the local client
+// doesn't end the remote process, so the program records its pid in a file
+// next to the binary, and stop kills it.
+type remoteExecutor struct {
+	kind      string // ssh, docker or kubectl
+	name      string // host, container or pod
+	namespace string
+	container string
+	dir       string
+	pidfile   string
+
+	// system and machine of the remote, found with uname on the first
+	// deploy.
+	unamed          bool
+	system, machine string
+}
+
+func setupExecutor() error {
+	if *executor_spec == "local" || *executor_spec == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(*executor_spec, ":", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return fmt.Errorf("invalid --executor %q", *executor_spec)
+	}
+	if parts[0] == "plugin" {
+		p, err := findPlugin(parts[1])
+		if err != nil {
+			return err
+		}
+		if !p.has("exec") {
+			return fmt.Errorf("--executor: plugin %s doesn't have the exec role", p.name)
+		}
+		runner = pluginExecutor{p}
+		atExit(runner.stop)
+		log("running the program with plugin %s", p.name)
+		return nil
+	}
+	e := &remoteExecutor{kind: parts[0], name: parts[1], dir: "/tmp"}
+	if len(parts) == 3 {
+		e.dir = parts[2]
+	}
+
+	switch e.kind {
+	case "ssh", "docker":
+	case "kubectl":
+		names := strings.Split(e.name, "/")
+		switch len(names) {
+		case 2:
+			e.namespace, e.name = names[0], names[1]
+		case 3:
+			e.namespace, e.name, e.container = names[0], names[1], names[2]
+		}
+	default:
+		return fmt.Errorf("invalid --executor %q: unknown kind %q", *executor_spec, e.kind)
+	}
+	if _, err := exec.LookPath(e.kind); err != nil {
+		return fmt.Errorf("--executor: %s", err)
+	}
+
+	runner = e
+	atExit(e.stop)
+	log("running the program with %s on %s", e.kind, e.name)
+	return nil
+}
+
+func (e *remoteExecutor) local() bool { return false }
+
+// exec returns the command that runs the shell script s remotely.
+func (e *remoteExecutor) exec(s string) []string {
+	switch e.kind {
+	case "ssh":
+		return []string{"ssh", e.name, s}
+	case "docker":
+		return []string{"docker", "exec", e.name, "sh", "-c", s}
+	}
+	argv := []string{"kubectl", "exec"}
+	if e.namespace != "" {
+		argv = append(argv, "-n", e.namespace)
+	}
+	argv = append(argv, e.name)
+	if e.container != "" {
+		argv = append(argv, "-c", e.container)
+	}
+	return append(argv, "--", "sh", "-c", s)
+}
+
+func (e *remoteExecutor) deploy(bin string) (string, error) {
+	if !e.unamed {
+		e.unamed = true
+		e.system, e.machine = remoteMachine(e.exec("uname -s -m"))
+	}
+	if err := checkArch(bin, e.system, e.machine); err != nil {
+		return "", err
+	}
+	dst := path.Join(e.dir, filepath.Base(bin))
+	e.pidfile = dst + ".pid"
+
+	var argv []string
+	switch {
+	case e.kind == "ssh" && e.name == remoteBuilt.host && bin == remoteBuilt.local:
+		// --remote-build left the binary on the host.
+		argv = e.exec("cp " + shellQuote(remoteBuilt.bin) + " " + shellQuote(dst))
+	case e.kind == "ssh":
+		argv = []string{"scp", "-q", bin, e.name + ":" + dst}
+	case e.kind == "docker":
+		argv = []string{"docker", "cp", bin, e.name + ":" + dst}
+	default:
+		argv = []string{"kubectl", "cp"}
+		if e.namespace != "" {
+			argv = append(argv, "-n", e.namespace)
+		}
+		argv = append(argv, bin, e.name+":"+dst)
+		if e.container != "" {
+			argv = append(argv, "-c", e.container)
+		}
+	}
+	if out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("copying the binary to %s: %s: %s", e.name, err, strings.TrimSpace(string(out)))
+	}
+	return dst, nil
+}
+
+func (e *remoteExecutor) command(argv, env []string, dir string) []string {
+	if dir == "" {
+		dir = e.dir
+	}
+	s := fmt.Sprintf("echo $$ > %s && cd %s && exec", shellQuote(e.pidfile), shellQuote(dir))
+	if len(env) > 0 {
+		s += " env " + shellJoin(env)
+	}
+	return e.exec(s + " " + shellJoin(argv))
+}
+
+func (e *remoteExecutor) stop() {
+	if e.pidfile == "" {
+		return
+	}
+	pf := shellQuote(e.pidfile)
+	argv := e.exec(fmt.Sprintf("test -f %s && kill $(cat %s) 2>/dev/null; rm -f %s", pf, pf, pf))
+	exec.Command(argv[0], argv[1:]...).Run()
+}
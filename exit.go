@@ -0,0 +1,69 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	exitMu    sync.Mutex
+	exitFuncs []func()
+)
+
+// atExit registers f to run when rerun shuts down. Functions run in
+// reverse order of registration.
+func atExit(f func()) {
+	exitMu.Lock()
+	exitFuncs = append(exitFuncs, f)
+	exitMu.Unlock()
+}
+
+// exit runs the registered functions and terminates rerun.
+func exit(code int) {
+	exitMu.Lock()
+	funcs := exitFuncs
+	exitFuncs = nil
+	exitMu.Unlock()
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+	os.Exit(code)
+}
+
+// handleSignals shuts down cleanly on interrupt and termination.
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-c
+		log("%s, shutting down", sig)
+		interruptProgram()
+		exit(1)
+	}()
+}
+
+// notifyHangup relays SIGHUP to c.
+func notifyHangup(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// interruptProgram interrupts the program when rerun ends. The program
+// has a process group of its own, so ^C in the terminal only reaches
+// rerun.
+func interruptProgram() {
+	launchMu.Lock()
+	pid := childPid
+	launchMu.Unlock()
+	if p, err := os.FindProcess(pid); pid != 0 && err == nil {
+		signalProgram(p, os.Interrupt)
+		wakeSuspended(pid)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An exitInfo is the decoded exit status of the program.
+type exitInfo struct {
+	Code   int    `json:"code"`             // -1 when a signal ended it
+	Signal string `json:"signal,omitempty"` // such as SIGSEGV
+	Core   bool   `json:"core,omitempty"`   // a core was dumped
+	OOM    bool   `json:"oom,omitempty"`    // the kernel's OOM killer ended it
+}
+
+// stopping holds the programs rerun stopped, whose end needn't be
+// reported unless it went wrong.
+var stopping struct {
+	sync.Mutex
+	pids map[int]bool
+}
+
+// stopRequested records that rerun stops pid.
+func stopRequested(pid int) {
+	stopping.Lock()
+	defer stopping.Unlock()
+	if stopping.pids == nil {
+		stopping.pids = map[int]bool{}
+	}
+	stopping.pids[pid] = true
+}
+
+func takeStopRequested(pid int) bool {
+	stopping.Lock()
+	defer stopping.Unlock()
+	requested := stopping.pids[pid]
+	delete(stopping.pids, pid)
+	return requested
+}
+
+// reportExit reports how the program pid ended, unless rerun stopped it and
+// nothing went wrong.
+func reportExit(pid int, e exitInfo, uptime time.Duration) {
+	requested := takeStopRequested(pid)
+	if requested && !e.Core && !e.OOM {
+		return
+	}
+
+	var s string
+	switch {
+	case e.Signal != "":
+		s = "was killed by " + e.Signal
+	case e.Code == 0:
+		s = "exited"
+	default:
+		s = fmt.Sprintf("exited with code %d", e.Code)
+	}
+	var notes []string
+	if e.Core {
+		notes = append(notes, "core dumped")
+	}
+	if e.OOM {
+		notes = append(notes, "out of memory, the kernel's OOM killer ended it")
+	}
+	if len(notes) > 0 {
+		s += " (" + strings.Join(notes, ", ") + ")"
+	}
+	log("the program %s after %s", s, uptime.Round(time.Millisecond))
+	setTitle("the program %s", s)
+}
@@ -0,0 +1,79 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP", syscall.SIGINT: "SIGINT", syscall.SIGQUIT: "SIGQUIT", syscall.SIGILL: "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP", syscall.SIGABRT: "SIGABRT", syscall.SIGBUS: "SIGBUS", syscall.SIGFPE: "SIGFPE",
+	syscall.SIGKILL: "SIGKILL", syscall.SIGUSR1: "SIGUSR1", syscall.SIGSEGV: "SIGSEGV", syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE", syscall.SIGALRM: "SIGALRM", syscall.SIGTERM: "SIGTERM", syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ", syscall.SIGSYS: "SIGSYS",
+}
+
+// oomKills returns the number of processes the OOM killer ended in the
+// cgroup of rerun, which the program shares, or -1 without cgroup v2.
+func oomKills() int {
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil || !strings.HasPrefix(string(b), "0::") {
+		return -1
+	}
+	dir := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0][3:])
+	b, err = ioutil.ReadFile(filepath.Join("/sys/fs/cgroup", dir, "memory.events"))
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "oom_kill" {
+			n, _ := strconv.Atoi(f[1])
+			return n
+		}
+	}
+	return -1
+}
+
+// decodeExit decodes the status of the program pid. A SIGKILL is taken for
+// the OOM killer when the oom_kill count of the cgroup went up since
+// oomBefore, or when the kernel log says so.
+func decodeExit(ps *os.ProcessState, pid, oomBefore int) exitInfo {
+	e := exitInfo{Code: ps.ExitCode()}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return e
+	}
+	sig := ws.Signal()
+	e.Signal = signalNames[sig]
+	if e.Signal == "" {
+		e.Signal = fmt.Sprintf("signal %d", int(sig))
+	}
+	e.Signal += " (" + sig.String() + ")"
+	e.Core = ws.CoreDump()
+
+	if sig == syscall.SIGKILL {
+		if oomBefore >= 0 && oomKills() > oomBefore {
+			e.OOM = true
+		} else if out, err := exec.Command("dmesg").Output(); err == nil {
+			// "Out of memory: Killed process 1234 (name)" or
+			// "oom-kill:...,task=name,pid=1234,uid=0".
+			id := strconv.Itoa(pid)
+			for _, line := range strings.Split(string(out), "\n") {
+				if strings.Contains(line, "Killed process "+id+" ") || strings.Contains(line, ",pid="+id+",") {
+					e.OOM = true
+				}
+			}
+		}
+	}
+	return e
+}
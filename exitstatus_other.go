@@ -0,0 +1,28 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+func oomKills() int { return -1 }
+
+// decodeExit takes the signal from the description of the status, as in
+// "signal: segmentation fault (core dumped)".
+func decodeExit(ps *os.ProcessState, pid, oomBefore int) exitInfo {
+	e := exitInfo{Code: ps.ExitCode()}
+	if s := ps.String(); strings.HasPrefix(s, "signal: ") {
+		s = strings.TrimPrefix(s, "signal: ")
+		if strings.HasSuffix(s, " (core dumped)") {
+			s, e.Core = strings.TrimSuffix(s, " (core dumped)"), true
+		}
+		e.Signal = s
+	}
+	return e
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var explain = flag.Bool("explain", false, "explain changes that don't trigger a cycle")
+
+var (
+	explainMu   sync.Mutex
+	explainSeen = map[string]time.Time{}
+)
+
+// explainf tells why the change of p, identified by stamp, didn't start a
+// cycle. Each change is explained once. With --journal the explanation is
+// recorded too.
+func explainf(p string, stamp time.Time, format string, args ...interface{}) {
+	if !*explain {
+		return
+	}
+
+	explainMu.Lock()
+	prev, ok := explainSeen[p]
+	explainSeen[p] = stamp
+	explainMu.Unlock()
+	if ok && prev.Equal(stamp) {
+		return
+	}
+
+	reason := fmt.Sprintf(format, args...)
+	log("%s: %s", p, reason)
+	writeJournal(struct {
+		Time   time.Time `json:"time"`
+		Event  string    `json:"event"`
+		Path   string    `json:"path"`
+		Reason string    `json:"reason"`
+	}{time.Now(), "suppressed", p, reason})
+}
+
+// explainTree explains the files below dir whose state changed since
+// states recorded it, and records them. The baseline is only recorded.
+func explainTree(dir string, states treeSnapshot, baseline bool, reason string) {
+	if !*explain {
+		return
+	}
+
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && states.update(p, info) && !baseline {
+			explainf(p, info.ModTime(), "%s", reason)
+		}
+		return nil
+	})
+}
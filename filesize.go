@@ -0,0 +1,70 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var (
+	max_file_size = flag.String("max-file-size", "", "files bigger than this, e.g. 5MB, don't start a cycle unless --include matches them")
+	include       = flag.String("include", "", "comma-separated name patterns of files that start a cycle whatever their size or extension, e.g. 'schema.db'")
+)
+
+// included reports whether --include names p.
+func included(p string) bool {
+	base := path.Base(filepath.ToSlash(p))
+	for _, pattern := range splitList(*include) {
+		if match, _ := path.Match(pattern, base); match {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaxFileSize validates --max-file-size.
+func checkMaxFileSize() error {
+	if *max_file_size == "" {
+		return nil
+	}
+	_, err := parseSize(*max_file_size)
+	return err
+}
+
+// dropLarge leaves out the files of paths that are too big to start a
+// cycle.
+func dropLarge(paths []string) []string {
+	if *max_file_size == "" {
+		return paths
+	}
+	var kept []string
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			if reason := sizeReason(p, fi.Size()); reason != "" {
+				explainf(p, fi.ModTime(), "%s", reason)
+				continue
+			}
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// sizeReason names the --max-file-size rule that keeps a change of p, size
+// bytes long, from starting a cycle, if any. Databases and media files
+// change often and are data, not source.
+func sizeReason(p string, size int64) string {
+	if *max_file_size == "" || included(p) {
+		return ""
+	}
+	limit, err := parseSize(*max_file_size)
+	if err != nil || size <= limit {
+		return ""
+	}
+	return "bigger than --max-file-size " + *max_file_size
+}
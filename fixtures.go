@@ -0,0 +1,150 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// A fixture is a service, such as a database, that rerun starts before the
+// first cycle and tears down on exit.
+type fixture struct {
+	Name string `json:"name"`
+
+	// Start is run to completion, e.g. "docker run -d ...". Run is a long
+	// running command that rerun keeps alive until it exits itself.
+	Start string `json:"start"`
+	Run   string `json:"run"`
+
+	// Ready is retried until it succeeds or Timeout passes.
+	Ready   string `json:"ready"`
+	Timeout string `json:"timeout"`
+
+	Stop string `json:"stop"`
+
+	// Env is exported to the tests and the program.
+	Env map[string]string `json:"env"`
+}
+
+// fixtureEnv holds the variables exported by the running fixtures.
+var fixtureEnv []string
+
+// environ returns the environment for tests and the program.
+func environ() []string {
+	return append(os.Environ(), extraEnv()...)
+}
+
+// extraEnv returns the variables rerun adds to the environment.
+func extraEnv() []string {
+	env := append(buildEnv(), fixtureEnv...)
+	for _, k := range sortedKeys(conf.Env) {
+		env = append(env, k+"="+conf.Env[k])
+	}
+	return env
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func startFixtures() error {
+	for i := range conf.Fixtures {
+		if err := conf.Fixtures[i].start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fixture) start() error {
+	log("starting fixture %s", f.Name)
+
+	if f.Start != "" {
+		if err := runShell(f.Start); err != nil {
+			return fmt.Errorf("fixture %s: %s", f.Name, err)
+		}
+	}
+
+	if f.Run != "" {
+		cmd := shellCommand(f.Run)
+		cmd.Stdout = redactOutput(os.Stdout)
+		cmd.Stderr = redactOutput(os.Stderr)
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("fixture %s: %s", f.Name, err)
+		}
+		go cmd.Wait()
+		atExit(func() {
+			cmd.Process.Kill()
+		})
+	}
+
+	if f.Stop != "" {
+		atExit(func() {
+			log("stopping fixture %s", f.Name)
+			if err := runShell(f.Stop); err != nil {
+				log("fixture %s: %s", f.Name, err)
+			}
+		})
+	}
+
+	if err := f.wait(); err != nil {
+		return fmt.Errorf("fixture %s: %s", f.Name, err)
+	}
+
+	for _, k := range sortedKeys(f.Env) {
+		fixtureEnv = append(fixtureEnv, k+"="+f.Env[k])
+	}
+	// The new variables may be secrets to mask before they are printed.
+	updateRedaction()
+	for _, k := range sortedKeys(f.Env) {
+		log("fixture %s: %s=%s", f.Name, k, f.Env[k])
+	}
+	return nil
+}
+
+// wait retries the ready check until it passes.
+func (f *fixture) wait() error {
+	if f.Ready == "" {
+		return nil
+	}
+
+	timeout := 30 * time.Second
+	if f.Timeout != "" {
+		d, err := time.ParseDuration(f.Timeout)
+		if err != nil {
+			return err
+		}
+		timeout = d
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		cmd := shellCommand(f.Ready)
+		if cmd.Run() == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready after %s", timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// runShell runs s to completion and returns its output with the error.
+func runShell(s string) error {
+	out, err := shellCommand(s).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s\n%s", s, err, out)
+	}
+	return nil
+}
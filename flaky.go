@@ -0,0 +1,240 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A flakeTracker follows the results of the tests from cycle to cycle and
+// counts the flips between pass and fail that no change to the test's
+// package or its dependencies explains.
+type flakeTracker struct {
+	changes [][]string // the changed files of every cycle, in order
+	last    map[string]lastResult
+	tests   map[string]*flakyTest
+	deps    map[string][]string // package -> directories of it and its dependencies
+}
+
+type lastResult struct {
+	action string
+	at     int // len(changes) after the cycle of the result
+}
+
+type flakyTest struct {
+	Package   string
+	Test      string
+	Runs      int
+	Fails     int
+	Unrelated int // flips without a related change
+}
+
+func newFlakeTracker() *flakeTracker {
+	return &flakeTracker{
+		last:  map[string]lastResult{},
+		tests: map[string]*flakyTest{},
+		deps:  map[string][]string{},
+	}
+}
+
+// add records the test results of a cycle that saw the changed files, and
+// returns the tests that flipped without a related change.
+func (f *flakeTracker) add(changed []string, results []testResult) (flagged []*flakyTest) {
+	f.changes = append(f.changes, changed)
+	for _, r := range results {
+		if r.Action != "pass" && r.Action != "fail" {
+			continue
+		}
+		key := r.Package + " " + r.Test
+		t := f.tests[key]
+		if t == nil {
+			t = &flakyTest{Package: r.Package, Test: r.Test}
+			f.tests[key] = t
+		}
+		t.Runs++
+		if r.Action == "fail" {
+			t.Fails++
+		}
+		prev, ok := f.last[key]
+		f.last[key] = lastResult{r.Action, len(f.changes)}
+		if !ok || prev.action == r.Action {
+			continue
+		}
+		var since []string
+		for _, c := range f.changes[prev.at:] {
+			since = append(since, c...)
+		}
+		if !f.related(r.Package, since) {
+			t.Unrelated++
+			flagged = append(flagged, t)
+		}
+	}
+	return flagged
+}
+
+// reset forgets the last results, at the start of a session: what changed
+// while rerun didn't watch is unknown.
+func (f *flakeTracker) reset() {
+	f.changes = nil
+	f.last = map[string]lastResult{}
+}
+
+// related reports whether one of the files is a source of pkg or of one of
+// its dependencies, their test data, or go.mod or go.sum. When the
+// dependencies can't be listed, every change counts as related.
+func (f *flakeTracker) related(pkg string, files []string) bool {
+	if len(files) == 0 {
+		return false
+	}
+	dirs, ok := f.deps[pkg]
+	if !ok {
+		out, err := gocmd("list", "-deps", "-test", "-f", "{{.Dir}}", pkg).Output()
+		if err != nil {
+			return true
+		}
+		dirs = strings.Fields(string(out))
+		f.deps[pkg] = dirs
+	}
+	for _, p := range files {
+		if base := filepath.Base(p); base == "go.mod" || base == "go.sum" {
+			return true
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return true
+		}
+		for _, dir := range dirs {
+			if filepath.Dir(abs) == dir || below(filepath.Join(dir, "testdata"), abs) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var flakes = struct {
+	sync.Mutex
+	*flakeTracker
+}{flakeTracker: newFlakeTracker()}
+
+// cycleTests returns the results of the tests of c, with --test-json, if
+// its test phase ran.
+func cycleTests(c *cycle) []testResult {
+	if !*test_json {
+		return nil
+	}
+	for _, p := range c.phases {
+		if p.Name == "test" {
+			return testResults
+		}
+	}
+	return nil
+}
+
+// noteFlakes warns of the tests of the cycle that flipped without a
+// related change.
+func noteFlakes(c *cycle, results []testResult) {
+	if len(results) == 0 {
+		return
+	}
+	flakes.Lock()
+	defer flakes.Unlock()
+	for _, t := range flakes.add(c.changed, results) {
+		result := "passes"
+		if flakes.last[t.Package+" "+t.Test].action == "fail" {
+			result = "fails"
+		}
+		log("flaky? %s in %s %s now without a change to it or its dependencies (%d of %d runs failed), see rerun flaky", t.Test, t.Package, result, t.Fails, t.Runs)
+	}
+}
+
+// flakyCommand reports the tests whose results flipped without a related
+// change, over the cycles of the journals of all the sessions.
+func flakyCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: rerun flaky")
+	}
+	names, _ := filepath.Glob(filepath.Join(stateDir, "sessions", "*", "journal.jsonl"))
+	if _, err := os.Stat(statePath("journal.jsonl")); err == nil || len(names) == 0 {
+		names = append([]string{statePath("journal.jsonl")}, names...)
+	}
+
+	f := newFlakeTracker()
+	cycles := 0
+	for _, name := range names {
+		n, err := readFlakes(f, name)
+		if err != nil {
+			return fmt.Errorf("%s, the history of the tests needs --journal and --test-json", err)
+		}
+		cycles += n
+	}
+	if cycles == 0 {
+		fmt.Println("no test results in the journal, run rerun with --journal and --test-json")
+		return nil
+	}
+
+	var list []*flakyTest
+	for _, t := range f.tests {
+		if t.Unrelated > 0 {
+			list = append(list, t)
+		}
+	}
+	if len(list) == 0 {
+		fmt.Printf("no flaky tests in %d cycles with tests\n", cycles)
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Unrelated != list[j].Unrelated {
+			return list[i].Unrelated > list[j].Unrelated
+		}
+		return list[i].Package+" "+list[i].Test < list[j].Package+" "+list[j].Test
+	})
+	fmt.Printf("%-40s %-30s %6s %6s %9s\n", "TEST", "PACKAGE", "RUNS", "FAILS", "UNRELATED")
+	for _, t := range list {
+		fmt.Printf("%-40s %-30s %6d %6d %9d\n", t.Test, t.Package, t.Runs, t.Fails, t.Unrelated)
+	}
+	fmt.Printf("%d flaky of %d tests in %d cycles; UNRELATED counts the flips between pass and fail without a change to the test's package or its dependencies\n", len(list), len(f.tests), cycles)
+	return nil
+}
+
+// readFlakes adds the test results of the journal name to f and returns
+// the number of cycles with tests.
+func readFlakes(f *flakeTracker, name string) (cycles int, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	f.reset()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev struct {
+			Event   string       `json:"event"`
+			BuildID int          `json:"build_id"`
+			Changed []string     `json:"changed"`
+			Tests   []testResult `json:"tests"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil || ev.Event != "cycle" {
+			continue
+		}
+		if ev.BuildID == 1 {
+			f.reset()
+		}
+		if len(ev.Tests) > 0 {
+			cycles++
+			f.add(ev.Changed, ev.Tests)
+		}
+	}
+	return cycles, scanner.Err()
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A generateSnapshot holds the files of the directories with go:generate
+// directives before go generate runs, to undo the writes that leave them
+// as they were: stringer and most other generators rewrite their output
+// whether or not it changes.
+type generateSnapshot map[string]fileState
+
+// snapshotGenerate records the files of the directories below root with a
+// go:generate directive in one of their Go files.
+func snapshotGenerate(root string) generateSnapshot {
+	snap := generateSnapshot{}
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if p != root && skipped(root, p, true) {
+			return filepath.SkipDir
+		}
+		if !hasGenerate(p) {
+			return nil
+		}
+		infos, _ := ioutil.ReadDir(p)
+		for _, fi := range infos {
+			if !fi.Mode().IsRegular() || fi.Size() > largeHash {
+				continue
+			}
+			f := filepath.Join(p, fi.Name())
+			snap[f] = fileState{mtime: fi.ModTime(), size: fi.Size(), sum: hashFile(f)}
+		}
+		return nil
+	})
+	return snap
+}
+
+// hasGenerate reports whether a Go file of dir has a go:generate directive.
+func hasGenerate(dir string) bool {
+	names, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		found := false
+		for s.Scan() {
+			if bytes.HasPrefix(s.Bytes(), []byte("//go:generate ")) {
+				found = true
+				break
+			}
+		}
+		f.Close()
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreUnchanged sets the modification time of the files go generate
+// rewrote with the same bytes back, so no watcher, editor or other rerun
+// takes them for changed, and keeps them for recordWrites.
+func (snap generateSnapshot) restoreUnchanged() {
+	var kept []string
+	for p, old := range snap {
+		fi, err := os.Stat(p)
+		if err != nil || fi.ModTime().Equal(old.mtime) || fi.Size() != old.size || hashFile(p) != old.sum {
+			continue
+		}
+		if err := os.Chtimes(p, time.Now(), old.mtime); err != nil {
+			continue
+		}
+		kept = append(kept, p)
+	}
+
+	unchangedMu.Lock()
+	defer unchangedMu.Unlock()
+	for _, p := range kept {
+		unchanged[p] = snap[p].mtime
+	}
+	if len(kept) != unchangedLogged {
+		unchangedLogged = len(kept)
+		if len(kept) > 0 {
+			log("generate rewrote %d file(s) with the same content, keeping their modification times", len(kept))
+		}
+	}
+}
+
+var (
+	unchangedMu     sync.Mutex
+	unchangedLogged int
+	// unchanged holds the files of the last go generate that were rewritten
+	// without a change, with the modification time they got back.
+	unchanged = map[string]time.Time{}
+)
+
+// takeUnchanged returns and forgets the files of restoreUnchanged.
+func takeUnchanged() map[string]time.Time {
+	unchangedMu.Lock()
+	defer unchangedMu.Unlock()
+	m := unchanged
+	unchanged = map[string]time.Time{}
+	return m
+}
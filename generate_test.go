@@ -0,0 +1,74 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreUnchanged(t *testing.T) {
+	const directive = "package p\n\n//go:generate stringer -type Kind\n"
+	tests := []struct {
+		name    string
+		before  string
+		after   string // "" leaves the file alone
+		restore bool   // the old modification time comes back
+	}{
+		{"kind.go", directive, "", false},
+		{"kind_string.go", "package p\n// generated\n", "package p\n// generated\n", true},
+		{"table.go", "package p\nvar t = 1\n", "package p\nvar t = 2\n", false},
+		{"longer.go", "package p\n", "package p\n\n", false},
+		{"sub/other.go", "package sub\n", "package sub\n", false}, // no directive there
+	}
+
+	root := t.TempDir()
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, tt := range tests {
+		p := filepath.Join(root, tt.name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(tt.before), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := snapshotGenerate(root)
+	if _, ok := snap[filepath.Join(root, "sub/other.go")]; ok {
+		t.Errorf("snapshotGenerate took a directory without go:generate directives")
+	}
+	for _, tt := range tests {
+		if tt.after != "" {
+			if err := ioutil.WriteFile(filepath.Join(root, tt.name), []byte(tt.after), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	takeUnchanged()
+	snap.restoreUnchanged()
+	kept := takeUnchanged()
+
+	for _, tt := range tests {
+		p := filepath.Join(root, tt.name)
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := fi.ModTime().Equal(old)
+		if tt.after != "" && restored != tt.restore {
+			t.Errorf("%s: modification time restored = %v, want %v", tt.name, restored, tt.restore)
+		}
+		if _, ok := kept[p]; ok != tt.restore {
+			t.Errorf("%s: kept for recordWrites = %v, want %v", tt.name, ok, tt.restore)
+		}
+	}
+}
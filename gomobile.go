@@ -0,0 +1,148 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	use_gomobile = flag.String("gomobile", "", "build with gomobile: apk installs the app on the device adb sees and restarts it on every change, aar builds an Android library")
+	android_app  = flag.String("android-app", "", "application ID of the app, from AndroidManifest.xml or org.golang.todo.NAME by default")
+)
+
+// manifestRe finds the application ID in an AndroidManifest.xml.
+var manifestRe = regexp.MustCompile(`<manifest[^>]*\spackage="([^"]+)"`)
+
+// setupGomobile replaces the install phase with gomobile build and adb, or
+// gomobile bind for a library. Nothing runs here.
+func setupGomobile(t *target) error {
+	if *use_gomobile == "" {
+		return nil
+	}
+	if *use_gomobile != "apk" && *use_gomobile != "aar" {
+		return fmt.Errorf("--gomobile: %q isn't apk or aar", *use_gomobile)
+	}
+	if tinygoEnabled() || crossCompiling() || *bazel_target != "" {
+		return fmt.Errorf("--gomobile doesn't go with --tinygo, --bazel or the cross compilation flags")
+	}
+	tools := []string{"gomobile"}
+	if *use_gomobile == "apk" {
+		tools = append(tools, "adb")
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("--gomobile: %s", err)
+		}
+	}
+	if *do_build {
+		log("--build doesn't apply with --gomobile, the install phase builds")
+		*do_build = false
+	}
+
+	name := strings.TrimSuffix(filepath.Base(t.bin), ".exe")
+	out, err := filepath.Abs(statePath("gomobile/" + name + "." + *use_gomobile))
+	if err != nil {
+		return err
+	}
+	t.bin = out
+
+	if *use_gomobile == "aar" {
+		install = func(buildpath string) (bool, error) {
+			if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+				return false, err
+			}
+			if ok, err := runStep("gomobile bind", "gomobile", "bind", "-target", "android", "-o", out, buildpath); !ok {
+				return ok, err
+			}
+			reportSuccess("install")
+			return true, nil
+		}
+		if *run_cmd == "" && !*no_run {
+			log("building %s, set --run-cmd to use it, e.g. \"./gradlew installDebug\"", out)
+			*no_run = true
+		}
+		return nil
+	}
+
+	app := *android_app
+	if app == "" {
+		app = manifestApp(t.dir)
+	}
+	if app == "" {
+		app = "org.golang.todo." + name
+	}
+	if state, err := exec.Command("adb", "get-state").Output(); err != nil || strings.TrimSpace(string(state)) != "device" {
+		log("adb sees no device yet, start an emulator or connect one; set ANDROID_SERIAL to pick one of several")
+	}
+
+	install = func(buildpath string) (bool, error) {
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return false, err
+		}
+		if ok, err := runStep("gomobile build", "gomobile", "build", "-target", "android", "-o", out, buildpath); !ok {
+			return ok, err
+		}
+		if ok, err := runStep("adb install", "adb", "install", "-r", out); !ok {
+			return ok, err
+		}
+		// An app that doesn't run can't be stopped, which is fine.
+		exec.Command("adb", "shell", "am", "force-stop", app).Run()
+		if ok, err := runStep("adb start", "adb", "shell", "monkey", "-p", app, "-c", "android.intent.category.LAUNCHER", "1"); !ok {
+			return ok, err
+		}
+		reportSuccess("install")
+		return true, nil
+	}
+	log("installing %s as %s and restarting it on every change", out, app)
+	*no_run = true
+	return nil
+}
+
+// manifestApp returns the application ID of the AndroidManifest.xml of
+// dir, if there is one.
+func manifestApp(dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "AndroidManifest.xml"))
+	if err != nil {
+		return ""
+	}
+	if m := manifestRe.FindSubmatch(b); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// runStep runs a step of an install phase made of several commands, as
+// with --gomobile and --kube. A failed step fails the phase.
+func runStep(step, name string, args ...string) (bool, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = toolEnviron()
+	recordCommand(step, cmd)
+
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+
+	err := cmd.Run()
+	// adb install reports some failures with exit status 0.
+	if err == nil && name == "adb" && strings.Contains(buf.String(), "Failure [") {
+		err = fmt.Errorf("%s failed", step)
+	}
+	if err != nil {
+		log("%s failed", step)
+		reportFailure("install", buf.String())
+		return false, err
+	}
+	log("%s succeeded", step)
+	return true, nil
+}
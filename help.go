@@ -0,0 +1,152 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A flagGroup is a section of the help, also shown by "rerun help TOPIC".
+type flagGroup struct {
+	topic, title string
+	flags        []string
+}
+
+var flagGroups = []flagGroup{
+	{"watch", "Watching", []string{"watch", "watch-replace", "on", "on-branch-switch", "ignore", "no-git", "preset", "ext", "include", "max-file-size", "all-files", "generated", "debounce", "every", "flood", "show-diff", "events", "daemon", "stdin-events", "netfs", "poll-interval", "event-latency", "max-watches", "inotify-events", "explain"}},
+	{"build", "Building", []string{"phases", "test-only", "build-only", "skip-install", "build", "build-flags", "matrix", "go", "goexec", "before", "generate", "chain", "vet", "retries", "deps", "diff-errors", "editor", "verify", "max-size", "vuln", "vuln-strict", "size-alert", "keep-builds", "warm", "goflags", "hermetic", "hermetic-env", "repro", "tinygo", "target", "gomobile", "android-app", "kube", "image", "kube-dockerfile", "kube-load", "remote-build", "bazel", "bazel-cmd"}},
+	{"test", "Testing", []string{"test", "test-json", "cover", "test-cache", "test-tmpdir", "test-in-docker"}},
+	{"run", "Running", []string{"cmd", "no-run", "no-initial-run", "initial-build-only", "resume", "keep-running-on-failure", "run", "run-all", "run-cmd", "wrap", "workdir", "executor", "exec-mode", "exec-timeout", "goos", "goarch", "goarm", "goamd64", "cc", "cxx", "pass-fd", "streams", "stderr-file", "redact", "record-casts", "keep-casts", "debugger", "urls", "monitor", "max-rss", "rss-action", "cleanup-files", "cleanup-timeout"}},
+	{"control", "Control and configuration", []string{"http", "http-pprof", "serve-jsonrpc", "jsonrpc-token", "proxy", "app-port", "on-5xx", "pprof-proxy", "pprof-port", "pre-restart-profile", "journal", "print-commands", "no-title", "plugin", "plugin-dir", "config"}},
+}
+
+const usageHead = `Usage: rerun [flags] [package | file.go | pattern/...] [--] [program args]
+
+rerun watches the sources of a Go program, and tests, builds and restarts it
+whenever they change. Without a package it looks for the main package of the
+current directory. Everything after the package goes to the program, flags
+included; a "--" right after the package is dropped, so the separator may be
+used for clarity.
+
+Commands:
+  rerun init -from air|fresh [-force]       convert another tool's config to .rerun.json
+  rerun [flags] config validate [file]      check a config file, every problem at its line and column
+  rerun [flags] config show [-effective]    print the settings that differ from the defaults, or all
+  rerun [flags] service install|uninstall   run rerun as a user service
+  rerun [flags] daemon [-socket PATH] [dir] share one watch of dir with other reruns
+  rerun [flags] plugins                     list the plugins of --plugin-dir
+  rerun flaky                               report the tests whose results flip without a change
+  rerun status [-porcelain]                 show the reruns of this tree, one line each for prompts
+  rerun [flags] start [-d] [flags] package  run rerun for attach, in the background with -d
+  rerun attach [session]                    follow the output of a rerun start of this tree
+  rerun stop [session]                      stop a rerun of this tree and its program
+  rerun help [topic]                        show this help, or one topic of it
+
+Topics: %s, examples
+`
+
+const usageExamples = `Examples:
+  rerun                                     run the main package of the current directory
+  rerun --test ./cmd/server -- -addr :8080  test, build and run with program flags
+  rerun --preset go-web --proxy :3000 .     run a web server behind a reloading proxy
+  rerun --run-all ./cmd/...                 run every main package below cmd
+  rerun hello.go                            run a single-file script
+  rerun --on commit .                       restart only when a commit lands
+  rerun --cmd 'go test {{.ChangedPkg}}' ./... test the packages that changed
+`
+
+// printUsage prints the help, all of it for topic "", and returns false for an
+// unknown topic.
+func printUsage(w io.Writer, topic string) bool {
+	var topics []string
+	for _, g := range flagGroups {
+		topics = append(topics, g.topic)
+	}
+	switch topic {
+	case "":
+		fmt.Fprintf(w, usageHead, strings.Join(topics, ", "))
+		for _, g := range flagGroups {
+			printGroup(w, g)
+		}
+		if other := ungrouped(); len(other) > 0 {
+			printGroup(w, flagGroup{"", "Other", other})
+		}
+		fmt.Fprintln(w)
+		fmt.Fprint(w, usageExamples)
+		return true
+	case "examples":
+		fmt.Fprint(w, usageExamples)
+		return true
+	}
+	for _, g := range flagGroups {
+		if g.topic == topic {
+			printGroup(w, g)
+			return true
+		}
+	}
+	fmt.Fprintf(w, "unknown help topic %q, choose from %s, examples\n", topic, strings.Join(topics, ", "))
+	return false
+}
+
+func printGroup(w io.Writer, g flagGroup) {
+	fmt.Fprintf(w, "\n%s:\n", g.title)
+	for _, name := range g.flags {
+		f := flag.Lookup(name)
+		if f == nil {
+			continue
+		}
+		kind, text := flag.UnquoteUsage(f)
+		line := "  --" + f.Name
+		if kind != "" {
+			line += " " + kind
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+			text += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "%s\n    \t%s\n", line, text)
+	}
+}
+
+// ungrouped returns the flags missing from flagGroups, so none is left out
+// of the help.
+func ungrouped() []string {
+	grouped := map[string]bool{}
+	for _, g := range flagGroups {
+		for _, name := range g.flags {
+			grouped[name] = true
+		}
+	}
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	return names
+}
+
+// helpCommand implements "rerun help [topic]".
+func helpCommand(args []string) error {
+	topic := ""
+	if len(args) > 0 {
+		topic = args[0]
+	}
+	if !printUsage(os.Stdout, topic) {
+		os.Exit(2)
+	}
+	return nil
+}
+
+func init() {
+	flag.Usage = func() {
+		printUsage(flag.CommandLine.Output(), "")
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+var (
+	hermetic     = flag.Bool("hermetic", false, "run the toolchain with only an allowlist of the environment and --goflags, and print the command lines of the phases, as --print-commands")
+	hermetic_env = flag.String("hermetic-env", "", "comma-separated variables to pass through with --hermetic besides the defaults, e.g. CC,PKG_CONFIG_PATH")
+	goflags      = flag.String("goflags", "", "GOFLAGS for the toolchain, e.g. \"-mod=readonly -trimpath\"; with --hermetic the inherited GOFLAGS is dropped")
+)
+
+// hermeticAllow are the variables the toolchain keeps with --hermetic: what
+// it needs to find itself, its caches and the module proxy. The go env -w
+// file of the user is left out with GOENV=off, though HOME is kept.
+var hermeticAllow = []string{
+	"PATH", "HOME", "USER", "TMPDIR",
+	"GOPATH", "GOROOT", "GOCACHE", "GOMODCACHE", "GOTOOLCHAIN", "GOOS", "GOARCH", "CGO_ENABLED",
+	"GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE",
+	"SystemRoot", "LOCALAPPDATA", "APPDATA", "USERPROFILE", "TEMP", "TMP", "PATHEXT", "ComSpec", // Windows
+}
+
+// toolBase returns the environment the go command starts from.
+func toolBase() []string {
+	if !*hermetic {
+		if *goflags == "" {
+			return os.Environ()
+		}
+		return append(os.Environ(), "GOFLAGS="+*goflags)
+	}
+	var env []string
+	for _, k := range append(hermeticAllow, splitList(*hermetic_env)...) {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return append(env, "GOENV=off", "GOFLAGS="+*goflags)
+}
+
+// toolEnviron returns the environment of toolchain commands that also see
+// the variables rerun adds, such as go test.
+func toolEnviron() []string {
+	if !*hermetic {
+		return environ()
+	}
+	return append(toolBase(), extraEnv()...)
+}
+
+// currentGoflags returns the GOFLAGS the toolchain gets.
+func currentGoflags() string {
+	if *hermetic || *goflags != "" {
+		return *goflags
+	}
+	return os.Getenv("GOFLAGS")
+}
+
+// reportHermetic prints the environment of the toolchain at startup.
+func reportHermetic() {
+	if !*hermetic {
+		return
+	}
+	log("hermetic toolchain environment: %s", strings.Join(append(toolBase(), toolEnv...), " "))
+}
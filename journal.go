@@ -0,0 +1,130 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateDir holds the files rerun writes, such as the journal. It is never
+// watched.
+const stateDir = ".rerun"
+
+func statePath(name string) string {
+	return filepath.Join(stateDir, session, name)
+}
+
+// A cycle is one round of test, build and install after a change.
+type cycle struct {
+	id      int
+	hash    string
+	start   time.Time
+	changed []string
+	phases  []phaseResult
+	ok      bool
+}
+
+type phaseResult struct {
+	Name     string  `json:"name"`
+	OK       bool    `json:"ok"`
+	Duration float64 `json:"duration"`
+}
+
+func newCycle(changed []string) *cycle {
+	return &cycle{id: nextBuild(), start: time.Now(), changed: changed}
+}
+
+// phase runs one phase of the cycle and keeps its result.
+func (c *cycle) phase(name string, f func(string) (bool, error), buildpath string) bool {
+	start := time.Now()
+	stop := spin(name)
+	ok, _ := f(buildpath)
+	stop()
+	c.phases = append(c.phases, phaseResult{
+		Name:     name,
+		OK:       ok,
+		Duration: time.Since(start).Seconds(),
+	})
+	return ok
+}
+
+func (c *cycle) done() {
+	recordCycle(c)
+	titleCycle(c.ok)
+	if c.ok {
+		setSessionState("ok", c.id, c.hash)
+	} else {
+		setSessionState("failed", c.id, c.hash)
+	}
+	if interactive {
+		log("%s", toggleStatus())
+	}
+	tests := cycleTests(c)
+	noteFlakes(c, tests)
+	writeJournal(struct {
+		Time     time.Time     `json:"time"`
+		Event    string        `json:"event"`
+		BuildID  int           `json:"build_id"`
+		Hash     string        `json:"hash,omitempty"`
+		Changed  []string      `json:"changed"`
+		OK       bool          `json:"ok"`
+		Phases   []phaseResult `json:"phases"`
+		Duration float64       `json:"duration"`
+		Tests    []testResult  `json:"tests,omitempty"`
+	}{c.start, "cycle", c.id, c.hash, c.changed, c.ok, c.phases, time.Since(c.start).Seconds(), tests})
+}
+
+// journalExit records the end of the program.
+func journalExit(pid int, e exitInfo, uptime time.Duration) {
+	writeJournal(struct {
+		Time  time.Time `json:"time"`
+		Event string    `json:"event"`
+		Pid   int       `json:"pid"`
+		exitInfo
+		Uptime float64 `json:"uptime"`
+	}{time.Now(), "exit", pid, e, uptime.Seconds()})
+}
+
+var journalMu sync.Mutex
+
+// writeJournal appends v to the journal, if --journal is set, and hands it
+// to the notify plugins and the editors of --serve-jsonrpc.
+func writeJournal(v interface{}) {
+	v = redactValue(v)
+	notifyPlugins(v)
+	notifyRPC(v)
+	if !*journal {
+		return
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		log("journal: %s", err)
+		return
+	}
+
+	name := statePath("journal.jsonl")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		log("journal: %s", err)
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log("journal: %s", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log("journal: %s", err)
+	}
+}
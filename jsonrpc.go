@@ -0,0 +1,500 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	serve_jsonrpc = flag.String("serve-jsonrpc", "", "serve JSON-RPC 2.0, framed as in LSP, for editor extensions on stdio, a unix socket (a path) or host:port, on localhost when the host is empty: status, restart, suspend, continue, setFlags and subscribeEvents")
+	jsonrpc_token = flag.String("jsonrpc-token", "", "the token clients of --serve-jsonrpc on host:port authenticate with; a random one, in .rerun/jsonrpc.token, when empty")
+)
+
+// The JSON-RPC API, for an editor extension that embeds rerun as its run
+// with hot reload backend. Messages are framed with a Content-Length header,
+// as in LSP and DAP, so the usual client libraries work. The methods are
+//
+//	authenticate {"token": string} over TCP, before the other methods
+//	status                        the program, the phases and the last cycle
+//	restart {"rebuild": bool}     start the program again, or run a cycle first
+//	suspend, continue             stop the program with SIGSTOP, keeping its state, and let it go on
+//	setFlags {"flags": {...}}     change the phase toggles and a few settings that
+//	                              run no command for the next cycles, as in .rerun.json
+//	subscribeEvents {"output": bool}
+//	                              send the journal events, and the lines the
+//	                              program prints with output, as "event" and
+//	                              "output" notifications
+//
+// On stdio the protocol has stdout to itself: the log and the output of the
+// program go to stderr, and rerun exits when stdin closes. Anyone who can
+// connect to host:port could drive rerun, so a client there authenticates
+// first with the token of --jsonrpc-token or .rerun/jsonrpc.token, which
+// only the user can read.
+
+var (
+	rpcStdout   *os.File
+	rpcListener net.Listener
+	rpcToken    string // required over TCP
+)
+
+// openJSONRPC claims stdout, or listens on the socket, of --serve-jsonrpc
+// before anything is logged.
+func openJSONRPC() error {
+	addr := *serve_jsonrpc
+	switch {
+	case addr == "":
+		return nil
+	case addr == "stdio":
+		rpcStdout, os.Stdout = os.Stdout, os.Stderr
+		return nil
+	case strings.ContainsRune(addr, filepath.Separator) || strings.HasSuffix(addr, ".sock"):
+		if c, err := net.Dial("unix", addr); err == nil {
+			c.Close()
+			return fmt.Errorf("--serve-jsonrpc: another rerun listens on %s", addr)
+		}
+		os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			return fmt.Errorf("--serve-jsonrpc: %s", err)
+		}
+		atExit(func() {
+			l.Close()
+			os.Remove(addr)
+		})
+		rpcListener = l
+	default:
+		if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+			*serve_jsonrpc = net.JoinHostPort("127.0.0.1", port)
+		}
+		if err := setupRPCToken(); err != nil {
+			return fmt.Errorf("--serve-jsonrpc: %s", err)
+		}
+		l, err := listenTCP("serve-jsonrpc", serve_jsonrpc)
+		if err != nil {
+			return fmt.Errorf("--serve-jsonrpc: %s", err)
+		}
+		rpcListener = l
+	}
+	return nil
+}
+
+// setupRPCToken takes --jsonrpc-token or makes up a token.
+func setupRPCToken() error {
+	rpcToken = *jsonrpc_token
+	if rpcToken != "" {
+		return nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	rpcToken = hex.EncodeToString(b)
+	return nil
+}
+
+// writeRPCToken leaves a made up token in the session for the editor.
+func writeRPCToken() {
+	if *jsonrpc_token != "" {
+		return
+	}
+	name := statePath("jsonrpc.token")
+	err := os.MkdirAll(filepath.Dir(name), 0755)
+	if err == nil {
+		os.Remove(name)
+		err = ioutil.WriteFile(name, []byte(rpcToken+"\n"), 0600)
+	}
+	if err != nil {
+		log("JSON-RPC: %s", err)
+		return
+	}
+	atExit(func() {
+		os.Remove(name)
+	})
+}
+
+// serveJSONRPC starts answering the requests of the editor.
+func serveJSONRPC() {
+	switch {
+	case rpcStdout != nil:
+		interactive = true
+		log("JSON-RPC on stdio")
+		go func() {
+			newRPCConn(os.Stdin, rpcStdout).serve()
+			log("JSON-RPC: stdin closed, shutting down")
+			// As Ctrl-C in a terminal would, the editor going away also
+			// interrupts the program.
+			interruptProgram()
+			exit(0)
+		}()
+	case rpcListener != nil:
+		interactive = true
+		log("JSON-RPC on %s", rpcListener.Addr())
+		if rpcToken != "" {
+			writeRPCToken()
+		}
+		go func() {
+			for {
+				c, err := rpcListener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					rc := newRPCConn(c, c)
+					rc.token = rpcToken
+					rc.serve()
+					c.Close()
+				}()
+			}
+		}()
+	}
+}
+
+type rpcMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcMaxMessage is the size of the largest message rerun reads.
+const rpcMaxMessage = 4 << 20
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+	rpcUnauthorized   = -32001
+)
+
+type rpcConn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex // serializes writes
+	w  io.Writer
+
+	output chan string   // the lines of the program, with subscribeEvents
+	done   chan struct{} // closed when the editor goes
+
+	token string // to authenticate with, until it did
+}
+
+var rpcConns struct {
+	sync.Mutex
+	list map[*rpcConn]bool
+	last json.RawMessage // the last cycle event, for status
+}
+
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{r: bufio.NewReader(r), w: w, done: make(chan struct{})}
+}
+
+// serve answers the requests of c until it closes.
+func (c *rpcConn) serve() {
+	defer func() {
+		close(c.done)
+		rpcConns.Lock()
+		delete(rpcConns.list, c)
+		rpcConns.Unlock()
+		if c.output != nil {
+			tail.unfollow(c.output)
+		}
+	}()
+	for {
+		body, err := c.read()
+		if err != nil {
+			if err != io.EOF {
+				log("JSON-RPC: %s", err)
+			}
+			return
+		}
+		var m rpcMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			// The id couldn't be read: the answer has a null one.
+			null := json.RawMessage("null")
+			c.write(rpcMessage{ID: &null, Error: &rpcError{rpcParseError, err.Error()}})
+			continue
+		}
+		result, rerr := c.call(m.Method, m.Params)
+		if m.ID == nil {
+			continue // a notification gets no answer
+		}
+		if rerr == nil {
+			var b []byte
+			if b, err = json.Marshal(result); err != nil {
+				rerr = &rpcError{rpcFailed, err.Error()}
+			} else {
+				c.write(rpcMessage{ID: m.ID, Result: b})
+				continue
+			}
+		}
+		c.write(rpcMessage{ID: m.ID, Error: rerr})
+	}
+}
+
+// read returns the body of the next message.
+func (c *rpcConn) read() ([]byte, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The length comes before authenticate: a bad one drops the
+	// connection rather than make rerun allocate it.
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 || n > rpcMaxMessage {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(c.r, body)
+	return body, err
+}
+
+func (c *rpcConn) write(m rpcMessage) {
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		log("JSON-RPC: %s", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func (c *rpcConn) notify(method string, params interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	c.write(rpcMessage{Method: method, Params: b})
+}
+
+func (c *rpcConn) call(method string, params json.RawMessage) (interface{}, *rpcError) {
+	if method == "authenticate" {
+		var p struct {
+			Token string `json:"token"`
+		}
+		if err := rpcParams(params, &p); err != nil {
+			return nil, err
+		}
+		if c.token != "" && subtle.ConstantTimeCompare([]byte(p.Token), []byte(c.token)) != 1 {
+			return nil, &rpcError{rpcUnauthorized, "wrong token"}
+		}
+		c.token = ""
+		return "authenticated", nil
+	}
+	if c.token != "" {
+		return nil, &rpcError{rpcUnauthorized, "authenticate first, with the token of --jsonrpc-token or " + statePath("jsonrpc.token")}
+	}
+
+	switch method {
+	case "status":
+		return rpcStatus(), nil
+
+	case "restart":
+		var p struct {
+			Rebuild bool `json:"rebuild"`
+		}
+		if err := rpcParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Rebuild {
+			trigger()
+			return "rebuilding", nil
+		}
+		go restart()
+		return "restarting", nil
+
+	case "suspend":
+		if err := suspendProgram(); err != nil {
+			return nil, &rpcError{rpcFailed, err.Error()}
+		}
+		return "suspended", nil
+
+	case "continue":
+		if err := continueProgram(); err != nil {
+			return nil, &rpcError{rpcFailed, err.Error()}
+		}
+		return "continuing", nil
+
+	case "setFlags":
+		var p struct {
+			Flags map[string]json.RawMessage `json:"flags"`
+		}
+		if err := rpcParams(params, &p); err != nil {
+			return nil, err
+		}
+		return rpcSetFlags(p.Flags)
+
+	case "subscribeEvents":
+		var p struct {
+			Output bool `json:"output"`
+		}
+		if err := rpcParams(params, &p); err != nil {
+			return nil, err
+		}
+		rpcConns.Lock()
+		if rpcConns.list == nil {
+			rpcConns.list = map[*rpcConn]bool{}
+		}
+		rpcConns.list[c] = true
+		rpcConns.Unlock()
+		if p.Output && c.output == nil {
+			_, c.output = tail.follow()
+			go func(lines chan string) {
+				for {
+					select {
+					case line := <-lines:
+						c.notify("output", struct {
+							Line string `json:"line"`
+						}{line})
+					case <-c.done:
+						return
+					}
+				}
+			}(c.output)
+		}
+		return "subscribed", nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q, want status, restart, suspend, continue, setFlags or subscribeEvents", method)}
+}
+
+func rpcParams(params json.RawMessage, v interface{}) *rpcError {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{rpcInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func rpcStatus() interface{} {
+	toggled := map[string]bool{}
+	for _, tg := range toggles {
+		toggled[tg.name] = *tg.flag
+	}
+	launchMu.Lock()
+	pid, starts := childPid, launches
+	launchMu.Unlock()
+	rpcConns.Lock()
+	last := rpcConns.last
+	rpcConns.Unlock()
+
+	return struct {
+		Pid         int             `json:"pid"`
+		Launches    int             `json:"launches"`
+		Toggles     map[string]bool `json:"toggles"`
+		Stale       bool            `json:"stale"`
+		Suspended   bool            `json:"suspended"`
+		LastCycle   json.RawMessage `json:"last_cycle,omitempty"`
+		Diagnostics []lspFile       `json:"diagnostics"`
+	}{pid, starts, toggled, isStale(), isSuspended(), last, lspDiagnostics()}
+}
+
+// rpcSettable are the settings setFlags changes besides the phase toggles.
+// Settings that hold commands, such as before or wrap, or flags for them,
+// such as build-flags, are left to the command line and the config file:
+// an editor sets them without running them.
+var rpcSettable = map[string]bool{
+	"no-run":      true,
+	"debounce":    true,
+	"ignore":      true,
+	"ext":         true,
+	"include":     true,
+	"show-diff":   true,
+	"explain":     true,
+	"diff-errors": true,
+}
+
+func rpcSetFlag(name string) bool {
+	for _, tg := range toggles {
+		if tg.name == name {
+			return true
+		}
+	}
+	return rpcSettable[name]
+}
+
+// rpcSetFlags changes settings between cycles. Only the phase toggles and
+// rpcSettable are accepted.
+func rpcSetFlags(flags map[string]json.RawMessage) (interface{}, *rpcError) {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		if flag.Lookup(name) == nil {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown setting %q", name)}
+		}
+		if !rpcSetFlag(name) {
+			return nil, &rpcError{rpcInvalidParams, name + " can't be set over JSON-RPC"}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+	set := map[string]string{}
+	for _, name := range names {
+		if err := setFlag(name, flags[name]); err != nil {
+			return nil, &rpcError{rpcFailed, err.Error()}
+		}
+		set[name] = flag.Lookup(name).Value.String()
+		log("JSON-RPC: %s set to %s", name, set[name])
+	}
+	return set, nil
+}
+
+// notifyRPC hands a journal event to the subscribed editors.
+func notifyRPC(v interface{}) {
+	if *serve_jsonrpc == "" {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	var ev struct {
+		Event string `json:"event"`
+	}
+	json.Unmarshal(b, &ev)
+
+	rpcConns.Lock()
+	if ev.Event == "cycle" {
+		rpcConns.last = b
+	}
+	list := make([]*rpcConn, 0, len(rpcConns.list))
+	for c := range rpcConns.list {
+		list = append(list, c)
+	}
+	rpcConns.Unlock()
+	for _, c := range list {
+		c.write(rpcMessage{Method: "event", Params: b})
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	kube_resource   = flag.String("kube", "", "run in the local Kubernetes cluster: build a linux image of the program, load it into kind or minikube and restart this deployment, e.g. deployment/myapp")
+	kube_image      = flag.String("image", "", "image of --kube, as the deployment names it, NAME:dev by default")
+	kube_dockerfile = flag.String("kube-dockerfile", "", "Dockerfile of the --kube image, built in its directory with the binary in the build arg BIN; an image FROM scratch with the binary by default")
+	kube_load       = flag.String("kube-load", "auto", "how the --kube image gets into the cluster: kind, minikube, none when the cluster shares the images of docker, or auto after the kubectl context")
+)
+
+// kubeLogs follows the logs of the pod of the current rollout.
+var kubeLogs struct {
+	sync.Mutex
+	cmd *exec.Cmd
+}
+
+// setupKube replaces the install phase with a linux build of the program,
+// an image of it, and a rollout of the deployment, whose new pod stands in
+// for the program.
+func setupKube(t *target, args []string) error {
+	if *kube_resource == "" {
+		return nil
+	}
+	if tinygoEnabled() || *bazel_target != "" || *use_gomobile != "" {
+		return fmt.Errorf("--kube doesn't go with --tinygo, --bazel or --gomobile")
+	}
+	for _, tool := range []string{"docker", "kubectl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("--kube: %s", err)
+		}
+	}
+	res := *kube_resource
+	if !strings.Contains(res, "/") {
+		res = "deployment/" + res
+	}
+	name := res[strings.LastIndexByte(res, '/')+1:]
+	image := *kube_image
+	if image == "" {
+		image = name + ":dev"
+	}
+	load, cluster, err := kubeLoader()
+	if err != nil {
+		return err
+	}
+	if *do_build {
+		log("--build doesn't apply with --kube, the install phase builds")
+		*do_build = false
+	}
+
+	// The cross compilation flags pick another target, the node's by default.
+	build := install
+	if !crossCompiling() {
+		arch := runtime.GOARCH
+		if out, err := exec.Command("kubectl", "get", "nodes", "-o", "jsonpath={.items[0].status.nodeInfo.architecture}").Output(); err == nil && len(out) > 0 {
+			arch = strings.TrimSpace(string(out))
+		}
+		crossEnv = append(crossEnv, "GOOS=linux", "GOARCH="+arch, "CGO_ENABLED=0")
+		bin, err := filepath.Abs(statePath("kube/" + strings.TrimSuffix(filepath.Base(t.bin), ".exe")))
+		if err != nil {
+			return err
+		}
+		t.bin = bin
+		build = func(buildpath string) (bool, error) {
+			return gobuildTo(bin, buildpath)
+		}
+	}
+
+	dockerfile, context := *kube_dockerfile, filepath.Dir(*kube_dockerfile)
+	var buildArgs []string
+	if dockerfile == "" {
+		context = filepath.Dir(t.bin)
+		dockerfile = filepath.Join(context, "Dockerfile")
+		cmd, _ := json.Marshal(args)
+		b := fmt.Sprintf("FROM scratch\nCOPY %s /app\nENTRYPOINT [\"/app\"]\nCMD %s\n", filepath.Base(t.bin), cmd)
+		if err := os.MkdirAll(context, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dockerfile, []byte(b), 0644); err != nil {
+			return err
+		}
+	} else {
+		abs, _ := filepath.Abs(context)
+		rel, err := filepath.Rel(abs, t.bin)
+		if err != nil || !below(abs, t.bin) {
+			return fmt.Errorf("--kube-dockerfile: the binary %s isn't below %s, the directory of the build", t.bin, context)
+		}
+		buildArgs = []string{"--build-arg", "BIN=" + filepath.ToSlash(rel)}
+	}
+
+	install = func(buildpath string) (bool, error) {
+		if ok, err := build(buildpath); !ok {
+			return ok, err
+		}
+		steps := [][]string{
+			append(append([]string{"docker", "build", "-q", "-t", image, "-f", dockerfile}, buildArgs...), context),
+		}
+		switch load {
+		case "kind":
+			steps = append(steps, []string{"kind", "load", "docker-image", image, "--name", cluster})
+		case "minikube":
+			steps = append(steps, []string{"minikube", "image", "load", "--overwrite", image, "-p", cluster})
+		}
+		steps = append(steps,
+			[]string{"kubectl", "rollout", "restart", res},
+			[]string{"kubectl", "rollout", "status", res, "--timeout=2m"},
+		)
+		for _, s := range steps {
+			label := strings.Join(s[:2], " ")
+			if s[0] == "kubectl" {
+				label = strings.Join(s[:3], " ")
+			}
+			if ok, err := runStep(label, s[0], s[1:]...); !ok {
+				return ok, err
+			}
+		}
+		reportSuccess("install")
+		followPod(res)
+		return true, nil
+	}
+	log("building %s for %s of the cluster (%s), restarting %s on every change", image, res, strings.TrimSuffix(load+" "+cluster, " "), res)
+	*no_run = true
+	atExit(stopKubeLogs)
+	return nil
+}
+
+// kubeLoader returns how images get into the cluster, and its name, from
+// --kube-load and the kubectl context: kind names its contexts kind-NAME,
+// minikube after the profile.
+func kubeLoader() (load, cluster string, err error) {
+	out, _ := exec.Command("kubectl", "config", "current-context").Output()
+	context := strings.TrimSpace(string(out))
+	load = *kube_load
+	if load == "auto" {
+		switch {
+		case strings.HasPrefix(context, "kind-"):
+			load = "kind"
+		case context == "minikube" || context != "" && strings.Contains(minikubeProfiles(), `"`+context+`"`):
+			load = "minikube"
+		default:
+			load = "none"
+		}
+	}
+	switch load {
+	case "kind":
+		cluster = strings.TrimPrefix(context, "kind-")
+		if cluster == "" || cluster == context {
+			cluster = "kind"
+		}
+	case "minikube":
+		cluster = context
+		if cluster == "" {
+			cluster = "minikube"
+		}
+	case "none":
+		return load, "", nil
+	default:
+		return "", "", fmt.Errorf("--kube-load: %q isn't kind, minikube, none or auto", load)
+	}
+	if _, err := exec.LookPath(load); err != nil {
+		return "", "", fmt.Errorf("--kube-load %s: %s", load, err)
+	}
+	return load, cluster, nil
+}
+
+// minikubeProfiles returns the profiles of minikube, as JSON, if it is
+// installed.
+func minikubeProfiles() string {
+	out, _ := exec.Command("minikube", "profile", "list", "-o", "json").Output()
+	return string(out)
+}
+
+// followPod follows the logs of the newest running pod of res in place of
+// the output of the program.
+func followPod(res string) {
+	stopKubeLogs()
+	pod, err := newestPod(res)
+	if err != nil {
+		log("--kube: %s", err)
+		return
+	}
+	cmd := exec.Command("kubectl", "logs", "-f", "pod/"+pod)
+	if cmd.Stdout, cmd.Stderr, err = childStreams(); err != nil {
+		log("error: %s", err)
+		return
+	}
+	recordCommand("run", cmd)
+	if err := cmd.Start(); err != nil {
+		log("--kube: %s", err)
+		return
+	}
+	log("following pod/%s", pod)
+	kubeLogs.Lock()
+	kubeLogs.cmd = cmd
+	kubeLogs.Unlock()
+	go cmd.Wait()
+}
+
+func stopKubeLogs() {
+	kubeLogs.Lock()
+	defer kubeLogs.Unlock()
+	if kubeLogs.cmd != nil {
+		kubeLogs.cmd.Process.Kill()
+		kubeLogs.cmd = nil
+	}
+}
+
+// newestPod returns the name of the newest running pod of the selector of
+// res that isn't being deleted.
+func newestPod(res string) (string, error) {
+	out, err := exec.Command("kubectl", "get", res, "-o", "jsonpath={.spec.selector.matchLabels}").Output()
+	if err != nil {
+		return "", fmt.Errorf("the selector of %s: %s", res, err)
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(out, &labels); err != nil || len(labels) == 0 {
+		return "", fmt.Errorf("%s has no selector labels", res)
+	}
+	var sel []string
+	for k, v := range labels {
+		sel = append(sel, k+"="+v)
+	}
+	sort.Strings(sel)
+
+	out, err = exec.Command("kubectl", "get", "pods", "-l", strings.Join(sel, ","), "-o", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("the pods of %s: %s", res, err)
+	}
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name              string     `json:"name"`
+				CreationTimestamp time.Time  `json:"creationTimestamp"`
+				DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+			} `json:"metadata"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &pods); err != nil {
+		return "", fmt.Errorf("the pods of %s: %s", res, err)
+	}
+	name, newest := "", time.Time{}
+	for _, p := range pods.Items {
+		if p.Status.Phase == "Running" && p.Metadata.DeletionTimestamp == nil && !p.Metadata.CreationTimestamp.Before(newest) {
+			name, newest = p.Metadata.Name, p.Metadata.CreationTimestamp
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("%s has no running pod", res)
+	}
+	return name, nil
+}
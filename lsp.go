@@ -0,0 +1,149 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+// pending holds the diagnostics of the phases that failed last time they
+// ran, for GET /diagnostics.
+var pending struct {
+	sync.Mutex
+	phases map[string][]diagnostic
+}
+
+// recordDiagnostics keeps the diagnostics of a failed phase, or forgets
+// them when out is empty.
+func recordDiagnostics(phase, out string) {
+	pending.Lock()
+	defer pending.Unlock()
+	if pending.phases == nil {
+		pending.phases = map[string][]diagnostic{}
+	}
+	if out == "" {
+		delete(pending.phases, phase)
+		return
+	}
+	pending.phases[phase] = parseDiagnostics(out)
+}
+
+// LSP types, as in the PublishDiagnostics notification.
+type (
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+	lspFile struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+)
+
+// LSP severities.
+const (
+	lspError   = 1
+	lspWarning = 2
+)
+
+// lspDiagnostics converts the pending diagnostics, grouped by file.
+func lspDiagnostics() []lspFile {
+	pending.Lock()
+	defer pending.Unlock()
+
+	files := map[string]*lspFile{}
+	var uris []string
+	for phase, diags := range pending.phases {
+		severity := lspError
+		if phase == "vet" {
+			severity = lspWarning
+		}
+		for _, d := range diags {
+			p := findSource(d.File)
+			uri := fileURI(p)
+			f := files[uri]
+			if f == nil {
+				f = &lspFile{URI: uri, Diagnostics: []lspDiagnostic{}}
+				files[uri] = f
+				uris = append(uris, uri)
+			}
+			pos := lspPosition{d.Line - 1, lspCharacter(p, d.Line, d.Col)}
+			if pos.Line < 0 {
+				pos.Line = 0
+			}
+			f.Diagnostics = append(f.Diagnostics, lspDiagnostic{lspRange{pos, pos}, severity, "rerun " + phase, d.Msg})
+		}
+	}
+
+	sort.Strings(uris)
+	list := []lspFile{}
+	for _, uri := range uris {
+		f := files[uri]
+		sort.SliceStable(f.Diagnostics, func(i, j int) bool {
+			return f.Diagnostics[i].Range.Start.Line < f.Diagnostics[j].Range.Start.Line
+		})
+		list = append(list, *f)
+	}
+	return list
+}
+
+func fileURI(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // C:/x on Windows
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// lspCharacter converts the 1-based byte column of the go tools to the
+// 0-based UTF-16 offset of LSP, reading the line from the file.
+func lspCharacter(p string, line, col int) int {
+	if col <= 1 {
+		return 0
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return col - 1
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		if n == line {
+			text := s.Text()
+			if col-1 > len(text) {
+				return col - 1
+			}
+			return len(utf16.Encode([]rune(text[:col-1])))
+		}
+	}
+	return col - 1
+}
+
+// serveDiagnostics adds GET /diagnostics to the control API.
+func serveDiagnostics() {
+	http.HandleFunc("/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lspDiagnostics())
+	})
+}
@@ -0,0 +1,108 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var matrix_tags = flag.String("matrix", "", "build tag sets separated by semicolons, e.g. \"dev;prod,netgo;integration\": the program is built and run with the first, and every change is compiled with the others too")
+
+// matrix holds the tag sets of --matrix, the first one that of the
+// program.
+var matrix []string
+
+func checkMatrix() error {
+	if *matrix_tags == "" {
+		return nil
+	}
+	if flags, _ := splitWords(*build_flags); hasTags(flags) {
+		return errors.New("--matrix sets the build tags, leave -tags out of --build-flags")
+	}
+	if tinygoEnabled() || *bazel_target != "" || *cmd_template != "" {
+		return errors.New("--matrix doesn't go with --tinygo, --bazel or --cmd")
+	}
+	return nil
+}
+
+// setMatrix splits --matrix into its tag sets, once the startup checks
+// passed.
+func setMatrix() {
+	matrix = nil
+	if *matrix_tags == "" {
+		return
+	}
+	for _, set := range strings.Split(*matrix_tags, ";") {
+		matrix = append(matrix, strings.Join(strings.Fields(strings.Replace(set, ",", " ", -1)), ","))
+	}
+}
+
+func hasTags(args []string) bool {
+	for _, a := range args {
+		if a == "-tags" || a == "--tags" || strings.HasPrefix(a, "-tags=") || strings.HasPrefix(a, "--tags=") {
+			return true
+		}
+	}
+	return false
+}
+
+// programTags returns the -tags flag of the first tag set of --matrix.
+func programTags() []string {
+	if len(matrix) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + matrix[0]}
+}
+
+// buildMatrix compiles buildpath with the other tag sets of --matrix, all
+// at once, and reports those that fail. The program is already started.
+func buildMatrix(buildpath string) (bool, error) {
+	variants := matrix[1:]
+	outs := make([]bytes.Buffer, len(variants))
+	errs := make([]error, len(variants))
+	var wg sync.WaitGroup
+	for i, tags := range variants {
+		cmd := gocmd(goTagArgs([]string{"-tags=" + tags}, "build", "-o", os.DevNull, buildpath)...)
+		recordCommand("matrix "+tagLabel(tags), cmd)
+		cmd.Stdout = &outs[i]
+		cmd.Stderr = &outs[i]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cmd.Run()
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []string
+	var out bytes.Buffer
+	for i, tags := range variants {
+		if errs[i] != nil {
+			failed = append(failed, tagLabel(tags))
+			fmt.Fprintf(&out, "# -tags %s\n%s", tagLabel(tags), outs[i].String())
+		}
+	}
+	if len(failed) > 0 {
+		log("matrix: the build fails with %s", strings.Join(failed, "; "))
+		reportFailure("matrix", out.String())
+		return false, fmt.Errorf("%d of %d tag sets fail", len(failed), len(variants))
+	}
+	reportSuccess("matrix")
+	log("matrix: builds with all %d tag sets", len(matrix))
+	return true, nil
+}
+
+func tagLabel(tags string) string {
+	if tags == "" {
+		return "no tags"
+	}
+	return tags
+}
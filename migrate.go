@@ -0,0 +1,316 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// initConfig implements "rerun init": it writes the configuration file,
+// converted from the configuration of another tool with -from.
+func initConfig(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	from := fs.String("from", "", "configuration to convert: .air.toml or fresh's runner.conf")
+	force := fs.Bool("force", false, "overwrite an existing configuration file")
+	fs.Parse(args)
+
+	if *from == "" {
+		return errors.New("init: -from is required")
+	}
+	if exists(*config_file) && !*force {
+		return fmt.Errorf("init: %s exists, use -force to overwrite it", *config_file)
+	}
+
+	b, err := ioutil.ReadFile(*from)
+	if err != nil {
+		return err
+	}
+
+	var c map[string]interface{}
+	var skipped []string
+	switch base := filepath.Base(*from); {
+	case strings.HasSuffix(base, ".toml"):
+		c, skipped, err = fromAir(b)
+	case strings.HasSuffix(base, ".conf"):
+		c, skipped, err = fromFresh(b)
+	case strings.HasPrefix(base, ".realize"):
+		err = errors.New("realize configurations can't be converted, set the flags by hand")
+	default:
+		err = errors.New("unknown configuration format")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", *from, err)
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*config_file, out.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	log("wrote %s from %s", *config_file, *from)
+	for _, s := range skipped {
+		log("not converted: %s", s)
+	}
+	return nil
+}
+
+// fromAir converts an air configuration. It returns the rerun settings and
+// the air settings that have no counterpart.
+func fromAir(b []byte) (map[string]interface{}, []string, error) {
+	t, err := parseTOML(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c := map[string]interface{}{}
+	var skipped []string
+	for _, key := range keys {
+		v := t[key]
+		switch key {
+		case "root":
+			if v != "." {
+				c["watch"] = v
+			}
+		case "build.delay":
+			if n, ok := v.(int64); ok && n > 0 {
+				c["debounce"] = fmt.Sprintf("%dms", n)
+			}
+		case "build.pre_cmd":
+			if cmds := strs(v); len(cmds) > 0 {
+				c["before"] = strings.Join(cmds, " && ")
+			}
+		case "build.exclude_dir":
+			if dirs := strs(v); len(dirs) > 0 {
+				c["ignore"] = strings.Join(dirs, ",")
+			}
+		case "build.include_ext":
+			if exts := strs(v); len(exts) > 0 {
+				c["ext"] = strings.Join(exts, ",")
+			}
+		case "build.exclude_file":
+			if files := strs(v); len(files) > 0 {
+				c["generated"] = strings.Join(files, ",")
+			}
+		case "build.args_bin":
+			if len(strs(v)) > 0 {
+				skipped = append(skipped, key+" (pass the arguments after the package)")
+			}
+		case "tmp_dir", "build.cmd", "build.bin", "build.full_bin":
+			// rerun builds and installs the package itself.
+		default:
+			skipped = append(skipped, key)
+		}
+	}
+	return c, skipped, nil
+}
+
+// fromFresh converts a fresh runner.conf.
+func fromFresh(b []byte) (map[string]interface{}, []string, error) {
+	c := map[string]interface{}{}
+	var skipped []string
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, nil, fmt.Errorf("line %d: want key: value", n)
+		}
+		key, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		switch {
+		case key == "root":
+			if v != "." {
+				c["watch"] = v
+			}
+		case key == "build_delay":
+			if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+				c["debounce"] = fmt.Sprintf("%dms", ms)
+			}
+		case key == "ignored":
+			if dirs := splitList(v); len(dirs) > 0 {
+				c["ignore"] = strings.Join(dirs, ",")
+			}
+		case key == "valid_ext":
+			var exts []string
+			for _, e := range splitList(v) {
+				exts = append(exts, strings.TrimPrefix(e, "."))
+			}
+			if len(exts) > 0 {
+				c["ext"] = strings.Join(exts, ",")
+			}
+		case key == "tmp_path", key == "build_name", key == "build_log",
+			key == "colors", strings.HasPrefix(key, "log_color"):
+			// rerun builds the package and colors nothing.
+		default:
+			skipped = append(skipped, key)
+		}
+	}
+	return c, skipped, s.Err()
+}
+
+// strs returns the strings of a TOML array.
+func strs(v interface{}) []string {
+	var list []string
+	a, _ := v.([]interface{})
+	for _, e := range a {
+		if s, ok := e.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// parseTOML reads the subset of TOML that tool configurations use: tables,
+// and keys with strings, integers, booleans and arrays of those. The keys
+// of the result are prefixed with their table, e.g. "build.delay".
+func parseTOML(b []byte) (map[string]interface{}, error) {
+	t := map[string]interface{}{}
+	table := ""
+
+	lines := strings.Split(string(b), "\n")
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(stripComment(lines[n]))
+		if line == "" {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: bad table", n+1)
+			}
+			table = strings.Trim(line, "[] ") + "."
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: want key = value", n+1)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:i]), `"`)
+		value := strings.TrimSpace(line[i+1:])
+		start := n
+		for strings.HasPrefix(value, "[") && strings.Count(value, "[") > strings.Count(value, "]") {
+			if n++; n == len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated array", start+1)
+			}
+			value += " " + strings.TrimSpace(stripComment(lines[n]))
+		}
+
+		v, rest, err := tomlValue(value)
+		if err == nil && strings.TrimSpace(rest) != "" {
+			err = fmt.Errorf("unexpected %q", rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", start+1, err)
+		}
+		t[table+key] = v
+	}
+	return t, nil
+}
+
+// tomlValue parses the value at the start of s and returns the rest.
+func tomlValue(s string) (interface{}, string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return nil, "", errors.New("missing value")
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				v, err := strconv.Unquote(s[:i+1])
+				return v, s[i+1:], err
+			}
+		}
+		return nil, "", errors.New("unterminated string")
+	case s[0] == '\'':
+		i := strings.IndexByte(s[1:], '\'')
+		if i < 0 {
+			return nil, "", errors.New("unterminated string")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	case s[0] == '[':
+		a := []interface{}{}
+		s = strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			v, rest, err := tomlValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			a = append(a, v)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", errors.New("unterminated array")
+			}
+		}
+		return a, s[1:], nil
+	}
+
+	i := strings.IndexAny(s, ",]")
+	if i < 0 {
+		i = len(s)
+	}
+	word, rest := strings.TrimSpace(s[:i]), s[i:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	n, err := strconv.ParseInt(strings.Replace(word, "_", "", -1), 0, 64)
+	if err != nil {
+		if f, ferr := strconv.ParseFloat(word, 64); ferr == nil {
+			return f, rest, nil
+		}
+		return nil, "", fmt.Errorf("bad value %q", word)
+	}
+	return n, rest, nil
+}
+
+// stripComment removes a # comment that isn't inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == 0 && c == '#':
+			return line[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case c == quote:
+			quote = 0
+		}
+	}
+	return line
+}
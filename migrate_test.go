@@ -0,0 +1,123 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]interface{}
+		err  bool
+	}{
+		{in: "", want: map[string]interface{}{}},
+		{
+			in:   `root = "."` + "\n" + `tmp_dir = 'tmp'`,
+			want: map[string]interface{}{"root": ".", "tmp_dir": "tmp"},
+		},
+		{
+			in:   "[build]\ndelay = 1_000\nstop_on_error = true\nrerun_delay = 0.5\n",
+			want: map[string]interface{}{"build.delay": int64(1000), "build.stop_on_error": true, "build.rerun_delay": 0.5},
+		},
+		{
+			in:   `[build]` + "\n" + `exclude_dir = ["assets", "tmp", "vendor"]`,
+			want: map[string]interface{}{"build.exclude_dir": []interface{}{"assets", "tmp", "vendor"}},
+		},
+		{
+			in:   "[build]\ninclude_ext = [\n  \"go\", # sources\n  \"tpl\",\n]\n",
+			want: map[string]interface{}{"build.include_ext": []interface{}{"go", "tpl"}},
+		},
+		{
+			in:   `cmd = "go build -o ./tmp/main # not a comment" # a comment`,
+			want: map[string]interface{}{"cmd": "go build -o ./tmp/main # not a comment"},
+		},
+		{
+			in:   `"quoted key" = "a \"b\""`,
+			want: map[string]interface{}{"quoted key": `a "b"`},
+		},
+		{
+			in:   "[ log ]\ntime = false\n[misc]\nclean_on_exit = true",
+			want: map[string]interface{}{"log.time": false, "misc.clean_on_exit": true},
+		},
+		{in: "[build", err: true},
+		{in: "delay", err: true},
+		{in: "delay =", err: true},
+		{in: `cmd = "go build`, err: true},
+		{in: "dirs = [\"a\",\n\"b\"", err: true},
+		{in: "delay = soon", err: true},
+		{in: `cmd = "a" "b"`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTOML([]byte(tt.in))
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTOML(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTOML(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTOML(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFromAir(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]interface{}
+		skipped []string
+	}{
+		{in: `root = "."`, want: map[string]interface{}{}},
+		{
+			in: "root = \"app\"\ntmp_dir = \"tmp\"\n[build]\ndelay = 500\ncmd = \"go build\"\n" +
+				"exclude_dir = [\"assets\", \"tmp\"]\ninclude_ext = [\"go\", \"tpl\"]\npre_cmd = [\"go generate\", \"make\"]\n",
+			want: map[string]interface{}{
+				"watch":    "app",
+				"debounce": "500ms",
+				"ignore":   "assets,tmp",
+				"ext":      "go,tpl",
+				"before":   "go generate && make",
+			},
+		},
+		{
+			in:      "[build]\nargs_bin = [\"-v\"]\nsend_interrupt = true\n",
+			want:    map[string]interface{}{},
+			skipped: []string{"build.args_bin (pass the arguments after the package)", "build.send_interrupt"},
+		},
+	}
+	for _, tt := range tests {
+		got, skipped, err := fromAir([]byte(tt.in))
+		if err != nil {
+			t.Errorf("fromAir(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(skipped, tt.skipped) {
+			t.Errorf("fromAir(%q) = %v, %q, want %v, %q", tt.in, got, skipped, tt.want, tt.skipped)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"a = 1", "a = 1"},
+		{"a = 1 # one", "a = 1 "},
+		{"# all of it", ""},
+		{`a = "#1"`, `a = "#1"`},
+		{`a = '#1' # x`, `a = '#1' `},
+		{`a = "\"#" # x`, `a = "\"#" `},
+	}
+	for _, tt := range tests {
+		if got := stripComment(tt.in); got != tt.want {
+			t.Errorf("stripComment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
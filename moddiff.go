@@ -0,0 +1,163 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The requirements of go.mod as of the previous cycle, to tell which
+// dependencies moved when it changes.
+var (
+	modFile     string
+	modResolved bool
+	modRequires map[string]string
+)
+
+// reportModChanges prints the modules go.mod added, removed or moved to
+// another version since the previous cycle, and reports whether there were
+// any.
+func reportModChanges() bool {
+	if !modResolved {
+		modResolved = true
+		if inModule() {
+			modFile = goEnv("GOMOD")
+		}
+	}
+	if modFile == "" {
+		return false
+	}
+	b, err := ioutil.ReadFile(modFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log("go.mod: %s", err)
+		}
+		return false
+	}
+	requires := parseRequires(string(b))
+	prev := modRequires
+	modRequires = requires
+	if prev == nil {
+		return false
+	}
+
+	var lines []string
+	var added, removed, upgraded, downgraded int
+	for _, m := range sortedKeys(requires) {
+		old, ok := prev[m]
+		switch v := requires[m]; {
+		case !ok:
+			added++
+			lines = append(lines, fmt.Sprintf("+ %s %s", m, v))
+		case old != v && compareVersions(old, v) > 0:
+			downgraded++
+			lines = append(lines, fmt.Sprintf("  %s %s → %s (downgrade)", m, old, v))
+		case old != v:
+			upgraded++
+			lines = append(lines, fmt.Sprintf("  %s %s → %s", m, old, v))
+		}
+	}
+	for _, m := range sortedKeys(prev) {
+		if _, ok := requires[m]; !ok {
+			removed++
+			lines = append(lines, fmt.Sprintf("- %s %s", m, prev[m]))
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i][2:] < lines[j][2:]
+	})
+	var counts []string
+	for _, c := range []struct {
+		n    int
+		what string
+	}{{added, "added"}, {removed, "removed"}, {upgraded, "upgraded"}, {downgraded, "downgraded"}} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	log("go.mod: %s", strings.Join(counts, ", "))
+	for _, line := range lines {
+		fmt.Fprintln(console, "    "+line)
+	}
+	return true
+}
+
+// parseRequires returns the required modules of a go.mod file and their
+// versions.
+func parseRequires(s string) map[string]string {
+	requires := map[string]string{}
+	block := false
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 0:
+			continue
+		case block && f[0] == ")":
+			block = false
+			continue
+		case block:
+		case f[0] == "require" && len(f) == 2 && f[1] == "(":
+			block = true
+			continue
+		case f[0] == "require":
+			f = f[1:]
+		default:
+			continue
+		}
+		if len(f) >= 2 {
+			requires[strings.Trim(f[0], `"`)] = f[1]
+		}
+	}
+	return requires
+}
+
+// compareVersions compares the release parts of two module versions,
+// such as v1.2.3, and falls back to the strings for the rest, which orders
+// pseudo-versions by their timestamps.
+func compareVersions(a, b string) int {
+	split := func(v string) ([]int, string) {
+		v = strings.TrimPrefix(v, "v")
+		rest := ""
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v, rest = v[:i], v[i:]
+		}
+		var nums []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			nums = append(nums, n)
+		}
+		return nums, rest
+	}
+	an, ar := split(a)
+	bn, br := split(b)
+	for i := 0; i < len(an) && i < len(bn); i++ {
+		if an[i] != bn[i] {
+			if an[i] < bn[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case ar == br:
+		return 0
+	case ar == "":
+		return 1 // a release is above its pre-releases
+	case br == "":
+		return -1
+	}
+	return strings.Compare(ar, br)
+}
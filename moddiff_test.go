@@ -0,0 +1,62 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRequires(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"module example.com/app\n\ngo 1.20\n", map[string]string{}},
+		{
+			"module example.com/app\n\nrequire example.com/lib v1.2.3\nrequire \"example.com/quoted\" v0.1.0 // indirect\n",
+			map[string]string{"example.com/lib": "v1.2.3", "example.com/quoted": "v0.1.0"},
+		},
+		{
+			"require (\n\texample.com/a v1.0.0\n\texample.com/b v0.0.0-20200101000000-abcdef012345 // indirect\n\n\t// a comment\n)\n" +
+				"require example.com/c v2.0.0+incompatible\n",
+			map[string]string{"example.com/a": "v1.0.0", "example.com/b": "v0.0.0-20200101000000-abcdef012345", "example.com/c": "v2.0.0+incompatible"},
+		},
+		{
+			"require (\n\texample.com/a v1.0.0\n)\nreplace example.com/a => ../a\nexclude example.com/x v1.0.0\n",
+			map[string]string{"example.com/a": "v1.0.0"},
+		},
+		{"// require example.com/a v1.0.0\n", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseRequires(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRequires(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.2.4", -1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.2", "v1.2.0", 0},
+		{"v1.2.3", "v1.2.3-rc.1", 1},
+		{"v1.2.3-rc.1", "v1.2.3", -1},
+		{"v1.2.3-rc.1", "v1.2.3-rc.2", -1},
+		{"v0.0.0-20200101000000-abcdef012345", "v0.0.0-20210101000000-012345abcdef", -1},
+		{"v0.1.0", "v0.0.0-20210101000000-012345abcdef", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	monitor    = flag.Duration("monitor", 0, "report memory and CPU usage of the program at this interval")
+	max_rss    = flag.String("max-rss", "", "act when the resident memory of the program and its child processes exceeds this size, e.g. 500MB; sampled every --monitor, or 5s")
+	rss_action = flag.String("rss-action", "warn", "what to do when --max-rss is exceeded: warn or restart")
+)
+
+// usage is a sample of the resources used by a process.
+type usage struct {
+	rss int64         // resident memory in bytes
+	cpu time.Duration // user and system time
+}
+
+// childPid is the pid of the running program, or 0.
+var childPid int
+
+// rssInterval is how often --max-rss samples the program without --monitor.
+const rssInterval = 5 * time.Second
+
+// watchUsage samples the program every --monitor, and reports its usage.
+// --max-rss alone samples it every rssInterval.
+func watchUsage() {
+	if *monitor <= 0 && *max_rss == "" {
+		return
+	}
+	interval := *monitor
+	if interval <= 0 {
+		interval = rssInterval
+	}
+
+	var limit int64
+	if *max_rss != "" {
+		n, err := parseSize(*max_rss)
+		if err != nil {
+			log("--max-rss: %s", err)
+			return
+		}
+		limit = n
+	}
+
+	go func() {
+		var pid int
+		var prev usage
+		var prevTime time.Time
+		warned := false
+
+		for range time.Tick(interval) {
+			launchMu.Lock()
+			p := childPid
+			launchMu.Unlock()
+			if p == 0 {
+				continue
+			}
+
+			u, err := treeUsage(p)
+			if err != nil {
+				log("monitor: %s", err)
+				continue
+			}
+			now := time.Now()
+
+			if p != pid {
+				pid, prev, prevTime, warned = p, usage{}, now, false
+			}
+			// The time of the children that exited is gone from the sum.
+			cpu := 0.0
+			if elapsed := now.Sub(prevTime); elapsed > 0 && prev.cpu > 0 && u.cpu > prev.cpu {
+				cpu = 100 * float64(u.cpu-prev.cpu) / float64(elapsed)
+			}
+			prev, prevTime = u, now
+
+			if *monitor > 0 {
+				log("pid %d: rss %s, cpu %.1f%%", pid, formatSize(u.rss), cpu)
+			}
+
+			if limit > 0 && u.rss > limit {
+				switch *rss_action {
+				case "restart":
+					log("pid %d exceeds --max-rss %s, restarting", pid, *max_rss)
+					restart()
+				default:
+					if !warned {
+						log("warning: pid %d exceeds --max-rss %s", pid, *max_rss)
+						warned = true
+					}
+				}
+			}
+		}
+	}()
+}
+
+// treeUsage sums the usage of pid and its descendants, so a program behind
+// --run-cmd or --wrap, or one that starts others, is measured as a whole.
+func treeUsage(pid int) (usage, error) {
+	u, err := sampleUsage(pid)
+	if err != nil {
+		return u, err
+	}
+	for _, p := range processTree(pid)[1:] {
+		if c, err := sampleUsage(p); err == nil {
+			u.rss += c.rss
+			u.cpu += c.cpu
+		}
+	}
+	return u, nil
+}
+
+// restart starts the program again without rebuilding it.
+func restart() {
+	if forceRestart() {
+		return
+	}
+	if current.ch != nil {
+		current.ch <- true
+	}
+}
+
+// parseSize parses sizes such as "5MB", "1.5GiB" or "1024".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	units := []struct {
+		suffix string
+		n      float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.n
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * mult), nil
+}
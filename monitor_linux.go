@@ -0,0 +1,45 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc, which is 100 on
+// all common architectures.
+const clockTicks = 100
+
+// processTree returns pid and its descendants.
+func processTree(pid int) []int {
+	return descendants(pid)
+}
+
+func sampleUsage(pid int) (u usage, err error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return
+	}
+
+	// The command name may contain spaces, the fields start after it.
+	s := string(b)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 22 {
+		return u, fmt.Errorf("unexpected /proc/%d/stat", pid)
+	}
+
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+
+	u.cpu = time.Duration(utime+stime) * time.Second / clockTicks
+	u.rss = rss * int64(os.Getpagesize())
+	return
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !plan9
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sampleUsage asks ps, which reports the resident size in KiB and the CPU
+// time as [dd-]hh:mm:ss or mm:ss.ss.
+func sampleUsage(pid int) (u usage, err error) {
+	out, err := exec.Command("ps", "-o", "rss=", "-o", "time=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return u, fmt.Errorf("ps: %s", err)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return u, fmt.Errorf("unexpected ps output %q", out)
+	}
+
+	kb, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	u.rss = kb * 1024
+	u.cpu = parseCPUTime(fields[1])
+	return
+}
+
+// processTree returns pid and its descendants, from the parents ps
+// reports, or only pid without ps.
+func processTree(pid int) []int {
+	out, err := exec.Command("ps", "-A", "-o", "pid=", "-o", "ppid=").Output()
+	if err != nil {
+		return []int{pid}
+	}
+	children := map[int][]int{}
+	f := strings.Fields(string(out))
+	for i := 0; i+1 < len(f); i += 2 {
+		p, _ := strconv.Atoi(f[i])
+		ppid, _ := strconv.Atoi(f[i+1])
+		children[ppid] = append(children[ppid], p)
+	}
+	all := []int{pid}
+	for i := 0; i < len(all); i++ {
+		all = append(all, children[all[i]]...)
+	}
+	return all
+}
+
+func parseCPUTime(s string) (d time.Duration) {
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		days, _ := strconv.Atoi(s[:i])
+		d += time.Duration(days) * 24 * time.Hour
+		s = s[i+1:]
+	}
+	for _, part := range strings.Split(s, ":") {
+		f, _ := strconv.ParseFloat(part, 64)
+		d = d*60 + time.Duration(f*float64(time.Second))
+	}
+	return
+}
@@ -0,0 +1,42 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processTree returns pid; the processes it started aren't looked up.
+func processTree(pid int) []int {
+	return []int{pid}
+}
+
+// sampleUsage reads /proc/PID/status: the name, user and state, six times
+// in milliseconds, of which the first two are the user and system time,
+// the memory in KiB and two priorities.
+func sampleUsage(pid int) (u usage, err error) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return u, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 12 {
+		return u, fmt.Errorf("unexpected status %q", b)
+	}
+	f = f[len(f)-9:]
+	var n [7]int64
+	for i := range n {
+		if n[i], err = strconv.ParseInt(f[i], 10, 64); err != nil {
+			return u, fmt.Errorf("unexpected status %q", b)
+		}
+	}
+	u.cpu = time.Duration(n[0]+n[1]) * time.Millisecond
+	u.rss = n[6] * 1024
+	return u, nil
+}
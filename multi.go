@@ -0,0 +1,120 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	run_all  = flag.Bool("run-all", false, "with a pattern such as ./cmd/..., run every main package it matches")
+	run_main = flag.String("run", "", "with a pattern such as ./cmd/..., the main package to run, by name or import path")
+)
+
+// isPattern reports whether buildpath names several packages.
+func isPattern(buildpath string) bool {
+	return buildpath == "..." || strings.HasSuffix(buildpath, "/...")
+}
+
+// rerunAll builds all main packages matching pattern in every cycle, so
+// they stay compile-clean together, and runs one of them or, with
+// --run-all, each of them.
+func rerunAll(pattern string, args []string) error {
+	if tinygoEnabled() {
+		return fmt.Errorf("--tinygo builds a single package, not %s", pattern)
+	}
+	if *use_gomobile != "" {
+		return fmt.Errorf("--gomobile builds a single package, not %s", pattern)
+	}
+	if *kube_resource != "" || *remote_build != "" {
+		return fmt.Errorf("--kube and --remote-build build a single package, not %s", pattern)
+	}
+	mains, err := mainPackages(pattern)
+	if err != nil {
+		return fmt.Errorf("go list %s: %s", pattern, err)
+	}
+	if len(mains) == 0 {
+		return fmt.Errorf("no main package matches %s", pattern)
+	}
+	log("%d main packages match %s", len(mains), pattern)
+
+	selected := mains
+	if !*run_all {
+		m, err := selectMain(mains)
+		if err != nil {
+			return err
+		}
+		selected = []string{m}
+	}
+
+	srcDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, err := build.Import(strings.TrimSuffix(pattern, "..."), srcDir, build.FindOnly)
+	if err != nil {
+		return err
+	}
+	t := &target{buildpath: pattern, dir: root.Dir}
+	if err = setupChain(watchRoot(t)); err != nil {
+		return err
+	}
+
+	if err = startFixtures(); err != nil {
+		return err
+	}
+
+	var chans []chan bool
+	for _, m := range selected {
+		p, err := resolveMain(m)
+		if err != nil {
+			return err
+		}
+		if t.bin == "" {
+			t.bin = p.bin
+		}
+
+		c := make(chan bool)
+		go run(c, p.bin, p.dir, args)
+		chans = append(chans, c)
+		log("running %s", m)
+	}
+
+	// Every cycle restarts or stops all the programs.
+	ch := make(chan bool)
+	go func() {
+		for relaunch := range ch {
+			for _, c := range chans {
+				c <- relaunch
+			}
+		}
+	}()
+
+	initialCycle(t, ch)
+	watchTree(t.dir, t, ch)
+	return nil
+}
+
+// selectMain returns the main package named by --run, the only one, or the
+// one the user chooses.
+func selectMain(mains []string) (string, error) {
+	if *run_main != "" {
+		for _, m := range mains {
+			if m == *run_main || path.Base(m) == *run_main {
+				return m, nil
+			}
+		}
+		return "", fmt.Errorf("--run %s: no such main package", *run_main)
+	}
+	if len(mains) == 1 {
+		return mains[0], nil
+	}
+	return choose(mains)
+}
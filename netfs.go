@@ -0,0 +1,180 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var netfs = flag.String("netfs", "auto", "trees on network or virtualized filesystems, such as NFS, SMB, 9p or virtiofs: auto polls their content hashes, on treats every tree so, off watches them like local ones")
+
+// networkTypes are the filesystems where events from the other side don't
+// arrive and modification times are coarse or skewed. Docker Desktop and
+// WSL2 share the host's files with 9p, virtiofs, grpcfuse or fakeowner.
+var networkTypes = map[string]bool{
+	"nfs": true, "nfs4": true, "cifs": true, "smb3": true, "smbfs": true, "9p": true, "virtiofs": true,
+	"fuse.grpcfuse": true, "fuse.osxfs": true, "fakeowner": true, "vboxsf": true, "fuse.sshfs": true,
+	"drvfs": true, "afpfs": true, "webdav": true,
+}
+
+// largeHash is the size above which files are compared by size and
+// modification time only.
+const largeHash = 8 << 20
+
+func checkNetfs() error {
+	switch *netfs {
+	case "auto", "on", "off":
+		return nil
+	}
+	return fmt.Errorf("--netfs %s: want auto, on or off", *netfs)
+}
+
+// hashedTree reports whether root is watched by polling content hashes,
+// and says why.
+func hashedTree(root string) bool {
+	switch *netfs {
+	case "off":
+		return false
+	case "on":
+		return true
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	fs := mountType(abs)
+	if !networkTypes[fs] {
+		return false
+	}
+	log("%s is on %s, a network or virtualized filesystem where events and modification times are unreliable; polling content hashes instead, --netfs off to watch it like a local tree", root, fs)
+	return true
+}
+
+// A fileState is what a hashPoller knows of a file.
+type fileState struct {
+	mtime time.Time
+	size  int64
+	sum   [sha256.Size]byte
+}
+
+// pollHashes walks root every hashInterval and calls cb with the files
+// whose contents changed.
+func pollHashes(root string, cb scanCallback) error {
+	log("watching: %s (content hashes every %s)", root, hashInterval())
+	h := newHashPoller(root, []string{root})
+	b := newBatch(root)
+	for {
+		time.Sleep(hashInterval())
+		if !exists(root) {
+			return errGone
+		}
+		now := time.Now()
+		for _, p := range h.next() {
+			b.add(p, now)
+		}
+		if b.ready() {
+			b.flush(cb)
+		}
+	}
+}
+
+// hashInterval is --poll-interval, 2s at least unless it was given.
+func hashInterval() time.Duration {
+	if !flagSet("poll-interval") && *poll_interval < 2*time.Second {
+		return 2 * time.Second
+	}
+	return *poll_interval
+}
+
+// A hashPoller finds the changed files of trees below root by their
+// contents. Modification times are only compared with their last value,
+// never with the clock, and every tenth walk hashes all files, for edits
+// within the granularity of the filesystem's times.
+type hashPoller struct {
+	root   string
+	trees  []string
+	states map[string]fileState
+	walks  int
+}
+
+func newHashPoller(root string, trees []string) *hashPoller {
+	h := &hashPoller{root: root, trees: trees, states: map[string]fileState{}}
+	h.scan(true, true)
+	return h
+}
+
+// next walks the trees again and returns the changed, added and removed
+// files.
+func (h *hashPoller) next() []string {
+	h.walks++
+	return h.scan(false, h.walks%10 == 0)
+}
+
+// scan updates the states from the files of the trees and returns the
+// changed ones, none for the baseline. Files whose size and modification
+// time didn't change are hashed only with full.
+func (h *hashPoller) scan(baseline, full bool) (changed []string) {
+	seen := map[string]bool{}
+	for _, tree := range h.trees {
+		filepath.Walk(tree, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if reason := skipReason(h.root, p, info.IsDir()); reason != "" {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || constraintReason(p) != "" {
+				return nil
+			}
+			seen[p] = true
+			old, ok := h.states[p]
+			s := fileState{mtime: info.ModTime(), size: info.Size(), sum: old.sum}
+			if ok && s.mtime.Equal(old.mtime) && s.size == old.size && (!full || s.size > largeHash) {
+				return nil
+			}
+			if s.size <= largeHash {
+				s.sum = hashFile(p)
+			}
+			h.states[p] = s
+			if baseline || (ok && s.sum == old.sum && s.size <= largeHash) {
+				return nil
+			}
+			if reason := cycleWrite(p, nil); reason != "" {
+				explainf(p, info.ModTime(), "%s", reason)
+				return nil
+			}
+			changed = append(changed, p)
+			return nil
+		})
+	}
+	for p := range h.states {
+		if !seen[p] {
+			delete(h.states, p)
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+func hashFile(p string) (sum [sha256.Size]byte) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	io.Copy(h, f)
+	copy(sum[:], h.Sum(nil))
+	return
+}
@@ -0,0 +1,82 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// mountType returns the filesystem type of the deepest mount above p.
+func mountType(p string) string {
+	best, fs := "", ""
+	for mnt, typ := range mounts() {
+		if below(mnt, p) && len(mnt) >= len(best) {
+			best, fs = mnt, typ
+		}
+	}
+	return fs
+}
+
+// crossedMounts returns the mount points below root, but root, of network
+// or virtualized filesystems, with their types. In a container or WSL2 the
+// tree can be local while a part of it, such as a bind mount of the
+// host's sources or /mnt/c, is shared across the boundary.
+func crossedMounts(root string) map[string]string {
+	if *netfs != "auto" {
+		return nil
+	}
+	crossed := map[string]string{}
+	for mnt, typ := range mounts() {
+		if mnt != root && below(root, mnt) && networkTypes[typ] {
+			crossed[mnt] = typ
+		}
+	}
+	return crossed
+}
+
+// mounts maps the mount points of /proc/self/mountinfo to their types. A
+// point mounted over keeps the last type.
+func mounts() map[string]string {
+	b, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	m := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		// ID PARENT MAJOR:MINOR ROOT MOUNTPOINT OPTIONS [TAGS...] - TYPE SOURCE SUPEROPTIONS
+		f := strings.Fields(line)
+		sep := -1
+		for i, v := range f {
+			if v == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+1 >= len(f) {
+			continue
+		}
+		m[unescapeMount(f[4])] = f[sep+1]
+	}
+	return m
+}
+
+// unescapeMount undoes the octal escapes of spaces and the like in
+// mountinfo.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,12 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+// mountType isn't known here; --netfs on polls content hashes anyway.
+func mountType(p string) string { return "" }
+
+func crossedMounts(root string) map[string]string { return nil }
@@ -0,0 +1,81 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var retries = flag.Int("retries", 3, "retry the install this many times after network errors, with backoff, before building offline")
+
+// networkRe matches the errors of the go command that are caused by the
+// network rather than the code.
+var networkRe = regexp.MustCompile(`dial tcp|no such host|i/o timeout|connection refused|connection reset|network is unreachable|TLS handshake timeout|server misbehaving|temporary failure in name resolution`)
+
+// installPackage runs go get, or go install in module mode, and retries
+// after network errors with growing pauses. When the network stays away,
+// the package is installed from the module cache, or the vendor directory,
+// instead.
+func installPackage(buildpath string) (string, error) {
+	verb := "get"
+	if moduleMode {
+		verb = "install"
+	}
+	out, err := runGo(nil, goArgs(verb, buildpath)...)
+	for i := 0; err != nil && networkRe.MatchString(out) && i < *retries; i++ {
+		pause := time.Second << uint(i)
+		log("install hit a network error, retrying in %s", pause)
+		time.Sleep(pause)
+		out, err = runGo(nil, goArgs(verb, buildpath)...)
+	}
+	if err == nil || !networkRe.MatchString(out) {
+		return out, err
+	}
+
+	env := offlineEnv()
+	log("network unavailable, installing offline with %s", strings.Join(env, " "))
+	return runGo(env, goArgs("install", buildpath)...)
+}
+
+// offlineEnv keeps the go command from downloading, and builds modules
+// from the vendor directory when there is one.
+func offlineEnv() []string {
+	env := []string{"GOPROXY=off"}
+
+	if !inModule() {
+		return env
+	}
+	mod := goEnv("GOMOD")
+	mode := "-mod=mod"
+	if exists(filepath.Join(filepath.Dir(mod), "vendor", "modules.txt")) {
+		mode = "-mod=vendor"
+	}
+	flags := []string{mode}
+	for _, f := range strings.Fields(currentGoflags()) {
+		if !strings.HasPrefix(f, "-mod=") {
+			flags = append(flags, f)
+		}
+	}
+	return append(env, "GOFLAGS="+strings.Join(flags, " "))
+}
+
+// runGo runs the go command with extra environment and returns its
+// combined output.
+func runGo(env []string, args ...string) (string, error) {
+	cmd := gocmd(args...)
+	cmd.Env = append(cmd.Env, env...)
+
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	recordCommand("install", cmd)
+	err := cmd.Run()
+	return buf.String(), err
+}
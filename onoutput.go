@@ -0,0 +1,102 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// An outputRule acts on the lines of the program's output that match the
+// regular expression Match: it restarts the program, at most once per
+// start, or runs the shell command Run with the line in $RERUN_LINE.
+type outputRule struct {
+	Match   string `json:"match"`
+	Restart bool   `json:"restart"`
+	Run     string `json:"run"`
+
+	re *regexp.Regexp
+}
+
+// compileRules checks the on_output rules of the configuration.
+func compileRules(rules []outputRule) error {
+	for i := range rules {
+		r := &rules[i]
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("on_output: %s", err)
+		}
+		if r.Match == "" || (!r.Restart && r.Run == "") {
+			return fmt.Errorf("on_output: a rule needs a match and restart or run")
+		}
+		r.re = re
+	}
+	return nil
+}
+
+var (
+	outputMu sync.Mutex
+	restarts = map[string]int{}  // start of the program a rule last restarted
+	hooks    = map[string]bool{} // rules whose command is running
+)
+
+// ruleScanner reads the output of the program for the lines the on_output
+// rules act on.
+type ruleScanner struct {
+	partial string
+}
+
+func (s *ruleScanner) Write(p []byte) (int, error) {
+	lines := strings.Split(s.partial+string(p), "\n")
+	s.partial = lines[len(lines)-1]
+	if len(s.partial) > 4096 {
+		s.partial = ""
+	}
+	rules := conf.OnOutput
+	for _, line := range lines[:len(lines)-1] {
+		line = strings.TrimRight(line, "\r")
+		for _, r := range rules {
+			if r.re != nil && r.re.MatchString(line) {
+				fireRule(r, line)
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// fireRule carries out the actions of r for line. Actions run in the
+// background, as restarting waits for the output of the program to end.
+func fireRule(r outputRule, line string) {
+	launchMu.Lock()
+	start, pid := launches, childPid
+	launchMu.Unlock()
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if r.Run != "" && !hooks[r.Match] {
+		hooks[r.Match] = true
+		log("output matched %q, running %s", r.Match, r.Run)
+		go func() {
+			cmd := shellCommand(r.Run)
+			cmd.Env = append(cmd.Env, "RERUN_LINE="+line, "RERUN_PID="+strconv.Itoa(pid))
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				log("on_output %q: %s: %s", r.Match, r.Run, err)
+			}
+			outputMu.Lock()
+			delete(hooks, r.Match)
+			outputMu.Unlock()
+		}()
+	}
+	if r.Restart && restarts[r.Match] != start {
+		restarts[r.Match] = start
+		log("output matched %q, restarting the program", r.Match)
+		go restart()
+	}
+}
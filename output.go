@@ -0,0 +1,89 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// outputLogMax is the size at which output.log moves to output.log.1 and a
+// new one starts.
+const outputLogMax = 4 << 20
+
+// console is where rerun prints: the terminal, and the output log of an
+// attachable session, for rerun attach. The terminal is os.Stdout at the
+// time of the write, which --serve-jsonrpc stdio swaps for stderr. A
+// detached rerun has no terminal. Secrets are masked, see --redact.
+var console io.Writer = consoleWriter{}
+
+type consoleWriter struct{}
+
+func (consoleWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	p = redactBytes(p)
+	if outputLog != nil {
+		outputLog.Write(p)
+		if detached {
+			return n, nil
+		}
+	}
+	if _, err := os.Stdout.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// outputLog keeps what rerun and the program print, in output.log of the
+// session. It is nil until openOutputLog.
+var outputLog *rotatingLog
+
+// openOutputLog starts the output log of the session, anew, if it is
+// attachable. Otherwise it removes the one of an earlier rerun.
+func openOutputLog() {
+	name := statePath("output.log")
+	os.Remove(name + ".1")
+	if !attachable {
+		os.Remove(name)
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log("output log: %s", err)
+		return
+	}
+	outputLog = &rotatingLog{name: name, f: f}
+}
+
+// A rotatingLog is a file that moves to NAME.1 once it reaches
+// outputLogMax. Failed writes are dropped, the terminal has the output.
+type rotatingLog struct {
+	mu   sync.Mutex
+	name string
+	f    *os.File
+	size int64
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return len(p), nil
+	}
+	if l.size+int64(len(p)) > outputLogMax && l.size > 0 {
+		l.f.Close()
+		os.Rename(l.name, l.name+".1")
+		f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			l.f = nil
+			return len(p), nil
+		}
+		l.f, l.size = f, 0
+	}
+	n, _ := l.f.Write(p)
+	l.size += int64(n)
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var pass_fd listFlag
+
+func init() {
+	flag.Var(&pass_fd, "pass-fd", "open a socket or file once and pass it to every run, starting at fd 3: tcp:ADDR, unix:PATH, file:PATH or fifo:PATH (repeatable)")
+}
+
+var (
+	passFiles []*os.File
+	passNames []string
+)
+
+// openPassFiles opens the --pass-fd files. They stay open for the life of
+// rerun, so sockets keep accepting connections while the program restarts.
+func openPassFiles() error {
+	if len(pass_fd) > 0 && runtime.GOOS == "windows" {
+		return errors.New("--pass-fd is not supported on windows")
+	}
+
+	for _, spec := range pass_fd {
+		i := strings.IndexByte(spec, ':')
+		if i < 0 {
+			return fmt.Errorf("--pass-fd %s: want KIND:ADDRESS", spec)
+		}
+		kind, addr := spec[:i], spec[i+1:]
+
+		f, err := openPassFile(kind, addr)
+		if err != nil {
+			return fmt.Errorf("--pass-fd %s: %s", spec, err)
+		}
+		log("passing %s as fd %d", spec, 3+len(passFiles))
+		passFiles = append(passFiles, f)
+		passNames = append(passNames, strings.Replace(kind+"-"+addr, ":", "_", -1))
+	}
+	return nil
+}
+
+func openPassFile(kind, addr string) (*os.File, error) {
+	switch kind {
+	case "tcp", "tcp4", "tcp6", "unix":
+		l, err := net.Listen(kind, addr)
+		if err != nil {
+			return nil, err
+		}
+		f, err := l.(interface {
+			File() (*os.File, error)
+		}).File()
+		if err != nil {
+			return nil, err
+		}
+		atExit(func() {
+			l.Close()
+		})
+		return f, nil
+	case "file":
+		return os.OpenFile(addr, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	case "fifo":
+		if !exists(addr) {
+			if out, err := exec.Command("mkfifo", addr).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("mkfifo: %s: %s", err, strings.TrimSpace(string(out)))
+			}
+		}
+		// Opening for reading and writing doesn't wait for a peer.
+		return os.OpenFile(addr, os.O_RDWR, 0)
+	}
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+// passFDs hands the --pass-fd files to cmd the way systemd socket
+// activation does. LISTEN_PID must be the pid of the program, which is only
+// known after the fork, so a shell sets it before it execs the program.
+func passFDs(cmd *exec.Cmd) {
+	if len(passFiles) == 0 {
+		return
+	}
+
+	cmd.ExtraFiles = passFiles
+	cmd.Env = append(cmd.Env,
+		"LISTEN_FDS="+strconv.Itoa(len(passFiles)),
+		"LISTEN_FDNAMES="+strings.Join(passNames, ":"))
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return
+	}
+	cmd.Args = append([]string{"sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`}, cmd.Args...)
+	cmd.Path = sh
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	phase_list   = flag.String("phases", "", "the phases of a cycle in order, of test, build, install and run, e.g. \"build,test,install,run\"; run comes last and needs install. By default --test, --build and --no-run pick them")
+	skip_install = flag.Bool("skip-install", false, "end the cycle before the install phase: nothing is installed or run")
+	test_only    = flag.Bool("test-only", false, "only run the tests, the same as --phases test")
+	build_only   = flag.Bool("build-only", false, "only check that the program builds, the same as --phases build")
+)
+
+// phaseOrder holds the test, build and install phases of a cycle, in the
+// order they run. The test and build phases run while --test and --build
+// are on, so they can be toggled.
+var phaseOrder = []string{"test", "build", "install"}
+
+// checkPhases rejects the phases of --phases, --test-only, --build-only and
+// --skip-install that contradict each other or the flags of the single
+// phases.
+func checkPhases() error {
+	_, err := choosePhases()
+	return err
+}
+
+// choosePhases returns the phases the flags pick, in order, or nil when
+// --test, --build and --no-run pick them.
+func choosePhases() ([]string, error) {
+	chosen := 0
+	for _, set := range []bool{*phase_list != "", *test_only, *build_only} {
+		if set {
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		return nil, errors.New("--phases, --test-only and --build-only each choose the phases, give one of them")
+	}
+
+	var list []string
+	switch {
+	case *test_only:
+		list = []string{"test"}
+	case *build_only:
+		list = []string{"build"}
+	case *phase_list != "":
+		for _, name := range strings.Split(*phase_list, ",") {
+			list = append(list, strings.TrimSpace(name))
+		}
+	default:
+		if *skip_install && !*do_tests && !*do_build {
+			return nil, errors.New("--skip-install leaves no phase, add --test or --build")
+		}
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	for i, name := range list {
+		switch {
+		case name != "test" && name != "build" && name != "install" && name != "run":
+			return nil, fmt.Errorf("--phases: unknown phase %q, choose from test, build, install and run", name)
+		case seen[name]:
+			return nil, fmt.Errorf("--phases: %s is given twice", name)
+		case name == "run" && i != len(list)-1:
+			return nil, errors.New("--phases: run comes last, the other phases build what it runs")
+		}
+		seen[name] = true
+	}
+	if seen["run"] && !seen["install"] {
+		return nil, errors.New("--phases: run needs install, which builds the binary it runs")
+	}
+	if *skip_install && seen["install"] {
+		return nil, errors.New("--skip-install leaves out the install phase --phases asks for")
+	}
+	for _, f := range []struct {
+		name  string
+		on    bool
+		phase string
+	}{{"test", *do_tests, "test"}, {"build", *do_build, "build"}} {
+		if (flagSet(f.name) || configured[f.name]) && f.on && !seen[f.phase] {
+			return nil, fmt.Errorf("--%s asks for the %s phase the phases leave out", f.name, f.phase)
+		}
+	}
+	if (flagSet("no-run") || configured["no-run"]) && *no_run && seen["run"] {
+		return nil, errors.New("--no-run leaves out the run phase --phases asks for")
+	}
+	return list, nil
+}
+
+// setPhases makes the phases the flags pick those of the cycles, once the
+// startup checks passed.
+func setPhases() {
+	list, _ := choosePhases()
+	if list == nil {
+		if *skip_install {
+			phaseOrder = []string{"test", "build"}
+			*no_run = true
+		}
+		return
+	}
+	seen := map[string]bool{}
+	phaseOrder = nil
+	for _, name := range list {
+		seen[name] = true
+		if name != "run" {
+			phaseOrder = append(phaseOrder, name)
+		}
+	}
+	*do_tests, *do_build, *no_run = seen["test"], seen["build"], !seen["run"]
+	log("phases: %s", strings.Join(list, ", "))
+}
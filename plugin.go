@@ -0,0 +1,347 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Plugins are executables in --plugin-dir that take one of these roles,
+// named by "PLUGIN info", which prints {"roles": [...]} as JSON:
+//
+//	build    PLUGIN build BUILDPATH replaces go install. It runs in the
+//	         current directory with $RERUN_BIN, the binary to write, and
+//	         $RERUN_PKGDIR; its output is reported like the go command's.
+//	notify   PLUGIN notify gets every journal record, such as the end of a
+//	         cycle, as a line of JSON on stdin.
+//	watch    PLUGIN watch ROOT runs along rerun and prints a changed path
+//	         per line, instead of rerun watching the tree.
+//	exec     --executor plugin:NAME runs the program with PLUGIN deploy BIN,
+//	         which prints where the binary went, PLUGIN exec DIR -- ARGS...,
+//	         and PLUGIN stop.
+var (
+	plugin_dir = flag.String("plugin-dir", "~/.rerun/plugins", "directory of the plugins")
+	plugin_use listFlag
+)
+
+func init() {
+	flag.Var(&plugin_use, "plugin", "use this plugin of --plugin-dir (repeatable)")
+}
+
+// A plugin is an executable of --plugin-dir.
+type plugin struct {
+	name  string
+	path  string
+	Roles []string `json:"roles"`
+}
+
+func (p *plugin) has(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// plugins are the plugins given with --plugin.
+var plugins []*plugin
+
+func pluginDir() string {
+	dir := *plugin_dir
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	return dir
+}
+
+// findPlugin asks the executable name of --plugin-dir for its roles.
+func findPlugin(name string) (*plugin, error) {
+	p := &plugin{name: name, path: filepath.Join(pluginDir(), name)}
+	if fi, err := os.Stat(p.path); err != nil || fi.IsDir() {
+		return nil, fmt.Errorf("no plugin %s in %s", name, pluginDir())
+	}
+	out, err := p.command("info").Output()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: info: %s", name, err)
+	}
+	if err := json.Unmarshal(out, p); err != nil {
+		return nil, fmt.Errorf("plugin %s: info: %s", name, err)
+	}
+	return p, nil
+}
+
+func (p *plugin) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(p.path, args...)
+	cmd.Env = environ()
+	return cmd
+}
+
+// loadPlugins finds the plugins of --plugin. At most one may build and
+// one may watch.
+func loadPlugins() error {
+	roles := map[string]string{}
+	for _, name := range plugin_use {
+		p, err := findPlugin(name)
+		if err != nil {
+			return err
+		}
+		for _, r := range p.Roles {
+			switch r {
+			case "build", "watch":
+				if other := roles[r]; other != "" {
+					return fmt.Errorf("plugins %s and %s both %s", other, name, r)
+				}
+				roles[r] = name
+			case "notify", "exec":
+			default:
+				return fmt.Errorf("plugin %s: unknown role %q", name, r)
+			}
+		}
+		plugins = append(plugins, p)
+		log("plugin %s: %s", name, strings.Join(p.Roles, ", "))
+	}
+	return nil
+}
+
+// watchFailed is set when the watch plugin exited, so rerun watches by
+// itself.
+var watchFailed bool
+
+// watcher returns the watch plugin, if there is one that works.
+func watcher() *plugin {
+	if watchFailed {
+		return nil
+	}
+	return pluginWith("watch")
+}
+
+// pluginWith returns the plugin of --plugin that has role.
+func pluginWith(role string) *plugin {
+	for _, p := range plugins {
+		if p.has(role) {
+			return p
+		}
+	}
+	return nil
+}
+
+// setupPluginBuild replaces the install phase with a build plugin.
+func setupPluginBuild(t *target) {
+	p := pluginWith("build")
+	if p == nil {
+		return
+	}
+	install = func(buildpath string) (bool, error) {
+		cmd := p.command("build", buildpath)
+		cmd.Env = append(cmd.Env, "RERUN_BIN="+t.bin, "RERUN_PKGDIR="+t.dir)
+		recordCommand("install", cmd)
+		buf := bytes.NewBuffer([]byte{})
+		cmd.Stdout = buf
+		cmd.Stderr = buf
+
+		if err := cmd.Run(); err != nil {
+			log("%s build failed", p.name)
+			reportFailure("build", buf.String())
+			return false, err
+		}
+		reportSuccess("build")
+		log("%s build succeeded", p.name)
+		return true, nil
+	}
+}
+
+// notifyPlugins hands the journal record v to the notify plugins.
+func notifyPlugins(v interface{}) {
+	var b []byte
+	for _, p := range plugins {
+		if !p.has("notify") {
+			continue
+		}
+		if b == nil {
+			var err error
+			if b, err = json.Marshal(v); err != nil {
+				return
+			}
+		}
+		go func(p *plugin) {
+			cmd := p.command("notify")
+			cmd.Stdin = bytes.NewReader(append(b, '\n'))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				log("plugin %s: notify: %s\n%s", p.name, err, out)
+			}
+		}(p)
+	}
+}
+
+// watchPlugin gets the changes of root from the watch plugin p. It returns
+// when the plugin exits.
+func watchPlugin(p *plugin, root string, cb scanCallback) error {
+	cmd := p.command("watch", root)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	atExit(func() {
+		cmd.Process.Kill()
+	})
+	log("watching: %s (plugin %s)", root, p.name)
+
+	changes := make(chan string, 256)
+	go readLines(out, changes)
+	watchLines(root, root, changes, cb, false)
+	return fmt.Errorf("plugin %s exited: %v", p.name, cmd.Wait())
+}
+
+// readLines sends the lines of r to changes, and closes it at the end.
+func readLines(r io.Reader, changes chan string) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			changes <- line
+		}
+	}
+	close(changes)
+}
+
+// watchLines calls cb with the batches of the changed paths from changes,
+// relative to base unless absolute, that the rules of root don't leave
+// out, until changes is closed. The changes reported while a cycle ran are
+// dropped, as a plugin reports the writes of the build too; with requeued,
+// as for stdin, they go to the next batch instead, but for those the cycle
+// made itself.
+func watchLines(root, base string, changes chan string, cb scanCallback, requeued bool) {
+	absRoot, _ := filepath.Abs(root)
+	// path returns c in the form of root, as for the other watchers, or ""
+	// when it is left out.
+	path := func(c string) string {
+		if !filepath.IsAbs(c) {
+			c = filepath.Join(base, c)
+		}
+		abs, _ := filepath.Abs(c)
+		rel, err := filepath.Rel(absRoot, abs)
+		if err != nil || !below(absRoot, abs) {
+			explainf(c, time.Now(), "outside the watched tree %s", root)
+			return ""
+		}
+		if c = filepath.Join(root, rel); changeSkipped(root, c) {
+			return ""
+		}
+		return c
+	}
+	b := newBatch(root)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				return
+			}
+			if c = path(c); c != "" {
+				b.add(c, time.Now())
+			}
+		case <-tick.C:
+		}
+		if !b.ready() {
+			continue
+		}
+		started := snapshotPaths(b.paths)
+		b.flush(cb)
+		for drained := false; !drained; {
+			select {
+			case c, ok := <-changes:
+				switch {
+				case !ok:
+				case !requeued:
+					explainf(c, time.Now(), "changed while a cycle ran, taken for output of the build")
+				default:
+					if c = path(c); c == "" {
+						break
+					}
+					if reason := cycleWrite(c, started); reason != "" {
+						explainf(c, time.Now(), "changed while a cycle ran, %s", reason)
+					} else {
+						b.add(c, time.Now())
+					}
+				}
+				drained = !ok
+			default:
+				drained = true
+			}
+		}
+	}
+}
+
+// A pluginExecutor runs the program with an exec plugin.
+type pluginExecutor struct {
+	p *plugin
+}
+
+func (e pluginExecutor) deploy(bin string) (string, error) {
+	out, err := e.p.command("deploy", bin).Output()
+	if err != nil {
+		return "", fmt.Errorf("plugin %s: deploy: %s", e.p.name, err)
+	}
+	if dst := strings.TrimSpace(string(out)); dst != "" {
+		return dst, nil
+	}
+	return bin, nil
+}
+
+func (e pluginExecutor) command(argv, env []string, dir string) []string {
+	if dir == "" {
+		dir = "."
+	}
+	return append([]string{e.p.path, "exec", dir, "--"}, argv...)
+}
+
+func (e pluginExecutor) stop() {
+	e.p.command("stop").Run()
+}
+
+func (pluginExecutor) local() bool { return false }
+
+// pluginsCommand implements "rerun plugins", which lists the plugins of
+// --plugin-dir with their roles.
+func pluginsCommand(args []string) error {
+	infos, err := ioutil.ReadDir(pluginDir())
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && info.Mode()&0111 != 0 {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p, err := findPlugin(name); err != nil {
+			fmt.Printf("%-20s %s\n", name, err)
+		} else {
+			fmt.Printf("%-20s %s\n", name, strings.Join(p.Roles, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	pprof_proxy         = flag.String("pprof-proxy", "", "serve /debug/pprof of the program on this address, the same across restarts, e.g. :6061")
+	pprof_port          = flag.String("pprof-port", "", "port of the program's /debug/pprof, for --pprof-proxy and --pre-restart-profile; the port it listens on when empty")
+	http_pprof          = flag.Bool("http-pprof", false, "serve the profiles of rerun itself on --http under /debug/pprof/")
+	pre_restart_profile = flag.Bool("pre-restart-profile", false, "save the goroutine and heap profiles of the program in .rerun/profiles right before each restart, from its /debug/pprof")
+)
+
+const (
+	// keepProfiles is how many stops keep their profiles.
+	keepProfiles = 20
+	// profileTimeout bounds each profile, so a stuck program doesn't hold
+	// the restart.
+	profileTimeout = 3 * time.Second
+)
+
+// preRestartProfiles are the profiles saved before a stop, with the query
+// of each: the goroutine stacks as text for deadlocks, and the heap for go
+// tool pprof.
+var preRestartProfiles = []struct{ name, query string }{
+	{"goroutine.txt", "goroutine?debug=2"},
+	{"heap.pprof", "heap"},
+}
+
+// pprofPort returns the port of the program's profiles.
+func pprofPort() string {
+	if *pprof_port != "" {
+		return *pprof_port
+	}
+	return appPort()
+}
+
+// ownProfiles serves the profiles of rerun itself with --http-pprof. It
+// isn't net/http/pprof, which mounts itself on the default mux of the
+// control API, cmdline with rerun's arguments included:
+//
+//	GET /debug/pprof/              the list of the profiles
+//	GET /debug/pprof/NAME?debug=N  heap, goroutine, allocs, block, mutex, threadcreate
+//	GET /debug/pprof/profile?seconds=N
+//	                               CPU profile, 30 seconds by default
+func ownProfiles(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%s\t%d\n", p.Name(), p.Count())
+		}
+		fmt.Fprintln(w, "profile")
+		return
+	case "profile":
+		seconds, err := strconv.Atoi(r.FormValue("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = 30
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+		return
+	}
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	p.WriteTo(w, debug)
+}
+
+// servePprofProxy forwards /debug/pprof on --pprof-proxy to whichever run
+// of the program is current, so profiling tools keep their URL.
+func servePprofProxy() {
+	if *pprof_proxy == "" {
+		return
+	}
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "127.0.0.1:" + pprofPort()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "the program doesn't answer on port "+pprofPort()+": "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+			http.NotFound(w, r)
+			return
+		}
+		rp.ServeHTTP(w, r)
+	})
+
+	l, err := listenTCP("pprof-proxy", pprof_proxy)
+	if err != nil {
+		log("pprof: %s", err)
+		return
+	}
+	log("pprof: profiles of the program on http://%s/debug/pprof/", *pprof_proxy)
+	go func() {
+		if err := http.Serve(l, h); err != nil {
+			log("pprof: %s", err)
+		}
+	}()
+}
+
+// profileBeforeStop saves the profiles of the program started by the cycle
+// build, which is about to be stopped.
+func profileBeforeStop(build int) {
+	if !*pre_restart_profile {
+		return
+	}
+	if isSuspended() {
+		log("--pre-restart-profile: the program is suspended and can't answer, no profiles")
+		return
+	}
+	port := pprofPort()
+	if port == "" {
+		log("--pre-restart-profile: the port of the program is unknown, set --pprof-port")
+		return
+	}
+	root := statePath("profiles")
+	dir := filepath.Join(root, fmt.Sprintf("%s-build%d", time.Now().Format("20060102-150405"), build))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log("--pre-restart-profile: %s", err)
+		return
+	}
+	client := &http.Client{Timeout: profileTimeout}
+	var saved []string
+	for _, p := range preRestartProfiles {
+		if err := saveProfile(client, "http://127.0.0.1:"+port+"/debug/pprof/"+p.query, filepath.Join(dir, p.name)); err != nil {
+			log("--pre-restart-profile: %s: %s", p.name, err)
+			continue
+		}
+		saved = append(saved, p.name)
+	}
+	if len(saved) == 0 {
+		os.Remove(dir)
+		return
+	}
+	log("saved %s of build %d in %s", strings.Join(saved, " and "), build, dir)
+	pruneProfiles(root)
+}
+
+func saveProfile(client *http.Client, url, name string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	return f.Close()
+}
+
+// pruneProfiles removes the profiles of all but the last keepProfiles
+// stops; their names sort by time.
+func pruneProfiles(root string) {
+	list, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	var dirs []string
+	for _, fi := range list {
+		if fi.IsDir() {
+			dirs = append(dirs, fi.Name())
+		}
+	}
+	sort.Strings(dirs)
+	for len(dirs) > keepProfiles {
+		os.RemoveAll(filepath.Join(root, dirs[0]))
+		dirs = dirs[1:]
+	}
+}
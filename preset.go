@@ -0,0 +1,170 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	preset = flag.String("preset", "", "comma-separated presets of ignore patterns and extensions: "+presetNames())
+	ext    = flag.String("ext", "", "comma-separated extensions of the files that start a cycle, e.g. go,tmpl; all files when empty")
+)
+
+// A watchPreset holds defaults for --ignore and --ext. Given flags replace
+// the values of the presets.
+type watchPreset struct {
+	ignore, ext string
+}
+
+var presets = map[string]watchPreset{
+	"go-web": {"node_modules,dist,.git,tmp", "go,tmpl,env"},
+	"go-cli": {".git,tmp,dist,bin", "go,mod,sum"},
+}
+
+// osIgnore are the files the system leaves around, ignored by every
+// preset.
+var osIgnore = map[string]string{
+	"darwin":  ".DS_Store,._*,.Spotlight-V100,.Trashes",
+	"windows": "Thumbs.db,desktop.ini,~$*,$RECYCLE.BIN",
+	"linux":   ".fuse_hidden*,.nfs*,.Trash-*",
+}
+
+func presetNames() string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// checkPresets rejects unknown presets.
+func checkPresets() error {
+	for _, name := range splitList(*preset) {
+		if _, ok := presets[name]; !ok {
+			return fmt.Errorf("--preset: unknown preset %q, choose from %s", name, presetNames())
+		}
+	}
+	return nil
+}
+
+// watchRules are the effective ignore patterns and extensions, each with
+// the setting it came from.
+type watchRules struct {
+	key    string
+	ignore [][2]string // pattern, source
+	exts   map[string]string
+}
+
+var (
+	rulesMu sync.Mutex
+	rules   watchRules
+)
+
+// currentRules returns the rules for the flags as they are now. They are
+// computed again only when a configuration reload changed the flags.
+func currentRules() watchRules {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	key := *preset + "|" + *ignore + "|" + *ext
+	if rules.key == key && rules.key != "" {
+		return rules
+	}
+
+	r := watchRules{key: key}
+	add := func(patterns, source string) {
+		for _, p := range splitList(patterns) {
+			r.ignore = append(r.ignore, [2]string{p, source})
+		}
+	}
+	addExts := func(exts, source string) {
+		if r.exts == nil {
+			r.exts = map[string]string{}
+		}
+		for _, e := range splitList(exts) {
+			r.exts[strings.TrimPrefix(e, ".")] = source
+		}
+	}
+
+	if *ignore != "" {
+		add(*ignore, "--ignore")
+	}
+	if *ext != "" {
+		addExts(*ext, "--ext")
+	}
+	for _, name := range splitList(*preset) {
+		p := presets[name]
+		if *ignore == "" {
+			add(p.ignore, "--preset "+name)
+			add(osIgnore[runtime.GOOS], "--preset "+name)
+		}
+		if *ext == "" {
+			addExts(p.ext, "--preset "+name)
+		}
+	}
+	rules = r
+	return r
+}
+
+// skipRule names the ignore pattern or extension rule that leaves p out
+// of the watch, if any.
+func (r watchRules) skipRule(p string, isDir bool) string {
+	base := path.Base(filepath.ToSlash(p))
+	for _, rule := range r.ignore {
+		if match, _ := path.Match(rule[0], base); match {
+			return rule[1] + ": " + rule[0]
+		}
+	}
+	if !isDir && r.exts != nil && !included(p) && !ownExts(p) {
+		e := strings.TrimPrefix(filepath.Ext(p), ".")
+		if _, ok := r.exts[e]; !ok {
+			for _, source := range r.exts {
+				return source + ": not a watched extension"
+			}
+		}
+	}
+	return ""
+}
+
+// describe lists the rules for the startup message.
+func (r watchRules) describe() string {
+	var patterns, exts []string
+	for _, rule := range r.ignore {
+		patterns = append(patterns, rule[0])
+	}
+	for e := range r.exts {
+		exts = append(exts, e)
+	}
+	sort.Strings(exts)
+	s := ""
+	if len(patterns) > 0 {
+		s = "ignoring " + strings.Join(patterns, ", ")
+	}
+	if len(exts) > 0 {
+		if s != "" {
+			s += "; "
+		}
+		s += "watching the extensions " + strings.Join(exts, ", ")
+	}
+	return s
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
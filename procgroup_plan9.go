@@ -0,0 +1,33 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// programGroup starts the program in a note group of its own. Plan 9 has
+// no signals: the notes posted to the group reach the processes the
+// program starts, as a process group does elsewhere, and not rerun.
+func programGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Rfork: syscall.RFNOTEG}
+}
+
+// signalProgram posts the note of sig, interrupt or kill, to the note group
+// of p, or to p alone when that fails.
+func signalProgram(p *os.Process, sig os.Signal) error {
+	note := "interrupt"
+	if sig == os.Kill {
+		note = "kill"
+	}
+	if err := ioutil.WriteFile("/proc/"+strconv.Itoa(p.Pid)+"/notepg", []byte(note), 0); err == nil {
+		return nil
+	}
+	return p.Signal(sig)
+}
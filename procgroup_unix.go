@@ -0,0 +1,34 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// programGroup starts the program in a process group of its own, so the
+// signals that stop it also reach what it starts, such as the commands of
+// a shell script or the program under --wrap.
+func programGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalProgram sends sig to the process group of p, or to p alone when it
+// leads none.
+func signalProgram(p *os.Process, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		if err := syscall.Kill(-p.Pid, s); err != syscall.ESRCH {
+			return err
+		}
+	}
+	return p.Signal(sig)
+}
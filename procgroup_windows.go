@@ -0,0 +1,18 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// programGroup leaves the program as it is: Windows has no process groups
+// to signal.
+func programGroup(cmd *exec.Cmd) {}
+
+func signalProgram(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
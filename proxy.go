@@ -0,0 +1,138 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+var (
+	proxy    = flag.String("proxy", "", "serve a reverse proxy to the program on this address, e.g. :3000")
+	app_port = flag.String("app-port", "8080", "port the program listens on, for --proxy")
+	on_5xx   = flag.String("on-5xx", "", "command template run when the program first answers 5xx after a restart, e.g. \"chromium --headless --screenshot={{.Out}} {{.URL}}\"")
+)
+
+var (
+	launchMu sync.Mutex
+	launches int // number of times the program was started
+)
+
+// launched records a start of the program.
+func launched(pid int) {
+	launchMu.Lock()
+	launches++
+	childPid = pid
+	launchMu.Unlock()
+	titleRunning(pid)
+	setSessionProgram(pid)
+	go detectPort(pid)
+}
+
+// stopped records the end of the program.
+func stopped(pid int) {
+	launchMu.Lock()
+	ended := childPid == pid
+	if ended {
+		childPid = 0
+	}
+	launchMu.Unlock()
+	forgetSuspended(pid)
+	if ended {
+		setSessionProgram(0)
+	}
+}
+
+// failureData is handed to the --on-5xx template.
+type failureData struct {
+	URL    string // proxied URL of the failing request
+	Status int    // status code
+	Out    string // suggested output file, e.g. for a screenshot
+}
+
+func serveProxy() {
+	if *proxy == "" {
+		return
+	}
+
+	rp := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "127.0.0.1:" + appPort()
+		},
+	}
+	captured := -1
+	rp.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode < 500 || resp.Request.Method != "GET" {
+			return nil
+		}
+
+		launchMu.Lock()
+		first := captured != launches
+		captured = launches
+		launchMu.Unlock()
+
+		if first {
+			u := *resp.Request.URL
+			u.Scheme = "http"
+			u.Host = resp.Request.Host
+			log("proxy: %s answered %d after restart", u.RequestURI(), resp.StatusCode)
+			go capture(u.String(), resp.StatusCode)
+		}
+		return nil
+	}
+
+	l, err := listenTCP("proxy", proxy)
+	if err != nil {
+		log("proxy: %s", err)
+		return
+	}
+	if detecting() {
+		log("proxy: serving the program on %s, port %s until another is detected", *proxy, *app_port)
+	} else {
+		log("proxy: serving 127.0.0.1:%s on %s", *app_port, *proxy)
+	}
+	go func() {
+		if err := http.Serve(l, rp); err != nil {
+			log("proxy: %s", err)
+		}
+	}()
+}
+
+// capture runs the --on-5xx hook for a failing page.
+func capture(u string, status int) {
+	if *on_5xx == "" {
+		return
+	}
+
+	if err := os.MkdirAll(statePath("failures"), 0755); err != nil {
+		log("proxy: %s", err)
+		return
+	}
+	out := statePath("failures/" + time.Now().Format("20060102-150405") + ".png")
+	data := failureData{
+		URL:    shellQuote(u),
+		Status: status,
+		Out:    shellQuote(out),
+	}
+
+	words, err := expandCommand(*on_5xx, data)
+	if err != nil {
+		log("proxy: %s", err)
+		return
+	}
+	cmd := exec.Command(words[0], words[1:]...)
+	cmd.Env = append(os.Environ(), buildEnv()...)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		log("proxy: %s: %s\n%s", words[0], err, b)
+		return
+	}
+	log("proxy: captured %s", out)
+}
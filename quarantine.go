@@ -0,0 +1,86 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"sync"
+)
+
+var keep_running = flag.Bool("keep-running-on-failure", false, "keep the last good build running while the cycles fail, marked stale, instead of stopping the program")
+
+var quarantine struct {
+	sync.Mutex
+	stale    bool // the program runs a build older than the failing cycles
+	good     bool // the binary is the build of a cycle that succeeded
+	rejected bool // the binary failed its checks after go install wrote it
+}
+
+// cycleFailed stops the program after a failed cycle. With
+// --keep-running-on-failure the last good build keeps running instead, or
+// is started again when it crashed, and the status says it is stale.
+func cycleFailed(ch chan bool) {
+	if !*keep_running {
+		ch <- false
+		return
+	}
+	launchMu.Lock()
+	running := childPid != 0
+	launchMu.Unlock()
+	quarantine.Lock()
+	good := quarantine.good
+	again := !running && good && !*no_run && !holdRun
+	quarantine.stale = running || again
+	quarantine.Unlock()
+
+	switch {
+	case running:
+		log("build failing, the last good build keeps running (stale)")
+	case again:
+		log("build failing, starting the last good build again (stale)")
+		ch <- true
+	default:
+		log("build failing, there is no good build to run")
+	}
+}
+
+// binaryRejected records that the binary was replaced by a build that
+// failed its checks, so it isn't started in place of the last good one.
+func binaryRejected() {
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	quarantine.good = false
+	quarantine.rejected = true
+	if *keep_running {
+		quarantine.stale = true
+	}
+}
+
+// cycleSucceeded clears the stale status: the next start runs an up to date
+// build.
+func cycleSucceeded() {
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	quarantine.good = true
+	quarantine.stale = false
+	quarantine.rejected = false
+}
+
+// startRejected reports whether starting bin would run a build that failed
+// its checks. Every start of the program goes through run, so the restarts
+// of the keys, the control API, on-output and the dirs rules can't start
+// it either; a rollback to a kept build can.
+func startRejected(bin string) bool {
+	quarantine.Lock()
+	rejected := quarantine.rejected
+	quarantine.Unlock()
+	return rejected && runBinary(bin) == bin
+}
+
+func isStale() bool {
+	quarantine.Lock()
+	defer quarantine.Unlock()
+	return quarantine.stale
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "sync"
+
+// raceNext is set when the next cycle builds with the race detector.
+var raceNext struct {
+	sync.Mutex
+	armed bool
+}
+
+// cycleRace is set while a cycle builds with -race. Cycles don't overlap.
+var cycleRace bool
+
+// armRace makes the next cycle, and only that one, build with -race.
+func armRace() {
+	raceNext.Lock()
+	raceNext.armed = true
+	raceNext.Unlock()
+	log("the next cycle builds with the race detector")
+}
+
+// takeRace starts a cycle: it reports whether the cycle builds with -race
+// and disarms the race detector for the cycles after it.
+func takeRace() bool {
+	raceNext.Lock()
+	defer raceNext.Unlock()
+	armed := raceNext.armed
+	raceNext.armed = false
+	return armed
+}
+
+// raceVerbs are the go subcommands that take -race.
+var raceVerbs = map[string]bool{"build": true, "install": true, "get": true, "test": true}
@@ -0,0 +1,214 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var redact = flag.String("redact", "", "mask the values of these environment variables, separated by commas, wherever they show up: in the output of the program and of rerun, the output log and the journal. The variables of the env config and of fixtures with secret-looking names are masked too")
+
+const (
+	redactMask = "[redacted]"
+	// minSecret is the length below which values, such as 1 or true,
+	// aren't masked.
+	minSecret = 4
+	// redactWait is how long the end of a write that may start a secret
+	// waits for the rest.
+	redactWait = 100 * time.Millisecond
+)
+
+// secretName matches the names of variables that hold secrets.
+var secretName = regexp.MustCompile(`(?i)secret|token|passw(or)?d|passphrase|api_?key|private_?key|credential|auth`)
+
+var redaction struct {
+	sync.RWMutex
+	keys     string
+	secrets  []string // longest first
+	replacer *strings.Replacer
+}
+
+// updateRedaction collects the values to mask from the environment of the
+// program: rerun's own and the variables of the config and the fixtures.
+func updateRedaction() {
+	env := map[string]string{}
+	for _, kv := range append(append(os.Environ(), extraEnv()...), fixtureEnv...) {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	keys := map[string]bool{}
+	for _, k := range strings.Split(*redact, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys[k] = true
+		}
+	}
+	configured := append([]string(nil), fixtureEnv...)
+	for k := range conf.Env {
+		configured = append(configured, k+"=")
+	}
+	for _, kv := range configured {
+		if k := kv[:strings.IndexByte(kv, '=')]; secretName.MatchString(k) {
+			keys[k] = true
+		}
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	var secrets []string
+	for k := range keys {
+		v := env[k]
+		if len(v) < minSecret {
+			continue
+		}
+		names = append(names, k)
+		// In JSON, a secret may show up escaped.
+		b, _ := json.Marshal(v)
+		for _, s := range []string{v, string(b[1 : len(b)-1])} {
+			if !seen[s] {
+				seen[s] = true
+				secrets = append(secrets, s)
+			}
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	sort.Strings(names)
+
+	var replacer *strings.Replacer
+	if len(secrets) > 0 {
+		var pairs []string
+		for _, s := range secrets {
+			pairs = append(pairs, s, redactMask)
+		}
+		replacer = strings.NewReplacer(pairs...)
+	}
+	list := strings.Join(names, ", ")
+	redaction.Lock()
+	redaction.secrets, redaction.replacer = secrets, replacer
+	changed := list != redaction.keys
+	redaction.keys = list
+	redaction.Unlock()
+	if changed && list != "" {
+		log("masking the values of %s", list)
+	}
+}
+
+func redacting() bool {
+	redaction.RLock()
+	defer redaction.RUnlock()
+	return redaction.replacer != nil
+}
+
+// redactBytes masks the secrets in b.
+func redactBytes(b []byte) []byte {
+	redaction.RLock()
+	r := redaction.replacer
+	redaction.RUnlock()
+	if r == nil {
+		return b
+	}
+	return []byte(r.Replace(string(b)))
+}
+
+// redactStrings returns list with the secrets masked, a copy if there are
+// any.
+func redactStrings(list []string) []string {
+	if !redacting() || list == nil {
+		return list
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = string(redactBytes([]byte(s)))
+	}
+	return out
+}
+
+// redactValue returns v, marshalled with the secrets masked when there are
+// any, for the journal and its listeners.
+func redactValue(v interface{}) interface{} {
+	if !redacting() {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	return json.RawMessage(redactBytes(b))
+}
+
+// heldSuffix returns the length of the longest end of b that starts a
+// secret without completing it.
+func heldSuffix(b []byte) int {
+	redaction.RLock()
+	defer redaction.RUnlock()
+	held := 0
+	for _, s := range redaction.secrets {
+		for k := len(s) - 1; k > held; k-- {
+			if k <= len(b) && bytes.HasSuffix(b, []byte(s[:k])) {
+				held = k
+				break
+			}
+		}
+	}
+	return held
+}
+
+// redactOutput returns w, masking the secrets in what the program writes
+// to it.
+func redactOutput(w io.Writer) io.Writer {
+	if !redacting() {
+		return w
+	}
+	return &redactWriter{w: w}
+}
+
+// A redactWriter masks the secrets in the writes to w. A secret split
+// between two writes is masked too: the end of a write that may start one
+// waits up to redactWait for the next write.
+type redactWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending []byte
+	timer   *time.Timer
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	data := append(r.pending, p...)
+	held := heldSuffix(data)
+	r.pending = append([]byte(nil), data[len(data)-held:]...)
+	if out := data[:len(data)-held]; len(out) > 0 {
+		if _, err := r.w.Write(redactBytes(out)); err != nil {
+			return 0, err
+		}
+	}
+	if held > 0 {
+		r.timer = time.AfterFunc(redactWait, r.flush)
+	}
+	return len(p), nil
+}
+
+func (r *redactWriter) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.w.Write(redactBytes(r.pending))
+		r.pending = nil
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// maskSecrets makes secrets the values to mask for the length of the
+// test, as updateRedaction does.
+func maskSecrets(t *testing.T, secrets ...string) {
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var pairs []string
+	for _, s := range secrets {
+		pairs = append(pairs, s, redactMask)
+	}
+	redaction.Lock()
+	redaction.secrets, redaction.replacer = secrets, strings.NewReplacer(pairs...)
+	redaction.Unlock()
+	t.Cleanup(func() {
+		redaction.Lock()
+		redaction.secrets, redaction.replacer = nil, nil
+		redaction.Unlock()
+	})
+}
+
+func TestHeldSuffix(t *testing.T) {
+	maskSecrets(t, "hunter22", "s3cr3t-token")
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"nothing here\n", 0},
+		{"the password is h", 1},
+		{"the password is hunt", 4},
+		{"the password is hunter2", 7},
+		{"the password is hunter22", 0},
+		{"token: s3cr3t-t", 8},
+		{"s3cr3t-toke", 11},
+		{"hunter22 and s", 1},
+		{"shunt", 4},
+		{"hunted", 0},
+	}
+	for _, tt := range tests {
+		if got := heldSuffix([]byte(tt.in)); got != tt.want {
+			t.Errorf("heldSuffix(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	maskSecrets(t, "hunter22", "s3cr3t-token")
+	tests := []struct {
+		writes []string
+		want   string
+	}{
+		{[]string{"the password is hunter22\n"}, "the password is [redacted]\n"},
+		{[]string{"the password is hun", "ter22, keep it\n"}, "the password is [redacted], keep it\n"},
+		{[]string{"hunte", "r", "22"}, "[redacted]"},
+		{[]string{"s3cr3t-", "token hunter22\n"}, "[redacted] [redacted]\n"},
+		{[]string{"hunt", "ing season\n"}, "hunting season\n"},
+		// The start of a secret that never completes comes out as is.
+		{[]string{"the password is hunter2"}, "the password is hunter2"},
+		{[]string{"a", "b", "c\n"}, "abc\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := &redactWriter{w: &out}
+		for _, s := range tt.writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", s, n, err)
+			}
+		}
+		w.flush()
+		w.mu.Lock()
+		got := out.String()
+		w.mu.Unlock()
+		if got != tt.want {
+			t.Errorf("writes %q: got %q, want %q", tt.writes, got, tt.want)
+		}
+	}
+}
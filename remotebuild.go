@@ -0,0 +1,205 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var remote_build = flag.String("remote-build", "", "build on another machine, e.g. ssh://buildbox, ssh://user@host:2222/src/app or ssh://host/~/app: rsync the module there, build it, and copy the binary back; with --executor ssh:HOST on the same host it runs there")
+
+// remoteBuilt is the binary --remote-build leaves on the host the program
+// runs on with --executor ssh, which deploy copies there instead of the
+// local copy.
+var remoteBuilt struct {
+	host  string
+	bin   string
+	local string // its local copy
+}
+
+// setupRemoteBuild replaces the install phase with an rsync of the module
+// to the build host, go build there, and an rsync of the binary back.
+func setupRemoteBuild(t *target) error {
+	if *remote_build == "" {
+		return nil
+	}
+	if tinygoEnabled() || *bazel_target != "" || *use_gomobile != "" || *kube_resource != "" {
+		return fmt.Errorf("--remote-build doesn't go with --tinygo, --bazel, --gomobile or --kube")
+	}
+	u, err := url.Parse(*remote_build)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return fmt.Errorf("invalid --remote-build %q, want ssh://[USER@]HOST[:PORT][/DIR]", *remote_build)
+	}
+	for _, tool := range []string{"ssh", "rsync"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("--remote-build: %s", err)
+		}
+	}
+	mod := goEnv("GOMOD")
+	if mod == "" || mod == os.DevNull {
+		return fmt.Errorf("--remote-build copies a module, %s isn't in one", t.buildpath)
+	}
+	root := filepath.Dir(mod)
+	if err := checkRemoteReplaces(root); err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	pkg, err := filepath.Rel(root, t.dir)
+	if err != nil || !below(root, t.dir) {
+		return fmt.Errorf("--remote-build: %s isn't below the module root %s", t.dir, root)
+	}
+	// The build runs in the same directory of the module as here, so the
+	// file names in its errors are the local ones.
+	cwd := "."
+	if below(root, wd) {
+		cwd, _ = filepath.Rel(root, wd)
+		if pkg, err = filepath.Rel(wd, t.dir); err != nil {
+			return err
+		}
+	}
+
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	ssh := []string{"ssh"}
+	if u.Port() != "" {
+		ssh = append(ssh, "-p", u.Port())
+	}
+	// The path is absolute; /~/DIR is below the home directory. By default
+	// every checkout has its own below it.
+	dir := u.Path
+	switch {
+	case dir == "" || dir == "/":
+		sum := sha256.Sum256([]byte(root))
+		dir = "rerun-builds/" + filepath.Base(root) + "-" + hex.EncodeToString(sum[:4])
+	case strings.HasPrefix(dir, "/~/"):
+		dir = dir[3:]
+	}
+
+	sh := func(s string) []string {
+		return append(append([]string(nil), ssh...), host, s)
+	}
+	out, err := exec.Command(ssh[0], sh("mkdir -p " + shellQuote(dir) + " && cd " + shellQuote(dir) + " && pwd")[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("--remote-build: %s: %s", host, strings.TrimSpace(string(out)))
+	}
+	abs := strings.TrimSpace(string(out))
+
+	name := strings.TrimSuffix(filepath.Base(t.bin), ".exe")
+	remoteBin := path.Join(abs, ".rerun", "remote", name)
+
+	// The program runs on the build host: build for it, and leave the
+	// binary there.
+	env := crossEnv
+	if e, ok := runner.(*remoteExecutor); ok && e.kind == "ssh" && (e.name == host || e.name == u.Hostname()) {
+		if crossCompiling() {
+			return fmt.Errorf("--remote-build builds for %s, which runs the program: drop the cross compilation flags", e.name)
+		}
+		remoteBuilt.host, remoteBuilt.bin = e.name, remoteBin
+		env = nil
+	} else if !crossCompiling() {
+		env = []string{"GOOS=" + runtime.GOOS, "GOARCH=" + runtime.GOARCH}
+	}
+	if goflags := currentGoflags(); goflags != "" {
+		env = append(env, "GOFLAGS="+goflags)
+	}
+	if !strings.HasPrefix(pkg, ".") {
+		pkg = "./" + pkg
+	}
+
+	bin, err := filepath.Abs(statePath("remote/" + filepath.Base(t.bin)))
+	if err != nil {
+		return err
+	}
+	t.bin = bin
+	if remoteBuilt.bin != "" {
+		remoteBuilt.local = bin
+	}
+	if *do_build {
+		log("--build doesn't apply with --remote-build, the install phase builds")
+		*do_build = false
+	}
+
+	rsh := strings.Join(ssh, " ")
+	install = func(buildpath string) (bool, error) {
+		// An edit of go.mod may have added a replacement since.
+		if err := checkRemoteReplaces(root); err != nil {
+			log("%s", err)
+			reportFailure("remote build", err.Error())
+			return false, err
+		}
+		if ok, err := runStep("rsync sources", "rsync", "-az", "--delete", "-e", rsh,
+			"--exclude=/.git", "--exclude=/.rerun", "--filter=:- .gitignore",
+			root+"/", host+":"+dir+"/"); !ok {
+			return ok, err
+		}
+		script := "cd " + shellQuote(path.Join(abs, filepath.ToSlash(cwd))) + " &&"
+		if len(env) > 0 {
+			script += " env " + shellJoin(env)
+		}
+		script += " go " + shellJoin(goArgs("build", "-o", remoteBin, filepath.ToSlash(pkg)))
+		if ok, err := remoteStep("remote build", abs, root, sh(script)); !ok {
+			return ok, err
+		}
+		if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+			return false, err
+		}
+		return runStep("rsync binary", "rsync", "-az", "-e", rsh, host+":"+remoteBin, bin)
+	}
+	log("building on %s in %s", host, abs)
+	return nil
+}
+
+// checkRemoteReplaces fails when go.mod replaces a module with a directory
+// outside root: only the module goes to the build host, so its build
+// wouldn't use the local replacement.
+func checkRemoteReplaces(root string) error {
+	var outside []string
+	for dir := range replaceDirs() {
+		if !below(root, dir) {
+			outside = append(outside, dir)
+		}
+	}
+	if len(outside) == 0 {
+		return nil
+	}
+	sort.Strings(outside)
+	return fmt.Errorf("--remote-build copies the module only, but go.mod replaces modules with %s outside it", strings.Join(outside, ", "))
+}
+
+// remoteStep runs the phase step with argv on the build host, with the
+// directory abs there shown as root in its output.
+func remoteStep(step, abs, root string, argv []string) (bool, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	recordCommand(step, cmd)
+
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	if err := cmd.Run(); err != nil {
+		log("%s failed", step)
+		reportFailure(step, strings.Replace(buf.String(), abs, root, -1))
+		return false, err
+	}
+	reportSuccess(step)
+	log("%s succeeded", step)
+	return true, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var watch_replace = flag.Bool("watch-replace", true, "watch the local directories of the replace directives of go.mod too, such as a sibling checkout of a dependency, and rebuild when they change")
+
+// replaced holds the directories of the replace directives that are
+// watched, to their module paths. They are only added: a directive dropped
+// from go.mod leaves an idle watch behind, which doesn't start cycles the
+// build ignores on its own.
+var replaced struct {
+	sync.Mutex
+	dirs map[string]string
+}
+
+// replaceDirs returns the local directories go.mod replaces modules with,
+// to the module paths, or nil outside a module.
+func replaceDirs() map[string]string {
+	mod := goEnv("GOMOD")
+	if mod == "" || mod == os.DevNull {
+		return nil
+	}
+	out, err := gocmd("mod", "edit", "-json", mod).Output()
+	if err != nil {
+		log("--watch-replace: go mod edit -json %s: %s", mod, err)
+		return nil
+	}
+	var f struct {
+		Replace []struct {
+			Old struct{ Path string }
+			New struct{ Path, Version string }
+		}
+	}
+	if err := json.Unmarshal(out, &f); err != nil {
+		log("--watch-replace: %s: %s", mod, err)
+		return nil
+	}
+	dirs := map[string]string{}
+	for _, r := range f.Replace {
+		// A replacement without a version is a directory, relative to the
+		// module root.
+		if r.New.Version != "" {
+			continue
+		}
+		dir := r.New.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(mod), dir)
+		}
+		dirs[filepath.Clean(dir)] = r.Old.Path
+	}
+	return dirs
+}
+
+// watchReplaces starts watching the replacement directories of go.mod
+// outside root that aren't watched yet. Their changes run a cycle of t.
+func watchReplaces(root string, t *target, ch chan bool) {
+	if !*watch_replace || *on == "commit" {
+		return
+	}
+	root, _ = filepath.Abs(root)
+	dirs := replaceDirs()
+	list := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		list = append(list, dir)
+	}
+	// Parents first, so a directory below another is left to its watch.
+	sort.Strings(list)
+
+	replaced.Lock()
+	defer replaced.Unlock()
+	if replaced.dirs == nil {
+		replaced.dirs = map[string]string{}
+	}
+next:
+	for _, dir := range list {
+		if below(root, dir) {
+			continue
+		}
+		for watched := range replaced.dirs {
+			if below(watched, dir) {
+				continue next
+			}
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			log("--watch-replace: %s replaces %s but isn't a directory", dir, dirs[dir])
+			continue
+		}
+		replaced.dirs[dir] = dirs[dir]
+		log("watching %s too, it replaces %s", dir, dirs[dir])
+		go watchReplaced(dir, dirs[dir], t, ch)
+	}
+}
+
+// modChanged reports whether paths, which are nil for a change of the
+// whole tree, include a go.mod.
+func modChanged(paths []string) bool {
+	for _, p := range paths {
+		if filepath.Base(p) == "go.mod" {
+			return true
+		}
+	}
+	return paths == nil
+}
+
+// watchReplaced runs a cycle of t whenever a file below dir, which
+// replaces module, changes.
+func watchReplaced(dir, module string, t *target, ch chan bool) {
+	changed := func(paths []string) {
+		log("change detected in %s, the replacement of %s", dir, module)
+		refresh(t, ch, paths)
+	}
+	for {
+		switch {
+		case hashedTree(dir):
+			pollHashes(dir, changed)
+		case *events:
+			if err := watchEvents(dir, changed); err != errGone {
+				log("event backend unavailable for %s (%s), polling it instead", dir, err)
+				scanChanges(dir, changed)
+			}
+		default:
+			scanChanges(dir, changed)
+		}
+
+		log("%s, the replacement of %s, disappeared, waiting for it to return", dir, module)
+		for !exists(dir) {
+			time.Sleep(time.Second)
+		}
+		changed(nil)
+	}
+}
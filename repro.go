@@ -0,0 +1,53 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+)
+
+var repro = flag.Bool("repro", false, "build reproducibly, with -trimpath, -buildvcs=false and a fixed -ldflags, and print the full hash of the binary every cycle, to compare it with another machine")
+
+// reproFlags make the binary depend only on the sources, the toolchain
+// and the target: no paths, no VCS stamp, no build ID of the link.
+var reproFlags = []string{"-trimpath", "-buildvcs=false", "-ldflags=-buildid="}
+
+// reproVerbs are the go subcommands that take reproFlags.
+var reproVerbs = map[string]bool{"build": true, "install": true, "get": true, "test": true}
+
+// checkRepro rejects the builds --repro doesn't control, and flags that
+// override its own.
+func checkRepro() error {
+	if !*repro {
+		return nil
+	}
+	switch {
+	case *use_tinygo || *tinygo_target != "":
+		return errors.New("--repro builds with the go command, not tinygo")
+	case *bazel_target != "":
+		return errors.New("--repro builds with the go command, Bazel has its own settings")
+	}
+	flags, _ := splitWords(*build_flags)
+	for _, f := range append(flags, strings.Fields(currentGoflags())...) {
+		if name := strings.TrimLeft(strings.SplitN(f, "=", 2)[0], "-"); name == "ldflags" || name == "buildvcs" {
+			return errors.New("--repro sets -" + name + ", drop it from --build-flags and GOFLAGS")
+		}
+	}
+	return nil
+}
+
+// reportRepro prints what a matching build elsewhere needs.
+func reportRepro() {
+	if !*repro {
+		return
+	}
+	version := "go"
+	if out, err := gocmd("version").CombinedOutput(); err == nil {
+		version = strings.TrimSpace(string(out))
+	}
+	log("repro: %s, go build %s; the same sources, toolchain and target build the same binary", version, strings.Join(reproFlags, " "))
+}
@@ -6,111 +6,165 @@ package main
 
 import (
 	"bytes"
-	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
-	"path"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
-
-	"go/build"
 )
 
 var (
 	do_tests = flag.Bool("test", false, "Run tests (before running program)")
 	do_build = flag.Bool("build", false, "Build program")
-	ignore   = flag.String("ignore", "", "ignore by special pattern")
+	ignore   = flag.String("ignore", "", "comma-separated patterns of file and directory names to ignore, e.g. 'tmp*,*.log'")
 	no_git   = flag.Bool("no-git", true, "ignore .git directory")
 	watch    = flag.String("watch", "", "root directory to watch")
 	goexec   = flag.String("goexec", "", "bin directory of go")
+
+	diff_errors        = flag.Bool("diff-errors", true, "collapse diagnostics already seen in the previous failure")
+	events             = flag.Bool("events", false, "use file system events instead of polling where supported")
+	run_cmd            = flag.String("run-cmd", "", "run the binary through this command template, e.g. \"strace -f {{.Bin}} {{.Args}}\"")
+	journal            = flag.Bool("journal", false, "append a record of every cycle to .rerun/journal.jsonl")
+	deps               = flag.Bool("deps", false, "report the number of dependencies after each build")
+	size_alert         = flag.Float64("size-alert", 10, "warn when the binary grows by more than this percentage")
+	no_run             = flag.Bool("no-run", false, "don't run the program, only test, build and install it")
+	no_initial_run     = flag.Bool("no-initial-run", false, "don't run a cycle at startup, wait for the first change")
+	initial_build_only = flag.Bool("initial-build-only", false, "build at startup, but don't start the program before the first change")
+	workdir            = flag.String("workdir", "", "working directory of the program, e.g. \"{{.PkgDir}}/testdata\"")
+	build_flags        = flag.String("build-flags", "", "extra flags for go build, install and test, e.g. \"-tags dev\"")
+	before             = flag.String("before", "", "shell command run at the start of every cycle")
+	do_vet             = flag.Bool("vet", false, "run go vet (before the tests)")
+	do_generate        = flag.Bool("generate", false, "run go generate at the start of every cycle")
+	generated          = flag.String("generated", "", "comma separated patterns of generated files that never trigger a cycle")
+	debounce           = flag.Duration("debounce", 0, "wait until files stop changing for this long before rebuilding")
 )
 
-func buildpathDir(buildpath string) (string, error) {
-	pkg, err := build.Import(buildpath, "", 0)
+// A target is the program that rerun builds and runs.
+type target struct {
+	buildpath string // import path, or file name for scripts
+	bin       string // the binary that is run
+	dir       string // source directory
+}
+
+func log(format string, args ...interface{}) {
+	clearSpin()
+	fmt.Fprintf(console, "[rerun] %s", fmt.Sprintf(format+"\n", args...))
+}
+
+// goArgs inserts --build-flags, the tags of the program with --matrix,
+// the flags of --repro and -race in a race cycle, after the go subcommand in args.
+func goArgs(args ...string) []string {
+	return goTagArgs(programTags(), args...)
+}
 
+// goTagArgs is goArgs with other tag flags, for the variants of --matrix.
+func goTagArgs(tags []string, args ...string) []string {
+	extra, err := splitWords(*build_flags)
 	if err != nil {
-		return "", err
+		log("--build-flags: %s", err)
 	}
-
-	if pkg.Goroot {
-		return "", err
+	extra = append(extra, tags...)
+	if *repro && reproVerbs[args[0]] {
+		extra = append(extra, reproFlags...)
 	}
-
-	return pkg.Dir, nil
+	if cycleRace && raceVerbs[args[0]] {
+		extra = append(extra, "-race")
+	}
+	return append(append([]string{args[0]}, extra...), args[1:]...)
 }
 
-type scanCallback func(dir string)
-
-func scanChanges(dir string, cb scanCallback) {
-	log("watching: %s", dir)
+func gobuild(buildpath string) (bool, error) {
+	cmd := gocmd(goArgs("build", "-v", buildpath)...)
+	recordCommand("build", cmd)
 
-	last := time.Now()
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
 
-	for {
-		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
-			if *no_git && info.IsDir() && p == filepath.Join(dir, ".git") {
-				return filepath.SkipDir
-			}
-			if *ignore != "" {
-				match, _ := path.Match(*ignore, path.Base(p))
-				if match {
-					return filepath.SkipDir
-				}
-			}
+	if err := cmd.Run(); err != nil {
+		log("build failed")
+		reportFailure("build", buf.String())
+		return false, err
+	}
 
-			if info.ModTime().After(last) {
-				cb(dir)
-				last = time.Now()
-			}
-			return nil
-		})
+	reportSuccess("build")
+	log("build succeeded")
+	return true, nil
+}
 
-		time.Sleep(500 * time.Millisecond)
+func goinstall(buildpath string) (bool, error) {
+	if out, err := installPackage(buildpath); err != nil {
+		log("install failed")
+		reportFailure("install", out)
+		return false, err
 	}
-}
 
-func log(format string, args ...interface{}) {
-	fmt.Printf("[rerun] %s", fmt.Sprintf(format+"\n", args...))
+	reportSuccess("install")
+	log("install succeeded")
+	return true, nil
 }
 
-func gobuild(buildpath string) (bool, error) {
-	cmd := exec.Command(*goexec+"go", "build", "-v", buildpath)
+// install produces the binary that is run. It is replaced for scripts.
+var install = goinstall
+
+func gogenerate(buildpath string) (bool, error) {
+	cmd := gocmd(goArgs("generate", buildpath)...)
+	recordCommand("generate", cmd)
 
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
 	cmd.Stderr = buf
 
 	if err := cmd.Run(); err != nil {
-		log("build failed")
-		fmt.Println(buf.String())
+		log("generate failed")
+		reportFailure("generate", buf.String())
 		return false, err
 	}
 
-	log("build succeeded")
+	reportSuccess("generate")
+	log("generate succeeded")
 	return true, nil
 }
 
-func goinstall(buildpath string) (bool, error) {
-	cmd := exec.Command(*goexec+"go", "get", buildpath)
+func govet(buildpath string) (bool, error) {
+	cmd := gocmd(goArgs("vet", buildpath)...)
+	recordCommand("vet", cmd)
 
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
 	cmd.Stderr = buf
 
 	if err := cmd.Run(); err != nil {
-		log("install failed")
-		fmt.Println(buf.String())
+		log("vet failed")
+		reportFailure("vet", buf.String())
 		return false, err
 	}
 
-	log("install succeeded")
+	reportSuccess("vet")
+	log("vet passed")
+	return true, nil
+}
+
+func runBefore(buildpath string) (bool, error) {
+	if err := runShell(*before); err != nil {
+		log("before hook failed")
+		fmt.Fprintln(console, err)
+		return false, err
+	}
 	return true, nil
 }
 
 func gotest(buildpath string) (bool, error) {
-	cmd := exec.Command(*goexec+"go", "test", "-v", buildpath)
+	defer renderCover()
+	if *test_json {
+		return gotestJSON(buildpath)
+	}
+
+	args := append([]string{"test", "-v"}, coverArgs()...)
+	cmd := testCommand(goArgs(append(args, buildpath)...)...)
+	recordCommand("test", cmd)
 
 	buf := bytes.NewBuffer([]byte{})
 	cmd.Stdout = buf
@@ -118,115 +172,555 @@ func gotest(buildpath string) (bool, error) {
 
 	if err := cmd.Run(); err != nil {
 		log("tests failed")
-		fmt.Println(buf.String())
+		reportFailure("test", buf.String())
 		return false, err
 	}
 
+	reportSuccess("test")
 	log("tests passed")
 	return true, nil
 }
 
-func run(ch chan bool, bin string, args []string) {
+// childCommand returns the command that launches the built binary, either
+// directly or through the --run-cmd template, and wrapped by --wrap. With a
+// remote --executor the binary is copied first and the command runs there.
+func childCommand(bin, dir string, args []string) (cmd *exec.Cmd, err error) {
+	data := newRunData(bin, dir, args)
+	if bin, err = runner.deploy(bin); err != nil {
+		return nil, err
+	}
+	data.Bin = bin
+
+	argv := append([]string{bin}, args...)
+	if *run_cmd != "" {
+		if argv, err = expandCommand(*run_cmd, data.quoted()); err != nil {
+			return nil, err
+		}
+	}
+	if *wrap != "" {
+		prefix, err := wrapCommand(data.Name)
+		if err != nil {
+			return nil, err
+		}
+		argv = append(prefix, argv...)
+	}
+
+	var wd string
+	if *workdir != "" {
+		if wd, err = expandTemplate(*workdir, data); err != nil {
+			return nil, err
+		}
+	}
+	if !runner.local() {
+		argv = runner.command(argv, extraEnv(), wd)
+		return exec.Command(argv[0], argv[1:]...), nil
+	}
+
+	cmd = exec.Command(argv[0], argv[1:]...)
+	if wd != "" {
+		if fi, err := os.Stat(wd); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("invalid working directory %s", wd)
+		}
+		cmd.Dir = wd
+	}
+	return cmd, nil
+}
+
+func run(ch chan bool, bin, dir string, args []string) {
 	go func() {
 		var proc *os.Process
-
-		for relaunch := range ch {
+		var exited chan bool
+		var build int // the cycle that started proc
+
+		for {
+			var relaunch bool
+			select {
+			case relaunch = <-ch:
+			case relaunch = <-resume:
+			}
+			if relaunch && startRejected(bin) {
+				log("the binary failed its checks, not starting it until a cycle passes them")
+				continue
+			}
+			if execPid != 0 {
+				swapExec(bin, relaunch)
+				continue
+			}
+			var ports []string
 			if proc != nil {
-				if err := proc.Signal(os.Interrupt); err != nil {
-					proc.Kill()
+				hold, forced := holdRestart(proc.Pid, relaunch)
+				if hold {
+					continue
+				}
+				ports = openPorts(proc.Pid)
+				// A traced program doesn't answer while the debugger holds it,
+				// nor one that already ended.
+				select {
+				case <-exited:
+				default:
+					if !forced {
+						profileBeforeStop(build)
+					}
 				}
-				proc.Wait()
+				stopRequested(proc.Pid)
+				runner.stop()
+				// A traced program doesn't get signals the debugger holds.
+				if forced {
+					signalProgram(proc, os.Kill)
+				} else if err := signalProgram(proc, os.Interrupt); err != nil {
+					signalProgram(proc, os.Kill)
+				}
+				wakeSuspended(proc.Pid)
+				<-exited
 			}
 
 			if !relaunch {
 				continue
 			}
+			waitCleanup(ports)
 
-			cmd := exec.Command(bin, args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+			cmd, err := childCommand(runBinary(bin), dir, args)
+			if err != nil {
+				log("error: %s", err)
+				proc = nil
+				continue
+			}
+			cmd.Env = environ()
+			if runner.local() {
+				passFDs(cmd)
+			}
+			programGroup(cmd)
+			if cmd.Stdout, cmd.Stderr, err = childStreams(); err != nil {
+				log("error: %s", err)
+				proc = nil
+				continue
+			}
 
+			recordCommand("run", cmd)
 			if err := cmd.Start(); err != nil {
 				log("error: %s", err)
 			}
 
 			proc = cmd.Process
+			if proc != nil {
+				buildMu.Lock()
+				build = buildID
+				buildMu.Unlock()
+				launched(proc.Pid)
+				exited = make(chan bool)
+				go wait(cmd, exited)
+			}
 		}
 	}()
 	return
 }
 
-func refresh(buildpath string, ch chan bool) {
-	if *do_tests {
-		if ok, _ := gotest(buildpath); !ok {
-			ch <- false
+// wait reaps the program and reports how it ended.
+func wait(cmd *exec.Cmd, exited chan bool) {
+	start := time.Now()
+	oom := oomKills()
+	cmd.Wait()
+	endCast()
+	pid := cmd.Process.Pid
+	stopped(pid)
+	e := decodeExit(cmd.ProcessState, pid, oom)
+	reportExit(pid, e, time.Since(start))
+	journalExit(pid, e, time.Since(start))
+	close(exited)
+}
+
+// cycleMu keeps cycles from overlapping.
+var cycleMu sync.Mutex
+
+func refresh(t *target, ch chan bool, changed []string) {
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+
+	stopWarm()
+	showDiff(changed)
+	c := newCycle(changed)
+	defer c.done()
+	setTitle("building…")
+	setSessionState("building", c.id, "")
+	if cycleRace = takeRace(); cycleRace {
+		log("building with -race")
+		defer func() {
+			cycleRace = false
+		}()
+	}
+	deps := reportModChanges()
+	defer func() {
+		lastOK = c.ok && !holdRun
+		resumed = ""
+		scheduleWarm(deps || c.id == 1)
+	}()
+
+	writes := snapshotWrites(watchRoot(t))
+	if takeBranchSwitch() && *on_branch_switch != "" {
+		if ok := c.phase("branch-switch", runBranchHook, t.buildpath); !ok {
+			cycleFailed(ch)
 			return
 		}
 	}
+	hooks, restartOnly, rebuild := dirActions(changed)
+	if len(hooks) > 0 {
+		hook := func(string) (bool, error) {
+			return runDirHooks(hooks, changed)
+		}
+		if ok := c.phase("hook", hook, t.buildpath); !ok {
+			cycleFailed(ch)
+			return
+		}
+	}
+	if !rebuild {
+		recordWrites(watchRoot(t), writes)
+		c.ok = true
+		if restartOnly {
+			log("restarting the program, the changes need no build")
+			ch <- !*no_run && !holdRun
+		}
+		return
+	}
 
-	if *do_build {
-		if ok, _ := gobuild(buildpath); !ok {
-			ch <- false
+	if len(links) > 0 {
+		generate := func(string) (bool, error) {
+			return runChain(changed)
+		}
+		if ok := c.phase("chain", generate, t.buildpath); !ok {
+			cycleFailed(ch)
+			return
+		}
+	}
+
+	if *before != "" {
+		if ok := c.phase("before", runBefore, t.buildpath); !ok {
+			cycleFailed(ch)
 			return
 		}
 	}
 
-	if ok, _ := goinstall(buildpath); !ok {
-		ch <- false
+	if *do_generate {
+		snap := snapshotGenerate(watchRoot(t))
+		ok := c.phase("generate", gogenerate, t.buildpath)
+		snap.restoreUnchanged()
+		if !ok {
+			cycleFailed(ch)
+			return
+		}
+	}
+	recordWrites(watchRoot(t), writes)
+
+	if *cmd_template != "" {
+		command := func(string) (bool, error) {
+			return runCmdTemplate(t, changed)
+		}
+		c.ok = c.phase("cmd", command, t.buildpath)
 		return
 	}
 
-	ch <- true
-	return
+	if *do_vet {
+		if ok := c.phase("vet", govet, t.buildpath); !ok {
+			cycleFailed(ch)
+			return
+		}
+	}
+
+	// A change of tests only can't change the program.
+	testsOnlyChange := lastOK && testsOnly(changed)
+	installed := false
+	for _, name := range phaseOrder {
+		var ok bool
+		switch {
+		case name == "test" && *do_tests:
+			ok = c.phase("test", gotest, t.buildpath)
+		case testsOnlyChange:
+			continue
+		case name == "build" && *do_build:
+			ok = c.phase("build", gobuild, t.buildpath)
+		case name == "install":
+			ok = c.phase("install", install, t.buildpath)
+			installed = true
+		default:
+			continue
+		}
+		if !ok {
+			cycleFailed(ch)
+			return
+		}
+	}
+
+	if testsOnlyChange {
+		log("only test files changed, skipping the build and the restart")
+		c.ok = true
+		return
+	}
+	if !installed {
+		c.ok = true
+		cycleSucceeded()
+		return
+	}
+
+	reportSize(t)
+	if c.hash = hashBuild(t.bin); c.hash != "" {
+		log("build %d (%s)", c.id, c.hash)
+	} else {
+		log("build %d", c.id)
+	}
+
+	if *vuln && depsChanged(changed) {
+		if ok := c.phase("vuln", govulncheck, t.buildpath); !ok {
+			log("the program isn't restarted")
+			binaryRejected()
+			return
+		}
+	}
+
+	if *verify != "" || *max_size != "" {
+		check := func(string) (bool, error) {
+			return verifyBinary(t)
+		}
+		if ok := c.phase("verify", check, t.buildpath); !ok {
+			log("the program isn't restarted")
+			binaryRejected()
+			return
+		}
+	}
+
+	c.ok = true
+	cycleSucceeded()
+	keepBuild(t.bin, c.id)
+	saveState(t, c.hash)
+	if unchangedResume(c.hash) {
+		log("the build didn't change, the last good build keeps running")
+	} else {
+		ch <- !*no_run && !holdRun
+	}
+	if len(matrix) > 1 {
+		c.phase("matrix", buildMatrix, t.buildpath)
+	}
+}
+
+// lastOK is set when the last cycle succeeded, so the running binary is
+// up to date.
+var lastOK bool
+
+// testsOnly reports whether all the changed files are tests, which can't
+// change the behavior of the program. Directories changed along with them
+// don't count.
+func testsOnly(changed []string) bool {
+	tests := 0
+	for _, p := range changed {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(p, "_test.go") {
+			return false
+		}
+		tests++
+	}
+	return tests > 0
+}
+
+// holdRun keeps the initial cycle of --initial-build-only from starting
+// the program.
+var holdRun bool
+
+// initialCycle runs the cycle at startup, as far as the startup flags
+// allow.
+func initialCycle(t *target, ch chan bool) {
+	switch {
+	case *initial_build_only:
+		holdRun = true
+		refresh(t, ch, nil)
+		holdRun = false
+		log("waiting for the first change to start the program")
+	case *no_initial_run:
+		log("waiting for the first change")
+	default:
+		refresh(t, ch, nil)
+	}
 }
 
 func rerun(buildpath string, args []string) (err error) {
-	pkg, err := build.Import(buildpath, "", 0)
+	t, err := resolveMain(buildpath)
 	if err != nil {
 		return
 	}
-
-	if pkg.Name != "main" {
-		err = errors.New(fmt.Sprintf("expected package %q, got %q", "main", pkg.Name))
+	if err = setupTinygo(t); err != nil {
+		return
+	}
+	if err = setupCross(t); err != nil {
+		return
+	}
+	if err = setupGomobile(t); err != nil {
+		return
+	}
+	if err = setupKube(t, args); err != nil {
+		return
+	}
+	if err = setupRemoteBuild(t); err != nil {
+		return
+	}
+	setupPluginBuild(t)
+	if err = setupChain(watchRoot(t)); err != nil {
 		return
 	}
 
-	_, name := path.Split(buildpath)
-	bin := filepath.Join(pkg.BinDir, name)
+	if err = startFixtures(); err != nil {
+		return
+	}
 
 	ch := make(chan bool)
-	go run(ch, bin, args)
+	go run(ch, t.bin, t.dir, args)
 
-	refresh(buildpath, ch)
+	programArgs = args
+	resumeLast(t, ch)
+	initialCycle(t, ch)
 
-	dir, err := buildpathDir(buildpath)
-	if err != nil {
-		return
-	}
+	watchTree(t.dir, t, ch)
+	return
+}
 
+// watchRoot returns the directory watched for t.
+func watchRoot(t *target) string {
 	// watch alternate dir
 	if watch != nil && *watch != "" {
-		dir = *watch
+		return *watch
 	}
+	return t.dir
+}
 
-	scanChanges(dir, func(path string) {
-		log("change detected")
-		refresh(buildpath, ch)
-	})
+// watchTree runs a cycle whenever a file below dir changes. When the
+// package directory disappears, e.g. on a branch switch, the program is
+// stopped until it comes back.
+func watchTree(dir string, t *target, ch chan bool) {
+	pkgDir := dir
+	dir = watchRoot(t)
+
+	current.t, current.ch = t, ch
+	reportWatchCost(dir)
+	warnOutsideDirs(dir)
+	hashed := hashedTree(dir)
+	watchBranch(dir)
+	watchReplaces(dir, t, ch)
+
+	missing := false
+	changed := func(paths []string) {
+		if !exists(pkgDir) {
+			if !missing {
+				log("%s disappeared, stopping the program until it returns", pkgDir)
+				ch <- false
+				missing = true
+			}
+			return
+		}
+		if missing {
+			log("%s is back", pkgDir)
+			missing = false
+		} else {
+			log("change detected")
+		}
+		reloadConfig(false)
+		if branchSwitch() {
+			paths = nil
+		}
+		// An edit of go.mod may add replace directives.
+		if modChanged(paths) {
+			watchReplaces(dir, t, ch)
+		}
+		refresh(t, ch, paths)
+	}
 
-	return
+	for {
+		switch {
+		case *stdin_events:
+			watchStdin(dir, changed)
+		case *on == "commit":
+			if err := watchCommits(dir, changed); err != errGone {
+				log("--on commit: %s", err)
+				return
+			}
+		case hashed:
+			pollHashes(dir, changed)
+		case watcher() != nil:
+			if err := watchPlugin(watcher(), dir, changed); err != errGone {
+				log("%s, watching here instead", err)
+				watchFailed = true
+				continue
+			}
+		case *daemon_socket != "":
+			if err := watchDaemon(dir, changed); err != errGone {
+				log("daemon: %s, watching here instead", err)
+				*daemon_socket = ""
+				continue
+			}
+		case *events:
+			if err := watchEvents(dir, changed); err != errGone {
+				log("event backend unavailable (%s), polling instead", err)
+				*events = false
+				scanChanges(dir, changed)
+			}
+		default:
+			scanChanges(dir, changed)
+		}
+
+		if !missing {
+			log("%s disappeared, stopping the program until it returns", dir)
+			ch <- false
+			missing = true
+		}
+		for !exists(dir) {
+			time.Sleep(time.Second)
+		}
+		changed(nil)
+	}
 }
 
 func main() {
 	flag.Parse()
 
-	if len(flag.Args()) < 1 {
-		fmt.Println("Usage: rerun [--no-git] [--test] [--no-run] [--build] [--race] <import path> [arg]*")
+	switch flag.Arg(0) {
+	case "init", "config", "service", "daemon", "plugins", "flaky", "status", "attach", "start", "stop", "help":
+		command := map[string]func([]string) error{
+			"init":    initConfig,
+			"config":  configCommand,
+			"service": serviceCommand,
+			"daemon":  daemonCommand,
+			"plugins": pluginsCommand,
+			"flaky":   flakyCommand,
+			"status":  statusCommand,
+			"attach":  attachCommand,
+			"start":   startCommand,
+			"stop":    stopCommand,
+			"help":    helpCommand,
+		}[flag.Arg(0)]
+		if err := command(flag.Args()[1:]); err != nil {
+			log("error: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := loadConfig(*config_file); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+	if err := openJSONRPC(); err != nil {
+		log("error: %s", err)
 		os.Exit(1)
 	}
 
-	if *ignore != "" {
-		log("ignoring '%s' dir", *ignore)
+	for _, check := range startupChecks {
+		if err := check(); err != nil {
+			log("error: %s", configSource(err))
+			os.Exit(1)
+		}
+	}
+	setPhases()
+	setMatrix()
+	updateRedaction()
+	if s := currentRules().describe(); s != "" {
+		log("%s", s)
 	}
 
 	if *no_git {
@@ -242,10 +736,94 @@ func main() {
 		*goexec += "/"
 	}
 
-	buildpath := flag.Args()[0]
-	args := flag.Args()[1:]
+	if err := selectToolchain(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+	reportHermetic()
+	if err := checkRepro(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+	reportRepro()
+
+	if err := loadPlugins(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+
+	if err := openPassFiles(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+
+	if err := setupExecutor(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+	if err := checkDebugger(); err != nil {
+		log("error: %s", err)
+		os.Exit(1)
+	}
+
+	var buildpath string
+	var args []string
+	if *bazel_target != "" {
+		buildpath = *bazel_target
+		args = flag.Args()
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+	} else if len(flag.Args()) < 1 && *cmd_template != "" {
+		buildpath = "."
+	} else if len(flag.Args()) < 1 {
+		p, err := findMain()
+		if err != nil {
+			log("error: %s", err)
+			fmt.Println("Usage: rerun [flags] [package | file.go | pattern/...] [--] [program args], see rerun help")
+			os.Exit(1)
+		}
+		buildpath = p
+	} else {
+		buildpath = flag.Args()[0]
+		args = flag.Args()[1:]
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+	}
+
+	claimSession(buildpath, args)
+	openOutputLog()
+
+	if err := setupExecMode(buildpath, args); err != nil {
+		log("error: --exec-mode: %s", err)
+		os.Exit(1)
+	}
+
+	handleSignals()
+	watchConfig()
+	serveProxy()
+	servePprofProxy()
+	serveControl()
+	serveJSONRPC()
+	readKeys()
+	watchUsage()
+	scheduleRebuilds()
+
+	start := rerun
+	switch {
+	case *cmd_template != "":
+		start = rerunCmd
+	case *bazel_target != "":
+		start = rerunBazel
+	case isScript(buildpath):
+		start = rerunScript
+	case isPattern(buildpath):
+		start = rerunAll
+	}
 
-	if err := rerun(buildpath, args); err != nil {
+	if err := start(buildpath, args); err != nil {
 		log("error: %s", err)
+		exit(1)
 	}
 }
@@ -0,0 +1,124 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// moduleMode is set when the program is a package of a module. go get no
+// longer installs there, so go install is used.
+var moduleMode bool
+
+// listedPackage is the part of the output of go list -json rerun uses.
+type listedPackage struct {
+	Dir        string
+	ImportPath string
+	Name       string
+	Target     string
+	Module     *struct {
+		Path  string
+		GoMod string
+	}
+}
+
+// resolveMain finds the main package buildpath. GOPATH packages are found
+// with go/build. Modules, and the packages go/build can't find, are asked
+// for with go list, which knows where go install puts the binary.
+func resolveMain(buildpath string) (*target, error) {
+	pkg, importErr := build.Import(buildpath, "", 0)
+	if importErr == nil && !inModule() {
+		if pkg.Name != "main" {
+			return nil, fmt.Errorf("expected package %q, got %q", "main", pkg.Name)
+		}
+		_, name := path.Split(buildpath)
+		return &target{
+			buildpath: buildpath,
+			bin:       filepath.Join(pkg.BinDir, name),
+			dir:       pkg.Dir,
+		}, nil
+	}
+
+	p, listErr := listPackage(buildpath)
+	if listErr != nil {
+		if importErr == nil {
+			importErr = listErr
+		}
+		return nil, unresolved(buildpath, importErr, listErr)
+	}
+	if p.Name != "main" {
+		return nil, fmt.Errorf("expected package %q, got %q", "main", p.Name)
+	}
+
+	t := &target{buildpath: buildpath, bin: p.Target, dir: p.Dir}
+	if t.bin == "" {
+		gopath := filepath.SplitList(build.Default.GOPATH)
+		t.bin = filepath.Join(gopath[0], "bin", path.Base(p.ImportPath))
+	}
+	if p.Module != nil {
+		moduleMode = true
+		log("%s is in module %s", p.ImportPath, p.Module.Path)
+	}
+	return t, nil
+}
+
+func listPackage(buildpath string) (*listedPackage, error) {
+	cmd := gocmd("list", "-json", buildpath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, err
+	}
+
+	var p listedPackage
+	if err := json.Unmarshal(out, &p); err != nil {
+		return nil, fmt.Errorf("go list: %s", err)
+	}
+	return &p, nil
+}
+
+// inModule reports whether the go command runs in module mode inside a
+// module.
+func inModule() bool {
+	mod := goEnv("GOMOD")
+	return mod != "" && mod != os.DevNull
+}
+
+func goEnv(name string) string {
+	out, err := gocmd("env", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// unresolved explains why buildpath wasn't found, with a hint that
+// depends on the mode of the go command.
+func unresolved(buildpath string, importErr, listErr error) error {
+	var hint string
+	switch mod := goEnv("GOMOD"); {
+	case goEnv("GO111MODULE") == "off":
+		hint = "GO111MODULE=off: the package must be below $GOPATH/src"
+	case mod == "" || mod == os.DevNull:
+		hint = "no go.mod here: run rerun inside the module of the package, or with GO111MODULE=off for a GOPATH package"
+	default:
+		hint = fmt.Sprintf("module mode with %s: pass an import path of the module or a ./relative path", mod)
+	}
+	indent := func(err error) string {
+		return strings.Replace(err.Error(), "\n", "\n    ", -1)
+	}
+	return fmt.Errorf("cannot find package %s\n  go/build: %s\n  go list: %s\n  %s", buildpath, indent(importErr), indent(listErr), hint)
+}
@@ -0,0 +1,32 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var every = flag.Duration("every", 0, "also rebuild and restart the program at this interval, e.g. 30m, whether or not files changed")
+
+// scheduleRebuilds starts a cycle every --every, for programs that bake in
+// data that goes stale, or inputs outside the watched tree. A tick that
+// comes while the last scheduled cycle still runs is dropped.
+func scheduleRebuilds() {
+	if *every <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(*every) {
+			if current.t == nil {
+				continue
+			}
+			log("--every %s: rebuilding", *every)
+			// No file is known to have changed, unlike after a branch
+			// switch.
+			refresh(current.t, current.ch, []string{})
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// isScript reports whether arg names a standalone Go file rather than an
+// import path.
+func isScript(arg string) bool {
+	if !strings.HasSuffix(arg, ".go") {
+		return false
+	}
+	fi, err := os.Stat(arg)
+	return err == nil && !fi.IsDir()
+}
+
+// A script is a single Go file outside of any module. It is built in a
+// temporary module so that it may import third-party packages.
+type script struct {
+	file string
+	dir  string
+	bin  string
+}
+
+func rerunScript(file string, args []string) (err error) {
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "rerun-")
+	if err != nil {
+		return
+	}
+	atExit(func() {
+		os.RemoveAll(dir)
+	})
+
+	name := strings.TrimSuffix(filepath.Base(file), ".go")
+	s := &script{file: file, dir: dir, bin: filepath.Join(dir, name)}
+	if runtime.GOOS == "windows" {
+		s.bin += ".exe"
+	}
+
+	if *do_tests || *do_build {
+		log("--test and --build don't apply to scripts")
+		*do_tests, *do_build = false, false
+	}
+	if tinygoEnabled() {
+		return fmt.Errorf("--tinygo doesn't apply to scripts")
+	}
+	if *use_gomobile != "" {
+		return fmt.Errorf("--gomobile doesn't apply to scripts")
+	}
+	if *kube_resource != "" || *remote_build != "" {
+		return fmt.Errorf("--kube and --remote-build don't apply to scripts")
+	}
+	install = s.build
+
+	if err = startFixtures(); err != nil {
+		return
+	}
+
+	t := &target{buildpath: file, bin: s.bin, dir: filepath.Dir(file)}
+	ch := make(chan bool)
+	go run(ch, t.bin, t.dir, args)
+
+	initialCycle(t, ch)
+
+	shallow = true
+	watchTree(t.dir, t, ch)
+	return
+}
+
+// build copies the script into the temporary module and builds it there.
+func (s *script) build(file string) (bool, error) {
+	src, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(s.dir, filepath.Base(file)), src, 0644)
+	}
+	if err == nil {
+		err = s.init()
+	}
+	if err != nil {
+		log("build failed")
+		log("error: %s", err)
+		return false, err
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	for _, args := range [][]string{{"mod", "tidy"}, goArgs("build", "-o", s.bin, ".")} {
+		cmd := gocmd(args...)
+		cmd.Dir = s.dir
+		cmd.Stdout = buf
+		cmd.Stderr = buf
+		recordCommand("install", cmd)
+
+		if err := cmd.Run(); err != nil {
+			log("build failed")
+			out := strings.Replace(buf.String(), "./"+filepath.Base(file), file, -1)
+			reportFailure("build", out)
+			return false, err
+		}
+	}
+
+	reportSuccess("build")
+	log("build succeeded")
+	return true, nil
+}
+
+// init creates the go.mod of the temporary module.
+func (s *script) init() error {
+	mod := filepath.Join(s.dir, "go.mod")
+	if _, err := os.Stat(mod); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(mod, []byte("module script\n"), 0644)
+}
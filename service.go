@@ -0,0 +1,177 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// serviceEnv are the variables of the current environment the service
+// needs to find and use the same go command.
+var serviceEnv = []string{"PATH", "GOPATH", "GOROOT", "GOBIN", "GOFLAGS", "GO111MODULE", "GOTOOLCHAIN", "GOPROXY"}
+
+// serviceCommand implements "rerun [flags] service install|uninstall
+// [-name NAME] [package [args]]". install writes a user-level systemd unit,
+// or a launchd agent on macOS, that runs rerun with the same flags,
+// package and arguments in the current directory.
+func serviceCommand(args []string) error {
+	if len(args) == 0 || (args[0] != "install" && args[0] != "uninstall") {
+		return errors.New("usage: rerun [flags] service install|uninstall [-name NAME] [package [args]]")
+	}
+	action := args[0]
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", filepath.Base(wd), "name of the service")
+	fs.Parse(args[1:])
+
+	file, err := serviceFile(*name)
+	if err != nil {
+		return err
+	}
+
+	if action == "uninstall" {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		log("removed %s", file)
+		if runtime.GOOS == "darwin" {
+			log("stop it with: launchctl unload %s", file)
+		} else {
+			log("stop it with: systemctl --user disable --now rerun-%s && systemctl --user daemon-reload", *name)
+		}
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The flags given before "service", then the package and arguments.
+	flags := os.Args[1 : len(os.Args)-len(flag.Args())]
+	argv := append(append([]string{exe}, flags...), fs.Args()...)
+
+	var env []string
+	for _, k := range serviceEnv {
+		if v := os.Getenv(k); v != "" {
+			env = append(env, k+"="+v)
+		}
+	}
+
+	var b []byte
+	if runtime.GOOS == "darwin" {
+		b = launchdAgent(*name, wd, argv, env)
+	} else {
+		b = systemdUnit(*name, wd, argv, env)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		return err
+	}
+
+	log("wrote %s", file)
+	if runtime.GOOS == "darwin" {
+		log("start it with: launchctl load -w %s", file)
+	} else {
+		log("start it with: systemctl --user daemon-reload && systemctl --user enable --now rerun-%s", *name)
+		log("keep it running after logout with: loginctl enable-linger $USER")
+		log("follow it with: journalctl --user -f -u rerun-%s", *name)
+	}
+	return nil
+}
+
+func serviceFile(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", "com.github.liut.rerun."+name+".plist"), nil
+	case "windows", "plan9":
+		return "", fmt.Errorf("services are not supported on %s", runtime.GOOS)
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", "rerun-"+name+".service"), nil
+}
+
+func systemdUnit(name, wd string, argv, env []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[Unit]\nDescription=rerun %s\n\n[Service]\n", name)
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote(wd))
+	for _, e := range env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(e))
+	}
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = systemdQuote(a)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	fmt.Fprintf(&b, "Restart=on-failure\nRestartSec=2\n\n[Install]\nWantedBy=default.target\n")
+	return b.Bytes()
+}
+
+// systemdQuote quotes s for a unit file, where % and $ are expanded.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	s = strings.Replace(s, "$", "$$", -1)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func launchdAgent(name, wd string, argv, env []string) []byte {
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	home, _ := os.UserHomeDir()
+	logFile := filepath.Join(home, "Library", "Logs", "rerun-"+name+".log")
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>com.github.liut.rerun.%s</string>\n", esc(name))
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range argv {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", esc(a))
+	}
+	b.WriteString("\t</array>\n\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", esc(kv[0]), esc(kv[1]))
+	}
+	b.WriteString("\t</dict>\n")
+	fmt.Fprintf(&b, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", esc(wd))
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n\t<key>KeepAlive</key>\n\t<true/>\n")
+	fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", esc(logFile))
+	fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", esc(logFile))
+	b.WriteString("</dict>\n</plist>\n")
+	return b.Bytes()
+}
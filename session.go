@@ -0,0 +1,203 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session is the directory of the files of this rerun below stateDir: the
+// first rerun of a tree has stateDir itself, the others that run at the
+// same time, say for an api and a worker, get sessions/KEY, KEY naming the
+// target. The daemon socket is shared.
+var session string
+
+// sessionStale is how long the session.json of a rerun that no longer runs
+// keeps its directory taken. A running rerun touches it three times as
+// often.
+const sessionStale = 30 * time.Second
+
+// sessionInfo is kept in session.json, for the other reruns, for tools
+// that look for the ports this one listens on, and for rerun status.
+type sessionInfo struct {
+	Pid    int               `json:"pid"`
+	Target string            `json:"target"`
+	Args   []string          `json:"args"`
+	Addrs  map[string]string `json:"addrs,omitempty"` // flag -> listen address
+
+	State   string    `json:"state,omitempty"` // building, ok or failed
+	Build   int       `json:"build,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Program int       `json:"program_pid,omitempty"` // pid of the running program
+	Since   time.Time `json:"since"`                 // of the state
+}
+
+var sess struct {
+	sync.Mutex
+	info    sessionInfo
+	claimed bool
+}
+
+// claimSession picks the directory of the files of this rerun, a free one
+// if another rerun runs in the tree, and keeps it until rerun exits.
+func claimSession(buildpath string, args []string) {
+	key := sessionKey(buildpath, args)
+	if sessionLive("") {
+		for n := 1; ; n++ {
+			session = "sessions/" + key
+			if n > 1 {
+				session += fmt.Sprintf("-%d", n)
+			}
+			if !sessionLive(session) {
+				break
+			}
+		}
+		log("another rerun runs in this tree, this one keeps its files in %s", filepath.Join(stateDir, session))
+	}
+
+	sess.Lock()
+	sess.info.Pid = os.Getpid()
+	sess.info.Target = buildpath
+	sess.info.Args = args
+	sess.claimed = true
+	sess.Unlock()
+	writeSession()
+
+	name := statePath("session.json")
+	atExit(func() {
+		// The end of the program mustn't write it again.
+		sess.Lock()
+		sess.claimed = false
+		sess.Unlock()
+		if !sessionLive(session) {
+			os.Remove(name)
+		}
+	})
+	go func() {
+		for range time.Tick(sessionStale / 3) {
+			now := time.Now()
+			if os.Chtimes(name, now, now) != nil {
+				writeSession()
+			}
+		}
+	}()
+}
+
+// sessionKey names the target: the base name of the package, for people,
+// and a hash of it and the program arguments, so two reruns of one package
+// with other arguments don't share files.
+func sessionKey(buildpath string, args []string) string {
+	target := buildpath
+	if abs, err := filepath.Abs(strings.TrimSuffix(buildpath, "/...")); err == nil && (strings.HasPrefix(buildpath, ".") || filepath.IsAbs(buildpath) || exists(buildpath)) {
+		target = abs
+	}
+	sum := sha256.Sum256([]byte(target + "\x00" + strings.Join(args, "\x00")))
+	name := []byte(filepath.Base(target))
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	return fmt.Sprintf("%s-%x", name, sum[:4])
+}
+
+// sessionLive reports whether another rerun has the session directory dir.
+// In --exec-mode the sidecar takes over the session of the rerun that
+// became the program.
+func sessionLive(dir string) bool {
+	info, ok := readSession(dir)
+	return ok && info.Pid != os.Getpid() && fmt.Sprint(info.Pid) != os.Getenv("RERUN_EXEC_PID")
+}
+
+// readSession returns the session.json of the session directory dir, if
+// a rerun still keeps it.
+func readSession(dir string) (info sessionInfo, ok bool) {
+	name := filepath.Join(stateDir, dir, "session.json")
+	fi, err := os.Stat(name)
+	if err != nil || time.Since(fi.ModTime()) > sessionStale {
+		return info, false
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return info, false
+	}
+	return info, json.Unmarshal(b, &info) == nil
+}
+
+// setSessionState records the state of the cycle build in session.json.
+func setSessionState(state string, build int, hash string) {
+	sess.Lock()
+	sess.info.State, sess.info.Build, sess.info.Hash = state, build, hash
+	sess.info.Since = time.Now()
+	sess.Unlock()
+	writeSession()
+}
+
+// setSessionProgram records the pid of the program, 0 once it ended.
+func setSessionProgram(pid int) {
+	sess.Lock()
+	sess.info.Program = pid
+	sess.Unlock()
+	writeSession()
+}
+
+func writeSession() {
+	sess.Lock()
+	defer sess.Unlock()
+	if !sess.claimed {
+		return
+	}
+	b, err := json.MarshalIndent(sess.info, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(statePath("session.json")), 0755)
+	}
+	if err == nil {
+		tmp := statePath("session.json.tmp")
+		if err = ioutil.WriteFile(tmp, append(b, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, statePath("session.json"))
+		}
+	}
+	if err != nil {
+		log("session: %s", err)
+	}
+}
+
+// listenTCP listens on *addr, the value of the flag name, or on a free
+// port of the same host when *addr is taken, as by the same endpoint of
+// another rerun, and sets *addr to it. The address is recorded in
+// session.json.
+func listenTCP(name string, addr *string) (net.Listener, error) {
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		host, _, serr := net.SplitHostPort(*addr)
+		if serr != nil {
+			return nil, err
+		}
+		var ferr error
+		if l, ferr = net.Listen("tcp", net.JoinHostPort(host, "0")); ferr != nil {
+			return nil, err
+		}
+		log("--%s: %s, listening on %s instead", name, err, l.Addr())
+		*addr = l.Addr().String()
+	}
+
+	sess.Lock()
+	if sess.info.Addrs == nil {
+		sess.info.Addrs = map[string]string{}
+	}
+	sess.info.Addrs[name] = l.Addr().String()
+	sess.Unlock()
+	writeSession()
+	return l, nil
+}
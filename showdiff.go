@@ -0,0 +1,216 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var show_diff = flag.Int("show-diff", 0, "print a unified diff of the changed files, of at most this many lines, at the start of every cycle")
+
+// snapshots hold the contents of the changed files as of the last cycle
+// that diffed them. A file without one is diffed against git HEAD.
+var snapshots = map[string]string{}
+
+// maxSnapshot is the size of the largest file that is diffed.
+const maxSnapshot = 1 << 20
+
+// showDiff prints the diff of the changed files against their snapshots,
+// capped at --show-diff lines, and takes new snapshots.
+func showDiff(changed []string) {
+	if *show_diff <= 0 {
+		return
+	}
+	budget := *show_diff
+	hidden := 0
+	for _, p := range changed {
+		fi, err := os.Stat(p)
+		if err == nil && (fi.IsDir() || fi.Size() > maxSnapshot) {
+			continue
+		}
+		b, _ := ioutil.ReadFile(p)
+		now := string(b)
+
+		old, ok := snapshots[p]
+		against := "last cycle"
+		if !ok {
+			if old, ok = headVersion(p); !ok {
+				snapshots[p] = now
+				log("no earlier version of %s, its diffs start with the next change", relPath(p))
+				continue
+			}
+			against = "HEAD"
+		}
+		snapshots[p] = now
+		if old == now {
+			continue
+		}
+
+		name := relPath(p)
+		var lines []string
+		if strings.IndexByte(old, 0) >= 0 || strings.IndexByte(now, 0) >= 0 {
+			lines = []string{"binary file " + name + " changed"}
+		} else {
+			lines = append([]string{"--- " + name + " (" + against + ")", "+++ " + name}, unifiedDiff(old, now)...)
+		}
+		for _, line := range lines {
+			if budget == 0 {
+				hidden++
+				continue
+			}
+			fmt.Fprintln(console, line)
+			budget--
+		}
+	}
+	if hidden > 0 {
+		log("%d more diff lines, raise --show-diff to see them", hidden)
+	}
+}
+
+// headVersion returns the contents of p in git HEAD, if p is in a
+// repository and in HEAD.
+func headVersion(p string) (string, bool) {
+	cmd := exec.Command("git", "show", "HEAD:./"+filepath.Base(p))
+	cmd.Dir = filepath.Dir(p)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+// unifiedDiff returns the hunks of the line diff from a to b, with three
+// lines of context.
+func unifiedDiff(a, b string) []string {
+	x, y := splitLines(a), splitLines(b)
+
+	// Common ends needn't go through the table.
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	mx, my := x[pre:len(x)-suf], y[pre:len(y)-suf]
+	if len(mx)*len(my) > 4e6 {
+		return []string{fmt.Sprintf("@@ %d lines changed, too many to diff @@", len(mx)+len(my))}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of mx[i:]
+	// and my[j:].
+	lcs := make([][]int, len(mx)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(my)+1)
+	}
+	for i := len(mx) - 1; i >= 0; i-- {
+		for j := len(my) - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op     byte
+		line   string
+		ai, bi int // lines of a and b before this one
+	}
+	var edits []edit
+	for i := 0; i < pre; i++ {
+		edits = append(edits, edit{' ', x[i], i, i})
+	}
+	i, j := 0, 0
+	for i < len(mx) || j < len(my) {
+		switch {
+		case i < len(mx) && j < len(my) && mx[i] == my[j]:
+			edits = append(edits, edit{' ', mx[i], pre + i, pre + j})
+			i++
+			j++
+		case i < len(mx) && (j == len(my) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', mx[i], pre + i, pre + j})
+			i++
+		default:
+			edits = append(edits, edit{'+', my[j], pre + i, pre + j})
+			j++
+		}
+	}
+	for k := 0; k < suf; k++ {
+		edits = append(edits, edit{' ', x[len(x)-suf+k], len(x) - suf + k, len(y) - suf + k})
+	}
+
+	const context = 3
+	var out []string
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// A hunk runs from context lines before this change to context
+		// lines after the last change that is close enough.
+		start, end := k-context, k
+		if start < 0 {
+			start = 0
+		}
+		for end < len(edits) {
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			for next < len(edits) && edits[next].op != ' ' {
+				next++
+			}
+			end = next
+		}
+		stop := end + context
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+
+		na, nb := 0, 0
+		var body []string
+		for _, e := range edits[start:stop] {
+			if e.op != '+' {
+				na++
+			}
+			if e.op != '-' {
+				nb++
+			}
+			body = append(body, string(e.op)+e.line)
+		}
+		sa, sb := edits[start].ai+1, edits[start].bi+1
+		if na == 0 {
+			sa--
+		}
+		if nb == 0 {
+			sb--
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", sa, na, sb, nb))
+		out = append(out, body...)
+		k = stop
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
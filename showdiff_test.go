@@ -0,0 +1,68 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines 1 to n, with the lines of names replaced.
+func numbered(n int, names map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if s, ok := names[i]; ok {
+			b.WriteString(s + "\n")
+		} else {
+			b.WriteString(strconv.Itoa(i) + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"same", "a\nb\n", "a\nb\n", nil},
+		{"empty", "", "", nil},
+		{"one line", "a\nb\nc\n", "a\nB\nc\n", []string{"@@ -1,3 +1,3 @@", " a", "-b", "+B", " c"}},
+		{"created", "", "x\ny\n", []string{"@@ -0,0 +1,2 @@", "+x", "+y"}},
+		{"emptied", "x\ny\n", "", []string{"@@ -1,2 +0,0 @@", "-x", "-y"}},
+		{"no final newline", "a\nb", "a\nb\nc\n", []string{"@@ -1,2 +1,3 @@", " a", " b", "+c"}},
+		{
+			"inserted", numbered(10, nil), numbered(10, map[int]string{5: "5\nnew"}),
+			[]string{"@@ -3,6 +3,7 @@", " 3", " 4", " 5", "+new", " 6", " 7", " 8"},
+		},
+		{
+			"two hunks", numbered(20, nil), numbered(20, map[int]string{2: "two", 18: "eighteen"}),
+			[]string{
+				"@@ -1,5 +1,5 @@", " 1", "-2", "+two", " 3", " 4", " 5",
+				"@@ -15,6 +15,6 @@", " 15", " 16", " 17", "-18", "+eighteen", " 19", " 20",
+			},
+		},
+		{
+			"merged hunks", numbered(10, nil), numbered(10, map[int]string{2: "two", 8: "eight"}),
+			[]string{"@@ -1,10 +1,10 @@", " 1", "-2", "+two", " 3", " 4", " 5", " 6", " 7", "-8", "+eight", " 9", " 10"},
+		},
+	}
+	for _, tt := range tests {
+		if got := unifiedDiff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unifiedDiff = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	a, b := numbered(3000, nil), strings.Replace(numbered(3000, nil), "\n", "x\n", -1)
+	want := []string{"@@ 6000 lines changed, too many to diff @@"}
+	if got := unifiedDiff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("unifiedDiff of 3000 changed lines = %q, want %q", got, want)
+	}
+}
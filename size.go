@@ -0,0 +1,78 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	lastSize int64
+	lastDeps int
+)
+
+// reportSize prints the size of the binary and how much it changed since
+// the previous build, and warns when it grew by more than --size-alert.
+func reportSize(t *target) {
+	fi, err := os.Stat(t.bin)
+	if err != nil {
+		return
+	}
+	size := fi.Size()
+
+	if lastSize == 0 {
+		log("binary size %s", formatSize(size))
+	} else {
+		delta := size - lastSize
+		pct := 100 * float64(delta) / float64(lastSize)
+		log("binary size %s (%+.1f%%, %s)", formatSize(size), pct, formatDelta(delta))
+		if pct > *size_alert {
+			log("warning: binary grew by %.1f%%", pct)
+		}
+	}
+	lastSize = size
+
+	if *deps && !isScript(t.buildpath) {
+		reportDeps(t.buildpath)
+	}
+}
+
+func reportDeps(buildpath string) {
+	out, err := gocmd("list", "-deps", buildpath).Output()
+	if err != nil {
+		log("go list -deps: %s", err)
+		return
+	}
+	n := len(strings.Fields(string(out)))
+
+	if lastDeps == 0 {
+		log("%d dependencies", n)
+	} else {
+		log("%d dependencies (%+d)", n, n-lastDeps)
+	}
+	lastDeps = n
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDelta(n int64) string {
+	if n < 0 {
+		return "-" + formatSize(-n)
+	}
+	return "+" + formatSize(n)
+}
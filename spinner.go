@@ -0,0 +1,88 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A phase that runs longer than spinAfter shows its elapsed time: a spinner
+// on a terminal, otherwise a line every spinEvery.
+const (
+	spinAfter = 2 * time.Second
+	spinEvery = 10 * time.Second
+)
+
+var spinFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+var (
+	spinMu   sync.Mutex
+	spinLine bool // the spinner is on the current line of the terminal
+)
+
+// clearSpin erases the spinner before other output.
+func clearSpin() {
+	spinMu.Lock()
+	if spinLine {
+		fmt.Print("\r\033[K")
+		spinLine = false
+	}
+	spinMu.Unlock()
+}
+
+// spin shows that phase is still running until the returned function is
+// called, which prints the duration of a long phase.
+func spin(phase string) func() {
+	if phase == "test" && *test_json && isTerminal(os.Stdout) {
+		return func() {} // the test phase shows its own progress
+	}
+	start := time.Now()
+	done := make(chan bool)
+	finished := make(chan bool)
+	live := isTerminal(os.Stdout)
+
+	go func() {
+		defer close(finished)
+		select {
+		case <-done:
+			return
+		case <-time.After(spinAfter):
+		}
+		tick := spinEvery
+		if live {
+			tick = 100 * time.Millisecond
+		}
+		t := time.NewTicker(tick)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			elapsed := time.Since(start).Truncate(100 * time.Millisecond)
+			if live {
+				spinMu.Lock()
+				fmt.Printf("\r[rerun] %s %s %s\033[K", phase, spinFrames[i%len(spinFrames)], elapsed)
+				spinLine = true
+				spinMu.Unlock()
+			} else if i > 0 {
+				log("%s still running after %s", phase, elapsed.Truncate(time.Second))
+			}
+			select {
+			case <-done:
+				clearSpin()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		if d := time.Since(start); d >= spinAfter {
+			log("%s took %s", phase, d.Round(100*time.Millisecond))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// detached is set in a rerun that rerun start -d runs in the background:
+// once its session has an output log, it prints only there.
+var detached = os.Getenv("RERUN_DETACHED") != ""
+
+// attachable is set in a rerun that rerun start runs, detached or not: its
+// session keeps an output log for rerun attach.
+var attachable = detached || os.Getenv("RERUN_ATTACHABLE") != ""
+
+func init() {
+	os.Unsetenv("RERUN_DETACHED")
+	os.Unsetenv("RERUN_ATTACHABLE")
+}
+
+// startCommand runs rerun with the flags before "start" and the arguments
+// after it, in the background with -d. The flags of rerun may follow -d.
+func startCommand(args []string) error {
+	detach := false
+	for len(args) > 0 && (args[0] == "-d" || args[0] == "--d") {
+		detach = true
+		args = args[1:]
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	argv := append(append([]string(nil), os.Args[1:len(os.Args)-len(flag.Args())]...), args...)
+	cmd := exec.Command(exe, argv...)
+	cmd.Env = append(os.Environ(), "RERUN_ATTACHABLE=1")
+	if !detach {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if cmd.ProcessState != nil {
+				os.Exit(cmd.ProcessState.ExitCode())
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := detachProcess(cmd); err != nil {
+		return err
+	}
+	// What the rerun prints before its session has an output log, and
+	// panics, go to start.log.
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	logName := filepath.Join(stateDir, "start.log")
+	f, err := os.Create(logName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd.Stdout, cmd.Stderr = f, f
+	cmd.Env = append(cmd.Env, "RERUN_DETACHED=1")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	timeout := time.After(30 * time.Second)
+	for {
+		select {
+		case err := <-exited:
+			b, _ := ioutil.ReadFile(logName)
+			os.Stdout.Write(lastLines(b, attachLines))
+			return fmt.Errorf("rerun exited: %v", err)
+		case <-timeout:
+			return fmt.Errorf("rerun pid %d claimed no session in 30s, see %s", cmd.Process.Pid, logName)
+		case <-time.After(100 * time.Millisecond):
+		}
+		for _, s := range liveSessions() {
+			if s.info.Pid == cmd.Process.Pid {
+				log("rerun of %s runs in the background as pid %d, session %s: rerun attach follows it, rerun stop ends it", s.info.Target, s.info.Pid, s.name())
+				return nil
+			}
+		}
+	}
+}
+
+// stopCommand ends a rerun of the tree, and its program, and waits for it.
+func stopCommand(args []string) error {
+	s, err := pickSession(args, "usage: rerun stop [session]")
+	if err != nil {
+		return err
+	}
+	log("stopping the rerun of %s (session %s, pid %d)", s.info.Target, s.name(), s.info.Pid)
+	if err := terminateRerun(s.info.Pid); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		if info, ok := readSession(s.dir); !ok || info.Pid != s.info.Pid {
+			return nil
+		}
+	}
+	return errors.New("the rerun didn't stop in 15s")
+}
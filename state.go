@@ -0,0 +1,96 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var resume_last = flag.Bool("resume", true, "start the last good build, which state.json of the session, in "+stateDir+" or below it, records, at once while the first build runs")
+
+// savedState is kept in state.json of the session after every successful
+// cycle.
+type savedState struct {
+	Buildpath string    `json:"buildpath"`
+	Bin       string    `json:"bin"`
+	Hash      string    `json:"hash"`
+	Args      []string  `json:"args"`
+	Time      time.Time `json:"time"`
+}
+
+var (
+	// programArgs are the arguments the program is started with.
+	programArgs []string
+
+	// resumed is the hash of the last good build started at startup,
+	// until the first cycle is done.
+	resumed string
+)
+
+// saveState records the successful build of t.
+func saveState(t *target, hash string) {
+	if hash == "" {
+		return
+	}
+	b, err := json.MarshalIndent(savedState{
+		Buildpath: t.buildpath,
+		Bin:       t.bin,
+		Hash:      hash,
+		Args:      programArgs,
+		Time:      time.Now(),
+	}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(statePath("state.json")), 0755)
+	}
+	if err == nil {
+		tmp := statePath("state.json.tmp")
+		if err = ioutil.WriteFile(tmp, append(b, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, statePath("state.json"))
+		}
+	}
+	if err != nil {
+		log("state: %s", err)
+	}
+}
+
+// resumeLast starts the last good build of t, if the binary is still the
+// one recorded and the program gets the same arguments.
+func resumeLast(t *target, ch chan bool) {
+	if !*resume_last || *no_run || *no_initial_run || *initial_build_only {
+		return
+	}
+	b, err := ioutil.ReadFile(statePath("state.json"))
+	if err != nil {
+		return
+	}
+	var s savedState
+	if err := json.Unmarshal(b, &s); err != nil {
+		log("state: %s", err)
+		return
+	}
+	if s.Buildpath != t.buildpath || s.Bin != t.bin || shellJoin(s.Args) != shellJoin(programArgs) {
+		return
+	}
+	if hashBuild(t.bin) != s.Hash {
+		return
+	}
+
+	resumed = s.Hash
+	log("starting the last good build (%s) of %s while building", s.Hash, s.Time.Format("Jan 2 15:04"))
+	ch <- true
+}
+
+// unchangedResume reports whether hash is the build started by resumeLast,
+// which then keeps running. It only holds for the first cycle.
+func unchangedResume(hash string) bool {
+	last := resumed
+	resumed = ""
+	return last != "" && last == hash
+}
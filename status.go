@@ -0,0 +1,206 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attachLines is how many lines of the output rerun attach shows first.
+const attachLines = 50
+
+// A liveSession is a rerun that runs in this tree.
+type liveSession struct {
+	dir  string // below stateDir, "" for the first rerun
+	info sessionInfo
+}
+
+// liveSessions lists the reruns of this tree, the first one first.
+func liveSessions() []liveSession {
+	var list []liveSession
+	if info, ok := readSession(""); ok {
+		list = append(list, liveSession{"", info})
+	}
+	names, _ := filepath.Glob(filepath.Join(stateDir, "sessions", "*", "session.json"))
+	sort.Strings(names)
+	for _, name := range names {
+		dir := "sessions/" + filepath.Base(filepath.Dir(name))
+		if info, ok := readSession(dir); ok {
+			list = append(list, liveSession{dir, info})
+		}
+	}
+	return list
+}
+
+// name is how rerun status and rerun attach call the session: "." for the
+// first rerun of the tree, KEY for sessions/KEY.
+func (s liveSession) name() string {
+	if s.dir == "" {
+		return "."
+	}
+	return strings.TrimPrefix(s.dir, "sessions/")
+}
+
+// state is building, ok, failed, stale when a cycle failed and the last
+// good build still runs, or starting before the first cycle.
+func (s liveSession) state() string {
+	switch {
+	case s.info.State == "":
+		return "starting"
+	case s.info.State == "failed" && s.info.Program != 0:
+		return "stale"
+	}
+	return s.info.State
+}
+
+// statusCommand shows the reruns of the tree. It exits with 1 when none
+// runs, so a shell prompt can leave the status out.
+func statusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	porcelain := fs.Bool("porcelain", false, "print one line per rerun: session, state, build, pid of the program or -, and target")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errors.New("usage: rerun status [-porcelain]")
+	}
+
+	list := liveSessions()
+	if len(list) == 0 {
+		if !*porcelain {
+			fmt.Println("no rerun runs in this tree")
+		}
+		os.Exit(1)
+	}
+	for _, s := range list {
+		if *porcelain {
+			pid := "-"
+			if s.info.Program != 0 {
+				pid = strconv.Itoa(s.info.Program)
+			}
+			fmt.Printf("%s %s %d %s %s\n", s.name(), s.state(), s.info.Build, pid, s.info.Target)
+			continue
+		}
+		line := s.info.Target + ": " + s.state()
+		if s.info.Build > 0 {
+			line += fmt.Sprintf(", build %d", s.info.Build)
+			if s.info.Hash != "" {
+				line += " (" + s.info.Hash + ")"
+			}
+		}
+		if !s.info.Since.IsZero() {
+			line += " since " + s.info.Since.Format("15:04:05")
+		}
+		if s.info.Program != 0 {
+			line += fmt.Sprintf(", program pid %d", s.info.Program)
+		} else {
+			line += ", the program doesn't run"
+		}
+		fmt.Printf("%s [session %s, rerun pid %d]\n", line, s.name(), s.info.Pid)
+	}
+	return nil
+}
+
+// attachCommand shows the last lines of the output of a rerun of the tree
+// and follows it until the rerun ends.
+func attachCommand(args []string) error {
+	s, err := pickSession(args, "usage: rerun attach [session]")
+	if err != nil {
+		return err
+	}
+	log("attached to %s (session %s), ^C to leave it running", s.info.Target, s.name())
+	return followOutput(s.dir, s.info.Pid)
+}
+
+// pickSession returns the rerun of the tree args name, by session or
+// target, or the only one.
+func pickSession(args []string, usage string) (*liveSession, error) {
+	list := liveSessions()
+	switch {
+	case len(args) > 1:
+		return nil, errors.New(usage)
+	case len(args) == 1:
+		for i := range list {
+			if list[i].name() == args[0] || list[i].info.Target == args[0] {
+				return &list[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no rerun of %s runs in this tree, see rerun status", args[0])
+	case len(list) == 0:
+		return nil, errors.New("no rerun runs in this tree")
+	case len(list) == 1:
+		return &list[0], nil
+	}
+	var names []string
+	for _, l := range list {
+		names = append(names, l.name())
+	}
+	return nil, fmt.Errorf("%d reruns run in this tree, name one of: %s", len(list), strings.Join(names, " "))
+}
+
+// followOutput prints the end of the output log of the session dir, then
+// what the rerun pid adds to it, across rotations, until it ends.
+func followOutput(dir string, pid int) error {
+	name := filepath.Join(stateDir, dir, "output.log")
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("%s, the rerun doesn't keep an output log: only those of rerun start do", err)
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(lastLines(b, attachLines))
+
+	for tick := 0; ; tick++ {
+		time.Sleep(200 * time.Millisecond)
+		io.Copy(os.Stdout, f)
+		if fi, err := os.Stat(name); err == nil {
+			if cur, err := f.Stat(); err == nil && !os.SameFile(fi, cur) {
+				// Rotated: what is left of the old file was copied above.
+				if nf, err := os.Open(name); err == nil {
+					f.Close()
+					f = nf
+				}
+			}
+		}
+		if tick%5 == 0 {
+			if info, ok := readSession(dir); !ok || info.Pid != pid {
+				io.Copy(os.Stdout, f)
+				log("the rerun ended")
+				return nil
+			}
+		}
+	}
+}
+
+// lastLines returns the end of b with its last n lines.
+func lastLines(b []byte, n int) []byte {
+	end := len(b)
+	if end > 0 && b[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		j := bytes.LastIndexByte(b[:end], '\n')
+		if j < 0 {
+			return b
+		}
+		end = j
+	}
+	return b[end+1:]
+}
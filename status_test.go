@@ -0,0 +1,30 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"", 3, ""},
+		{"\n", 1, "\n"},
+		{"one\n", 3, "one\n"},
+		{"a\nb\nc\n", 1, "c\n"},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc\n", 3, "a\nb\nc\n"},
+		{"a\nb\nc\n", 4, "a\nb\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\n\n\nb\n", 2, "\nb\n"},
+	}
+	for _, tt := range tests {
+		if got := string(lastLines([]byte(tt.in), tt.n)); got != tt.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
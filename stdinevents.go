@@ -0,0 +1,45 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+)
+
+var stdin_events = flag.Bool("stdin-events", false, "take the changed paths from stdin, one per line and relative to the current directory unless absolute, instead of watching, e.g. from watchman-wait or a sync agent")
+
+func checkStdinEvents() error {
+	if !*stdin_events {
+		return nil
+	}
+	switch {
+	case *serve_jsonrpc == "stdio":
+		return errors.New("--stdin-events and --serve-jsonrpc stdio both need stdin")
+	case *on == "commit":
+		return errors.New("--stdin-events doesn't go with --on commit")
+	case *daemon_socket != "":
+		return errors.New("--stdin-events doesn't go with --daemon")
+	}
+	return nil
+}
+
+// watchStdin runs cycles for the changes below root read from stdin. When
+// stdin closes, the source of the changes is gone and so is rerun.
+func watchStdin(root string, cb scanCallback) {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = root
+	}
+	log("watching: %s (changes from stdin)", root)
+	changes := make(chan string, 256)
+	go readLines(os.Stdin, changes)
+	watchLines(root, wd, changes, cb, true)
+
+	log("stdin closed, shutting down")
+	interruptProgram()
+	exit(0)
+}
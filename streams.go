@@ -0,0 +1,101 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var (
+	streams     = flag.String("streams", "split", "output of the program: split, merge (one stream with 1| and 2| markers), color (stderr in red) or stderr (stdout silenced)")
+	stderr_file = flag.String("stderr-file", "", "append the stderr of the program to this file instead of the terminal")
+)
+
+var (
+	stderrOnce sync.Once
+	stderrLog  *os.File
+	stderrErr  error // of opening stderrLog, for every start
+)
+
+// childStreams returns where the stdout and stderr of the program go.
+func childStreams() (stdout, stderr io.Writer, err error) {
+	stdout, stderr = os.Stdout, os.Stderr
+
+	switch *streams {
+	case "split":
+	case "merge":
+		stdout = &lineWriter{out: os.Stdout, mark: "1| ", bol: true}
+		stderr = &lineWriter{out: os.Stdout, mark: "2| ", bol: true}
+	case "color":
+		if isTerminal(os.Stderr) {
+			stderr = &lineWriter{out: os.Stderr, color: "\033[31m", bol: true}
+		}
+	case "stderr":
+		stdout = ioutil.Discard
+	default:
+		return nil, nil, fmt.Errorf("invalid --streams %q", *streams)
+	}
+	if detached && outputLog != nil {
+		stdout, stderr = ioutil.Discard, ioutil.Discard
+	}
+
+	if *stderr_file != "" {
+		stderrOnce.Do(func() {
+			stderrLog, stderrErr = os.OpenFile(*stderr_file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		})
+		if stderrErr != nil {
+			return nil, nil, fmt.Errorf("--stderr-file: %v", stderrErr)
+		}
+		stderr = stderrLog
+	}
+	cast := startCast()
+	return redactOutput(childOutput(cast.tee(stdout))), redactOutput(childOutput(cast.tee(stderr))), nil
+}
+
+// A lineWriter marks the start of every line and colors the text it
+// passes to out.
+type lineWriter struct {
+	out   io.Writer
+	mark  string
+	color string
+	bol   bool // at the beginning of a line
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+
+		if w.bol {
+			buf.WriteString(w.mark)
+		}
+		text := bytes.TrimSuffix(line, []byte("\n"))
+		if w.color != "" {
+			buf.WriteString(w.color)
+			buf.Write(text)
+			buf.WriteString("\033[0m")
+		} else {
+			buf.Write(text)
+		}
+		w.bol = len(text) < len(line)
+		if w.bol {
+			buf.WriteByte('\n')
+		}
+	}
+	if _, err := w.out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
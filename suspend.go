@@ -0,0 +1,102 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// suspended holds the program while it is stopped with SIGSTOP. It keeps
+// its memory, unlike a restart.
+var suspended struct {
+	sync.Mutex
+	pid   int // 0 when the program isn't suspended
+	since time.Time
+	once  sync.Once
+}
+
+// suspendProgram stops the program until continueProgram. Only the
+// program is stopped, not the processes it starts.
+func suspendProgram() error {
+	launchMu.Lock()
+	pid := childPid
+	launchMu.Unlock()
+	if pid == 0 {
+		return errors.New("the program doesn't run")
+	}
+
+	suspended.Lock()
+	defer suspended.Unlock()
+	if suspended.pid == pid {
+		return fmt.Errorf("pid %d is already suspended", pid)
+	}
+	if err := stopProcess(pid); err != nil {
+		return fmt.Errorf("suspending pid %d: %s", pid, err)
+	}
+	suspended.pid, suspended.since = pid, time.Now()
+	suspended.once.Do(func() {
+		// Stopped, the program would ignore the signal that ends it.
+		atExit(func() {
+			wakeSuspended(0)
+		})
+	})
+	setTitle("SUSPENDED (pid %d)", pid)
+	log("suspended pid %d, s or continue lets it go on", pid)
+	return nil
+}
+
+// continueProgram lets the suspended program go on.
+func continueProgram() error {
+	suspended.Lock()
+	defer suspended.Unlock()
+	pid := suspended.pid
+	if pid == 0 {
+		return errors.New("the program isn't suspended")
+	}
+	if err := continueProcess(pid); err != nil {
+		return fmt.Errorf("continuing pid %d: %s", pid, err)
+	}
+	suspended.pid = 0
+	titleRunning(pid)
+	log("pid %d goes on after %s suspended", pid, time.Since(suspended.since).Round(time.Millisecond))
+	return nil
+}
+
+// toggleSuspend suspends the program or lets it go on.
+func toggleSuspend() error {
+	if isSuspended() {
+		return continueProgram()
+	}
+	return suspendProgram()
+}
+
+func isSuspended() bool {
+	suspended.Lock()
+	defer suspended.Unlock()
+	return suspended.pid != 0
+}
+
+// wakeSuspended lets pid go on if it is suspended, or the suspended
+// program for 0, after it was signaled to end.
+func wakeSuspended(pid int) {
+	suspended.Lock()
+	defer suspended.Unlock()
+	if suspended.pid != 0 && (pid == 0 || pid == suspended.pid) {
+		continueProcess(suspended.pid)
+		suspended.pid = 0
+	}
+}
+
+// forgetSuspended drops pid, which ended, even stopped.
+func forgetSuspended(pid int) {
+	suspended.Lock()
+	defer suspended.Unlock()
+	if suspended.pid == pid {
+		suspended.pid = 0
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package main
+
+import "errors"
+
+var errNoSuspend = errors.New("suspending the program needs a Unix system or Plan 9")
+
+func stopProcess(pid int) error {
+	return errNoSuspend
+}
+
+func continueProcess(pid int) error {
+	return errNoSuspend
+}
@@ -0,0 +1,24 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+)
+
+// stopProcess stops pid through its control file: Plan 9 has no signals.
+func stopProcess(pid int) error {
+	return writeCtl(pid, "stop")
+}
+
+// continueProcess starts pid stopped with stopProcess again.
+func continueProcess(pid int) error {
+	return writeCtl(pid, "start")
+}
+
+func writeCtl(pid int, msg string) error {
+	return ioutil.WriteFile("/proc/"+strconv.Itoa(pid)+"/ctl", []byte(msg), 0)
+}
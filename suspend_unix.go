@@ -0,0 +1,17 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package main
+
+import "syscall"
+
+func stopProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGSTOP)
+}
+
+func continueProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGCONT)
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+var (
+	test_cache  = flag.String("test-cache", "", "GOCACHE of the test phase, apart from the build cache; auto for "+stateDir+"/test-cache")
+	test_tmpdir = flag.String("test-tmpdir", "", "GOTMPDIR of the test phase; auto for "+stateDir+"/test-tmp")
+)
+
+// testEnv returns the environment of go test. With --test-cache and
+// --test-tmpdir the tests get directories of their own, so they don't
+// evict the build's cache entries and either can be removed alone.
+func testEnv() []string {
+	env := append(toolEnviron(), toolEnv...)
+	for _, d := range []struct {
+		name, flag, dir, auto string
+	}{
+		{"GOCACHE", "--test-cache", *test_cache, "test-cache"},
+		{"GOTMPDIR", "--test-tmpdir", *test_tmpdir, "test-tmp"},
+	} {
+		if d.dir == "" {
+			continue
+		}
+		dir := d.dir
+		if dir == "auto" {
+			dir = statePath(d.auto)
+		}
+		dir, err := filepath.Abs(dir)
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err != nil {
+			log("%s: %s", d.flag, err)
+			continue
+		}
+		env = append(env, d.name+"="+dir)
+	}
+	return env
+}
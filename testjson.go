@@ -0,0 +1,179 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+var test_json = flag.Bool("test-json", false, "show a compact live view of the tests and only the output of failing ones")
+
+// testEvent is an event of the go test -json stream, see go doc test2json.
+type testEvent struct {
+	Time       time.Time
+	Action     string
+	Package    string
+	ImportPath string // of the build-output and build-fail events
+	Test       string
+	Elapsed    float64
+	Output     string
+}
+
+// testResult is the outcome of a single test.
+type testResult struct {
+	Package string `json:"package"`
+	Test    string `json:"test"`
+	Action  string `json:"action"`
+}
+
+// testResults holds the outcomes of the last test phase.
+var testResults []testResult
+
+func gotestJSON(buildpath string) (bool, error) {
+	args := append([]string{"test", "-json"}, coverArgs()...)
+	cmd := testCommand(goArgs(append(args, buildpath)...)...)
+	recordCommand("test", cmd)
+
+	stderr := bytes.NewBuffer([]byte{})
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+
+	live := isTerminal(os.Stdout)
+	r := newTestReport()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			stderr.Write(append(scanner.Bytes(), '\n'))
+			continue
+		}
+		if ev.Action == "run" && live {
+			fmt.Printf("\r\033[K[rerun] running %s (%d passed, %d failed)", ev.Test, r.passed, len(r.failed))
+		}
+		r.add(ev)
+	}
+	if err := scanner.Err(); err != nil {
+		// go test blocks on a full pipe: read the rest. The events left
+		// are lost, its exit status still tells whether the tests passed.
+		fmt.Fprintf(stderr, "reading go test -json: %s\n", err)
+		io.Copy(ioutil.Discard, stdout)
+	}
+	err = cmd.Wait()
+	if live {
+		fmt.Print("\r\033[K")
+	}
+
+	testResults = r.results
+
+	if err != nil {
+		log("tests failed: %d passed, %d failed, %d skipped", r.passed, countTests(r.failed), r.skipped)
+		reportFailure("test", stderr.String()+r.failures())
+		return false, err
+	}
+
+	reportSuccess("test")
+	log("tests passed: %d passed, %d skipped", r.passed, r.skipped)
+	return true, nil
+}
+
+// testReport collects the events of a go test -json stream.
+type testReport struct {
+	output  map[string]*bytes.Buffer // by package and test
+	builds  map[string]*bytes.Buffer // compiler output by import path
+	failed  []string
+	broken  []string // import paths that didn't build
+	passed  int
+	skipped int
+	results []testResult
+}
+
+func newTestReport() *testReport {
+	return &testReport{output: map[string]*bytes.Buffer{}, builds: map[string]*bytes.Buffer{}}
+}
+
+// add takes an event. Since Go 1.24 the errors of building a test package
+// come as build-output and build-fail events of its import path.
+func (r *testReport) add(ev testEvent) {
+	key := ev.Package + " " + ev.Test
+	switch ev.Action {
+	case "build-output":
+		appendOutput(r.builds, ev.ImportPath, ev.Output)
+	case "build-fail":
+		r.broken = append(r.broken, ev.ImportPath)
+	case "output":
+		appendOutput(r.output, key, ev.Output)
+	case "pass", "fail", "skip":
+		if ev.Test == "" {
+			if ev.Action == "fail" {
+				r.failed = append(r.failed, key)
+			}
+			return
+		}
+		r.results = append(r.results, testResult{ev.Package, ev.Test, ev.Action})
+		switch ev.Action {
+		case "pass":
+			r.passed++
+		case "fail":
+			r.failed = append(r.failed, key)
+		case "skip":
+			r.skipped++
+		}
+		if ev.Action != "fail" {
+			delete(r.output, key)
+		}
+	}
+}
+
+// failures returns the compiler output of the packages that didn't build
+// and the output of the failed tests and packages.
+func (r *testReport) failures() string {
+	var out string
+	for _, path := range r.broken {
+		if buf := r.builds[path]; buf != nil {
+			out += buf.String()
+		}
+	}
+	for _, key := range r.failed {
+		if buf := r.output[key]; buf != nil {
+			out += buf.String()
+		}
+	}
+	return out
+}
+
+func appendOutput(m map[string]*bytes.Buffer, key, s string) {
+	if m[key] == nil {
+		m[key] = bytes.NewBuffer([]byte{})
+	}
+	m[key].WriteString(s)
+}
+
+// countTests counts the failures of single tests, leaving out those of
+// whole packages.
+func countTests(failed []string) (n int) {
+	for _, key := range failed {
+		if !strings.HasSuffix(key, " ") {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTestReport(t *testing.T) {
+	stream := `{"ImportPath":"example.com/p [example.com/p.test]","Action":"build-output","Output":"# example.com/p [example.com/p.test]\n"}
+{"ImportPath":"example.com/p [example.com/p.test]","Action":"build-output","Output":"./p.go:3:23: undefined: x\n"}
+{"ImportPath":"example.com/p [example.com/p.test]","Action":"build-fail"}
+{"Action":"start","Package":"example.com/p"}
+{"Action":"output","Package":"example.com/p","Output":"FAIL\texample.com/p [build failed]\n","OutputType":"frame"}
+{"Action":"fail","Package":"example.com/p","Elapsed":0,"FailedBuild":"example.com/p [example.com/p.test]"}
+{"Action":"start","Package":"example.com/q"}
+{"Action":"run","Package":"example.com/q","Test":"TestOK"}
+{"Action":"output","Package":"example.com/q","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"example.com/q","Test":"TestOK","Elapsed":0}
+{"Action":"run","Package":"example.com/q","Test":"TestBad"}
+{"Action":"output","Package":"example.com/q","Test":"TestBad","Output":"    q_test.go:9: got 1, want 2\n"}
+{"Action":"fail","Package":"example.com/q","Test":"TestBad","Elapsed":0}
+{"Action":"run","Package":"example.com/q","Test":"TestLater"}
+{"Action":"skip","Package":"example.com/q","Test":"TestLater","Elapsed":0}
+{"Action":"fail","Package":"example.com/q","Elapsed":0.01}`
+
+	r := newTestReport()
+	for _, line := range strings.Split(stream, "\n") {
+		var ev testEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+		r.add(ev)
+	}
+
+	if r.passed != 1 || countTests(r.failed) != 1 || r.skipped != 1 {
+		t.Errorf("passed, failed, skipped = %d, %d, %d, want 1, 1, 1", r.passed, countTests(r.failed), r.skipped)
+	}
+	if len(r.results) != 3 {
+		t.Errorf("%d results, want 3", len(r.results))
+	}
+	out := r.failures()
+	for _, want := range []string{"./p.go:3:23: undefined: x\n", "FAIL\texample.com/p [build failed]\n", "q_test.go:9: got 1, want 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("failures() = %q, missing %q", out, want)
+		}
+	}
+	if strings.Contains(out, "=== RUN   TestOK") {
+		t.Errorf("failures() = %q, has the output of a passed test", out)
+	}
+	if strings.Index(out, "undefined: x") > strings.Index(out, "[build failed]") {
+		t.Errorf("failures() = %q, the compiler errors come after the failure they caused", out)
+	}
+}
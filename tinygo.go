@@ -0,0 +1,160 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	use_tinygo    = flag.Bool("tinygo", false, "build with tinygo instead of the go command")
+	tinygo_target = flag.String("target", "", "tinygo target, e.g. wasm, wasip1 or a board such as pico, which is flashed on every change; implies --tinygo")
+)
+
+// wasmTargets are the tinygo targets that produce a file rather than
+// firmware for a board.
+var wasmTargets = map[string]bool{"wasm": true, "wasi": true, "wasip1": true, "wasip2": true, "wasm-unknown": true}
+
+// tinygoInfo is the part of the output of tinygo info -json rerun uses. It
+// decides which files the target builds.
+type tinygoInfo struct {
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	BuildTags []string `json:"build_tags"`
+}
+
+// tinygoTarget is set once tinygo builds the program.
+var tinygoTarget *tinygoInfo
+
+func tinygoEnabled() bool {
+	return *use_tinygo || *tinygo_target != ""
+}
+
+// setupTinygo replaces the install phase with tinygo build, or tinygo
+// flash for boards, which leaves nothing to run.
+func setupTinygo(t *target) error {
+	if !tinygoEnabled() {
+		return nil
+	}
+	if _, err := exec.LookPath("tinygo"); err != nil {
+		return fmt.Errorf("--tinygo: %s", err)
+	}
+
+	info, err := readTinygoInfo()
+	if err != nil {
+		return err
+	}
+	tinygoTarget = info
+	name := *tinygo_target
+	if name == "" {
+		name = "native"
+	}
+	log("tinygo target %s (%s/%s)", name, info.GOOS, info.GOARCH)
+
+	if *do_build {
+		log("--build doesn't apply with --tinygo, the install phase builds")
+		*do_build = false
+	}
+
+	switch {
+	case *tinygo_target == "":
+		install = func(buildpath string) (bool, error) {
+			return runTinygo("build", tinygoArgs("build", "-o", t.bin, buildpath))
+		}
+	case wasmTargets[*tinygo_target]:
+		bin, err := filepath.Abs(statePath("tinygo/" + filepath.Base(t.bin) + ".wasm"))
+		if err != nil {
+			return err
+		}
+		t.bin = bin
+		install = func(buildpath string) (bool, error) {
+			if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+				return false, err
+			}
+			return runTinygo("build", tinygoArgs("build", "-o", bin, buildpath))
+		}
+		if *run_cmd == "" && !*no_run {
+			log("building %s, set --run-cmd to run it, e.g. \"wasmtime {{.Bin}}\"", bin)
+			*no_run = true
+		}
+	default:
+		// The firmware goes to the board, there is no binary.
+		t.bin = ""
+		install = func(buildpath string) (bool, error) {
+			return runTinygo("flash", tinygoArgs("flash", buildpath))
+		}
+		log("flashing %s on every change", *tinygo_target)
+		*no_run = true
+	}
+	return nil
+}
+
+// readTinygoInfo asks tinygo for the GOOS, GOARCH and build tags of the
+// target.
+func readTinygoInfo() (*tinygoInfo, error) {
+	args := []string{"info", "-json"}
+	if *tinygo_target != "" {
+		args = append(args, "-target", *tinygo_target)
+	}
+	cmd := exec.Command("tinygo", args...)
+	cmd.Env = toolBase()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("tinygo info: %s", msg)
+		}
+		return nil, fmt.Errorf("tinygo info: %s", err)
+	}
+
+	var info tinygoInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("tinygo info: %s", err)
+	}
+	return &info, nil
+}
+
+// tinygoArgs inserts --target and --build-flags after the tinygo
+// subcommand. -race doesn't exist there.
+func tinygoArgs(verb string, args ...string) []string {
+	words := []string{verb}
+	if *tinygo_target != "" {
+		words = append(words, "-target", *tinygo_target)
+	}
+	extra, err := splitWords(*build_flags)
+	if err != nil {
+		log("--build-flags: %s", err)
+	}
+	return append(append(words, extra...), args...)
+}
+
+// runTinygo runs tinygo as phase and reports the result.
+func runTinygo(phase string, args []string) (bool, error) {
+	cmd := exec.Command("tinygo", args...)
+	cmd.Env = toolBase()
+	recordCommand("install", cmd)
+
+	buf := bytes.NewBuffer([]byte{})
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+
+	if err := cmd.Run(); err != nil {
+		log("%s failed", phase)
+		reportFailure(phase, buf.String())
+		return false, err
+	}
+
+	reportSuccess(phase)
+	log("%s succeeded", phase)
+	return true, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var no_title = flag.Bool("no-title", false, "don't show the build state in the title of the terminal window or tab")
+
+var title struct {
+	sync.Mutex
+	pushed bool // the title before rerun is on the terminal's stack
+	last   string
+}
+
+// setTitle shows the state in the title of the terminal, as in
+// "rerun: OK (pid 1234)". The title rerun found is restored on exit, by
+// terminals that keep a stack of titles.
+func setTitle(format string, args ...interface{}) {
+	if *no_title || !isTerminal(os.Stdout) || os.Getenv("TERM") == "dumb" {
+		return
+	}
+	s := "rerun: " + fmt.Sprintf(format, args...)
+	// Control characters would end the sequence early.
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+
+	title.Lock()
+	defer title.Unlock()
+	if !title.pushed {
+		title.pushed = true
+		fmt.Print("\033[22;0t")
+		atExit(func() {
+			fmt.Print("\033[23;0t")
+		})
+	}
+	if s != title.last {
+		title.last = s
+		fmt.Printf("\033]0;%s\007", s)
+	}
+}
+
+// titleCycle shows the end of a cycle. The program a good cycle starts
+// shows up with titleRunning.
+func titleCycle(ok bool) {
+	launchMu.Lock()
+	pid := childPid
+	launchMu.Unlock()
+	switch {
+	case ok && pid != 0:
+		setTitle("OK (pid %d)", pid)
+	case ok:
+		setTitle("OK")
+	case isStale() && pid != 0:
+		setTitle("FAILED (stale pid %d)", pid)
+	default:
+		setTitle("FAILED")
+	}
+}
+
+// titleRunning shows the program that was just started.
+func titleRunning(pid int) {
+	if isStale() {
+		setTitle("FAILED (stale pid %d)", pid)
+	} else {
+		setTitle("OK (pid %d)", pid)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var go_version = flag.String("go", "", "Go version for all toolchain commands, e.g. 1.22.3")
+
+var (
+	// gotool is the go command used, if it isn't the one in --goexec.
+	gotool string
+
+	// toolEnv is added to the environment of the go command.
+	toolEnv []string
+)
+
+// gocmd returns a go command with the selected toolchain.
+func gocmd(args ...string) *exec.Cmd {
+	name := *goexec + "go"
+	if gotool != "" {
+		name = gotool
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(append(toolBase(), toolEnv...), crossEnv...)
+	return cmd
+}
+
+var versionRe = regexp.MustCompile(`^1\.\d+(\.\d+|rc\d+|beta\d+)?$`)
+
+// selectToolchain applies --go. A golang.org/dl wrapper such as go1.22.3
+// in the PATH is preferred, otherwise the go command is asked to switch
+// toolchains with GOTOOLCHAIN, which needs Go 1.21 or later.
+func selectToolchain() error {
+	if *go_version == "" {
+		return nil
+	}
+
+	version := strings.TrimPrefix(*go_version, "go")
+	if !versionRe.MatchString(version) {
+		return fmt.Errorf("invalid Go version %q", *go_version)
+	}
+	name := "go" + version
+
+	if p, err := exec.LookPath(name); err == nil {
+		if out, err := exec.Command(p, "version").CombinedOutput(); err != nil {
+			log("%s: %s", name, strings.TrimSpace(string(out)))
+			log("downloading %s", name)
+			cmd := exec.Command(p, "download")
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("%s download: %s", name, err)
+			}
+		}
+		gotool = p
+	} else {
+		toolEnv = []string{"GOTOOLCHAIN=" + name}
+	}
+
+	out, err := gocmd("version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go%s: %s: %s", version, err, strings.TrimSpace(string(out)))
+	}
+	if !strings.Contains(string(out), name+" ") {
+		return fmt.Errorf("asked for %s, got %s", name, strings.TrimSpace(string(out)))
+	}
+	log("using %s", strings.TrimSpace(string(out)))
+	return nil
+}
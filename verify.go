@@ -0,0 +1,58 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	verify   = flag.String("verify", "", "command template that checks the built binary before the restart, e.g. \"! go tool nm {{.Bin}} | grep -q pprof\"")
+	max_size = flag.String("max-size", "", "don't restart the program when the binary is larger than this, e.g. 20MB")
+)
+
+// verifyBinary checks the binary of t against --max-size and --verify. A
+// failure keeps the previous program running.
+func verifyBinary(t *target) (bool, error) {
+	if *max_size != "" {
+		limit, err := parseSize(*max_size)
+		if err != nil {
+			log("--max-size: %s", err)
+			return false, err
+		}
+		fi, err := os.Stat(t.bin)
+		if err != nil {
+			log("verify failed")
+			fmt.Fprintln(console, err)
+			return false, err
+		}
+		if fi.Size() > limit {
+			log("verify failed")
+			fmt.Fprintf(console, "binary is %s, --max-size is %s\n", formatSize(fi.Size()), formatSize(limit))
+			return false, errors.New("binary too large")
+		}
+	}
+
+	if *verify != "" {
+		s, err := expandTemplate(*verify, newRunData(t.bin, t.dir, nil).quoted())
+		if err != nil {
+			log("--verify: %s", err)
+			return false, err
+		}
+		out, err := shellCommand(s).CombinedOutput()
+		if err != nil {
+			log("verify failed")
+			reportFailure("verify", string(out))
+			return false, err
+		}
+		reportSuccess("verify")
+	}
+
+	log("verify passed")
+	return true, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	vuln        = flag.Bool("vuln", false, "run govulncheck when go.mod or go.sum change and report new vulnerabilities")
+	vuln_strict = flag.Bool("vuln-strict", false, "with --vuln, don't restart the program when new vulnerabilities are found")
+)
+
+var (
+	// knownVulns are the vulnerabilities reported by the last check.
+	knownVulns map[string]bool
+	vulnTool   string
+)
+
+// vulnMessage is a message of the govulncheck -json stream.
+type vulnMessage struct {
+	OSV *struct {
+		ID      string `json:"id"`
+		Summary string `json:"summary"`
+	} `json:"osv"`
+	Finding *struct {
+		OSV          string `json:"osv"`
+		FixedVersion string `json:"fixed_version"`
+		Trace        []struct {
+			Module   string `json:"module"`
+			Version  string `json:"version"`
+			Function string `json:"function"`
+		} `json:"trace"`
+	} `json:"finding"`
+}
+
+// depsChanged reports whether the dependencies may have changed: at the
+// first check, when go.mod or go.sum were modified, and when the whole tree
+// may have, as after a branch switch, which changed is nil for.
+func depsChanged(changed []string) bool {
+	if knownVulns == nil || changed == nil {
+		return true
+	}
+	for _, p := range changed {
+		if base := filepath.Base(p); base == "go.mod" || base == "go.sum" {
+			return true
+		}
+	}
+	return false
+}
+
+// govulncheck checks the dependencies of buildpath for vulnerabilities in
+// the code the program calls, and reports those that the previous check
+// didn't find.
+func govulncheck(buildpath string) (bool, error) {
+	if vulnTool == "" {
+		p, err := exec.LookPath("govulncheck")
+		if err != nil {
+			log("--vuln: govulncheck not found, install it with: go install golang.org/x/vuln/cmd/govulncheck@latest")
+			*vuln = false
+			return true, nil
+		}
+		vulnTool = p
+	}
+
+	cmd := exec.Command(vulnTool, "-json", buildpath)
+	cmd.Env = append(toolEnviron(), toolEnv...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		return vulnFailed(strings.TrimSpace(stderr.String()), err)
+	}
+
+	summaries := map[string]string{}
+	found := map[string]string{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m vulnMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return vulnFailed(err.Error(), err)
+		}
+		if m.OSV != nil {
+			summaries[m.OSV.ID] = m.OSV.Summary
+		}
+		// Only findings that reach a function are called by the program.
+		if f := m.Finding; f != nil && len(f.Trace) > 0 && f.Trace[0].Function != "" {
+			fix := "no fix"
+			if f.FixedVersion != "" {
+				fix = "fixed in " + f.FixedVersion
+			}
+			found[f.OSV] = fmt.Sprintf("%s@%s, %s", f.Trace[0].Module, f.Trace[0].Version, fix)
+		}
+	}
+
+	first := knownVulns == nil
+	var added []string
+	for id := range found {
+		if !knownVulns[id] {
+			added = append(added, id)
+		}
+	}
+	sort.Strings(added)
+
+	known := map[string]bool{}
+	for id := range found {
+		known[id] = true
+	}
+	knownVulns = known
+
+	if len(added) == 0 {
+		log("vuln check passed, %d known", len(found))
+		return true, nil
+	}
+	for _, id := range added {
+		log("vulnerability %s: %s (%s)", id, strings.TrimSpace(summaries[id]), found[id])
+	}
+	// The first check finds what was there before; only later ones can
+	// block.
+	if *vuln_strict && !first {
+		log("vuln check failed")
+		return false, errors.New("new vulnerabilities")
+	}
+	return true, nil
+}
+
+// vulnFailed handles a check that didn't finish, as without network access.
+// Only --vuln-strict holds the restart for it; otherwise the dependencies
+// count as checked, and the next change of go.mod or go.sum checks again.
+func vulnFailed(out string, err error) (bool, error) {
+	if *vuln_strict {
+		log("vuln check failed")
+		reportFailure("vuln", out)
+		return false, err
+	}
+	if out == "" {
+		out = err.Error()
+	}
+	log("vuln check failed, restarting anyway: %s", lastLines([]byte(out), 1))
+	if knownVulns == nil {
+		knownVulns = map[string]bool{}
+	}
+	return true, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var warm = flag.Bool("warm", false, "build every package of the module in the background while idle, so rebuilds hit a hot build cache")
+
+// warmIdle is how long rerun waits after a cycle before warming the cache.
+const warmIdle = 3 * time.Second
+
+var warmer struct {
+	sync.Mutex
+	timer *time.Timer
+	cmd   *exec.Cmd
+	dir   string
+}
+
+// scheduleWarm starts the background build once rerun has been idle for a
+// while after a cycle. The first build after the start or a dependency
+// change is noted because it takes longer.
+func scheduleWarm(note bool) {
+	if !*warm {
+		return
+	}
+	warmer.Lock()
+	defer warmer.Unlock()
+	if warmer.dir == "" {
+		warmer.dir, _ = os.Getwd()
+		if inModule() {
+			warmer.dir = filepath.Dir(goEnv("GOMOD"))
+		}
+	}
+	warmer.timer = time.AfterFunc(warmIdle, func() {
+		warmBuild(note)
+	})
+}
+
+func warmBuild(note bool) {
+	cmd := gocmd(goArgs("build", "-o", os.DevNull, "./...")...)
+	warmer.Lock()
+	cmd.Dir = warmer.dir
+	if warmer.timer == nil || warmer.cmd != nil {
+		warmer.Unlock()
+		return
+	}
+	warmer.timer = nil
+	if err := cmd.Start(); err != nil {
+		warmer.Unlock()
+		log("--warm: %s", err)
+		return
+	}
+	warmer.cmd = cmd
+	warmer.Unlock()
+
+	if note {
+		log("warming the build cache")
+	}
+	start := time.Now()
+	err := cmd.Wait()
+
+	warmer.Lock()
+	defer warmer.Unlock()
+	if warmer.cmd != cmd {
+		return // stopped by a cycle
+	}
+	warmer.cmd = nil
+	if err == nil && note {
+		log("build cache warm in %s", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// stopWarm cancels the background build, which would only slow down the
+// cycle that is starting.
+func stopWarm() {
+	warmer.Lock()
+	defer warmer.Unlock()
+	if warmer.timer != nil {
+		warmer.timer.Stop()
+		warmer.timer = nil
+	}
+	if warmer.cmd != nil {
+		warmer.cmd.Process.Kill()
+		warmer.cmd = nil
+	}
+}
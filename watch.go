@@ -0,0 +1,449 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shallow limits the watch to the files directly inside the root.
+var shallow bool
+
+// A scanCallback is called with the files that changed since the last
+// call.
+type scanCallback func(changed []string)
+
+// skipped reports whether p, found while watching root, is left out of the
+// watch.
+func skipped(root, p string, isDir bool) bool {
+	return skipReason(root, p, isDir) != ""
+}
+
+// skipReason names the rule that leaves p out of the watch, if any.
+func skipReason(root, p string, isDir bool) string {
+	if shallow && isDir && p != root {
+		return "only files next to the script are watched"
+	}
+	if *no_git && isDir && p == filepath.Join(root, ".git") {
+		return "--no-git"
+	}
+	if isDir && filepath.Base(p) == stateDir {
+		return stateDir + " holds rerun's own files"
+	}
+	if reason := bazelReason(root, p); reason != "" {
+		return reason
+	}
+	if isDir {
+		if reason := castReason(p); reason != "" {
+			return reason
+		}
+	}
+	if r := dirRuleFor(p); r != nil {
+		if reason := r.skip(p, isDir); reason != "" {
+			return reason
+		}
+	}
+	if reason := currentRules().skipRule(p, isDir); reason != "" {
+		return reason
+	}
+	if !isDir && *generated != "" {
+		for _, pattern := range strings.Split(*generated, ",") {
+			if match, _ := path.Match(pattern, path.Base(p)); match {
+				return "--generated " + pattern
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	writtenMu sync.Mutex
+	written   = map[string]time.Time{}
+)
+
+// A treeSnapshot holds the modification times and sizes of files. Changes
+// are found by comparing a file with its last state, never with rerun's
+// clock, which NTP, the time zone or a suspend can move.
+type treeSnapshot map[string]fileState
+
+// update records the state of the file of info and reports whether it
+// differs from the one recorded before, or none was.
+func (s treeSnapshot) update(p string, info os.FileInfo) bool {
+	if s.same(p, info) {
+		return false
+	}
+	s[p] = fileState{mtime: info.ModTime(), size: info.Size()}
+	return true
+}
+
+// same reports whether the file of info is in the state recorded for p.
+func (s treeSnapshot) same(p string, info os.FileInfo) bool {
+	old, ok := s[p]
+	return ok && old.mtime.Equal(info.ModTime()) && old.size == info.Size()
+}
+
+// snapshotPaths records the state of the changed paths a cycle starts
+// with, for cycleWrite.
+func snapshotPaths(paths []string) treeSnapshot {
+	snap := treeSnapshot{}
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			snap.update(p, info)
+		}
+	}
+	return snap
+}
+
+// snapshotWrites records the files below root before the hooks of a cycle
+// run, for recordWrites. It is nil when no hook writes to the tree.
+func snapshotWrites(root string) treeSnapshot {
+	if *before == "" && *on_branch_switch == "" && !*do_generate && len(links) == 0 && len(conf.Dirs) == 0 {
+		return nil
+	}
+	snap := treeSnapshot{}
+	walkWatched(root, func(p string, info os.FileInfo) {
+		snap.update(p, info)
+	})
+	return snap
+}
+
+// walkWatched calls fn with the files below root that aren't skipped.
+func walkWatched(root string, fn func(p string, info os.FileInfo)) {
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if skipped(root, p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			fn(p, info)
+		}
+		return nil
+	})
+}
+
+// recordWrites remembers the files below root that the hooks of the cycle
+// modified or created, as told by the snapshot taken before them, and
+// those go generate rewrote unchanged, so the writes don't start another
+// cycle. A later modification of the same files does.
+func recordWrites(root string, before treeSnapshot) {
+	if before == nil {
+		return
+	}
+
+	m := map[string]time.Time{}
+	walkWatched(root, func(p string, info os.FileInfo) {
+		if before.update(p, info) {
+			m[p] = info.ModTime()
+		}
+	})
+
+	for p, mtime := range takeUnchanged() {
+		m[p] = mtime
+	}
+
+	writtenMu.Lock()
+	written = m
+	writtenMu.Unlock()
+}
+
+// selfWritten reports whether the modification of p at mtime was made by
+// the hooks of the last cycle.
+func selfWritten(p string, mtime time.Time) bool {
+	writtenMu.Lock()
+	defer writtenMu.Unlock()
+	t, ok := written[p]
+	return ok && t.Equal(mtime)
+}
+
+// cycleWrite explains why p, reported while a cycle ran, isn't a change for
+// the next cycle: the hooks of the cycle wrote it, go build left the binary
+// in the working directory, or the file is as started, the files the cycle
+// started with, holds it, a late event of the change that started it. It is
+// "" for an edit made meanwhile.
+func cycleWrite(p string, started treeSnapshot) string {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return ""
+	}
+	if selfWritten(p, fi.ModTime()) {
+		return "written by the hooks of the last cycle"
+	}
+	if *do_build && current.t != nil && !fi.IsDir() {
+		wd, _ := os.Getwd()
+		if abs, _ := filepath.Abs(p); abs == filepath.Join(wd, filepath.Base(current.t.bin)) {
+			return "the binary go build wrote"
+		}
+	}
+	if started.same(p, fi) {
+		return "not modified since the cycle started"
+	}
+	return ""
+}
+
+// requeue moves the changes that arrived on changes while a cycle ran into
+// b, for the next cycle, but for those the cycle made itself. started holds
+// the files the cycle started with.
+func requeue(changes chan string, b *batch, started treeSnapshot) {
+	for {
+		select {
+		case p, ok := <-changes:
+			if !ok {
+				return
+			}
+			if reason := cycleWrite(p, started); reason != "" {
+				explainf(p, time.Now(), "changed while a cycle ran, %s", reason)
+				continue
+			}
+			b.add(p, time.Now())
+		default:
+			return
+		}
+	}
+}
+
+// priority reports whether p matches one of the configured priority
+// files. Patterns with a slash match the path relative to root, the others
+// match the base name.
+func priority(root, p string) bool {
+	for _, pattern := range conf.Priority {
+		name := path.Base(p)
+		if strings.Contains(pattern, "/") {
+			if rel, err := filepath.Rel(root, p); err == nil {
+				name = filepath.ToSlash(rel)
+			}
+		}
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+var flood = flag.Int("flood", 500, "when this many files change at once, wait until the changes settle and rebuild once")
+
+// floodQuiet is how long a flood of changes must have stopped before the
+// batch is ready. Floods are counted over floodWindow, across batches.
+const (
+	floodQuiet  = 2 * time.Second
+	floodWindow = time.Second
+)
+
+// A batch collects changed files until the tree has been quiet for
+// --debounce. A change to a priority file makes the batch ready at once,
+// unless the batch is a flood, as on a branch switch.
+type batch struct {
+	root   string
+	paths  []string
+	stamps map[string]time.Time
+	last   time.Time
+	urgent bool
+	flood  bool
+
+	// settle is the minimum quiet time, for watchers that see a burst of
+	// changes one by one.
+	settle time.Duration
+
+	window time.Time // start of the current flood window
+	count  int       // changes in the window
+}
+
+func newBatch(root string) *batch {
+	return &batch{root: root, stamps: map[string]time.Time{}}
+}
+
+// add records a change of p. Stamp tells repeated reports of the same
+// change apart from new ones.
+func (b *batch) add(p string, stamp time.Time) {
+	prev, ok := b.stamps[p]
+	if ok && prev.Equal(stamp) {
+		return
+	}
+	if !ok {
+		b.paths = append(b.paths, p)
+	}
+	b.stamps[p] = stamp
+	b.last = time.Now()
+	if b.last.Sub(b.window) > floodWindow {
+		b.window, b.count = b.last, 0
+	}
+	b.count++
+	if n := len(b.paths); !b.flood && *flood > 0 && (n >= *flood || b.count >= *flood) {
+		if b.count > n {
+			n = b.count
+		}
+		b.flood = true
+		log("%d files changed, waiting for the changes to settle", n)
+	}
+	if priority(b.root, p) {
+		b.urgent = true
+	}
+	if !b.urgent && *debounce > 0 {
+		explainf(p, stamp, "waiting until files are quiet for --debounce %s", *debounce)
+	}
+}
+
+func (b *batch) ready() bool {
+	if len(b.paths) == 0 {
+		return false
+	}
+	quiet := *debounce
+	if quiet < b.settle {
+		quiet = b.settle
+	}
+	if b.flood {
+		if quiet < floodQuiet {
+			quiet = floodQuiet
+		}
+		return time.Since(b.last) >= quiet
+	}
+	return b.urgent || time.Since(b.last) >= quiet
+}
+
+// flush hands the collected files to cb and starts a new batch. Files that
+// grew past --max-file-size while they were written are dropped here.
+func (b *batch) flush(cb scanCallback) {
+	paths := dropLarge(b.paths)
+	if b.flood {
+		log("%d files changed, rebuilding once", len(paths))
+	}
+	b.paths = nil
+	b.stamps = map[string]time.Time{}
+	b.urgent = false
+	b.flood = false
+	if len(paths) > 0 {
+		cb(paths)
+	}
+}
+
+// errGone is returned by the watchers when the watched directory is
+// removed or renamed.
+var errGone = errors.New("watched directory disappeared")
+
+func scanChanges(dir string, cb scanCallback) error {
+	log("watching: %s", dir)
+
+	return pollTrees(dir, []string{dir}, nil, cb)
+}
+
+// pollTrees walks the given subtrees of root every --poll-interval and calls cb
+// with the files modified since the last call. It returns errGone once
+// root no longer exists, and nil once stop is closed.
+func pollTrees(root string, trees []string, stop <-chan struct{}, cb scanCallback) error {
+	b := newBatch(root)
+	states := treeSnapshot{}
+	listings := map[string]string{}
+
+	// walk compares the trees with states. The first walk only records
+	// them.
+	walk := func(baseline bool) {
+		seen := map[string]bool{}
+		defer func() {
+			// Forget removed files; those of skipped directories are only
+			// walked for --explain.
+			for p := range states {
+				if !seen[p] && !exists(p) {
+					delete(states, p)
+				}
+			}
+		}()
+		for _, tree := range trees {
+			filepath.Walk(tree, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if reason := skipReason(root, p, info.IsDir()); reason != "" {
+					if info.IsDir() {
+						explainTree(p, states, baseline, reason)
+						return filepath.SkipDir
+					}
+					seen[p] = true
+					if states.update(p, info) && !baseline {
+						explainf(p, info.ModTime(), "%s", reason)
+					}
+					return nil
+				}
+
+				seen[p] = true
+				changed := states.update(p, info)
+				if info.IsDir() {
+					prev, seen := listings[p]
+					if seen && !changed {
+						return nil
+					}
+					listings[p] = watchedNames(root, p)
+					if !seen || listings[p] == prev {
+						if seen {
+							explainf(p, info.ModTime(), "only ignored entries were added or removed")
+						}
+						return nil
+					}
+				} else if !changed || baseline {
+					return nil
+				}
+				if reason := cycleWrite(p, nil); reason != "" {
+					explainf(p, info.ModTime(), "%s", reason)
+					return nil
+				}
+				if reason := constraintReason(p); reason != "" {
+					explainf(p, info.ModTime(), "%s", reason)
+					return nil
+				}
+				b.add(p, info.ModTime())
+				return nil
+			})
+		}
+	}
+
+	walk(true)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(*poll_interval):
+		}
+		if !exists(root) {
+			return errGone
+		}
+		walk(false)
+		if b.ready() {
+			b.flush(cb)
+		}
+	}
+}
+
+// watchedNames lists the entries of dir that aren't skipped, so a change of
+// the directory that only touched ignored files can be told apart.
+func watchedNames(root, dir string) string {
+	infos, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, info := range infos {
+		if skipped(root, filepath.Join(dir, info.Name()), info.IsDir()) {
+			continue
+		}
+		if !info.IsDir() && (constraintReason(filepath.Join(dir, info.Name())) != "" || sizeReason(info.Name(), info.Size()) != "") {
+			continue
+		}
+		names = append(names, info.Name())
+	}
+	return strings.Join(names, "/")
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
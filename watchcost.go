@@ -0,0 +1,92 @@
+// Copyright 2013 The rerun AUTHORS. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A subtree is a top-level directory of the watch root, as counted by
+// reportWatchCost.
+type subtree struct {
+	name    string
+	dirs    int
+	files   int
+	goFiles int
+}
+
+// reportWatchCost walks the watch set of root once and prints its size and
+// how long the walk took. Large or slow trees also get their heaviest
+// subtrees, with a hint for the ones that have no Go files.
+func reportWatchCost(root string) {
+	start := time.Now()
+	trees := map[string]*subtree{}
+	dirs, files := 0, 0
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if skipped(root, p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		name := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		if rel == "." || (!info.IsDir() && name == filepath.ToSlash(rel)) {
+			name = "."
+		}
+		st := trees[name]
+		if st == nil {
+			st = &subtree{name: name}
+			trees[name] = st
+		}
+		if info.IsDir() {
+			dirs++
+			st.dirs++
+		} else {
+			files++
+			st.files++
+			if strings.HasSuffix(p, ".go") {
+				st.goFiles++
+			}
+		}
+		return nil
+	})
+	took := time.Since(start)
+	log("watch set: %d directories, %d files, scanned in %s", dirs, files, took.Round(time.Millisecond))
+
+	if files < 2000 && took < 500*time.Millisecond {
+		return
+	}
+	var list []*subtree
+	for _, st := range trees {
+		if st.name != "." {
+			list = append(list, st)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].files+list[i].dirs > list[j].files+list[j].dirs
+	})
+	if len(list) > 3 {
+		list = list[:3]
+	}
+	for _, st := range list {
+		share := 100 * (st.files + st.dirs) / (files + dirs)
+		if share < 10 {
+			break
+		}
+		hint := ""
+		if st.goFiles == 0 {
+			hint = ", no Go files: --ignore " + st.name + " leaves it out"
+		}
+		log("  %s: %d directories, %d files (%d%%)%s", st.name, st.dirs, st.files, share, hint)
+	}
+}